@@ -13,6 +13,7 @@ Internal definitions may change without notice.
 load(
     "//internal:rules.bzl",
     _go_binary = "go_binary",
+    _go_embed_data = "go_embed_data",
     _go_library = "go_library",
     _go_test = "go_test",
 )
@@ -26,6 +27,7 @@ load(
 )
 
 go_binary = _go_binary
+go_embed_data = _go_embed_data
 go_library = _go_library
 go_test = _go_test
 go_toolchain = _go_toolchain
@@ -2,7 +2,7 @@
 # a downloaded Go distribution.
 
 load("@rules_go_simple//:def.bzl", "go_toolchain")
-load("@rules_go_simple//internal:rules.bzl", "go_tool_binary")
+load("@rules_go_simple//internal:rules.bzl", "go_builder_binary", "go_tool_binary")
 
 # tools contains executable files that are part of the toolchain.
 filegroup(
@@ -21,6 +21,13 @@ filegroup(
     visibility = ["//visibility:public"],
 )
 
+# headers contains files that assembly sources may include, like textflag.h.
+filegroup(
+    name = "headers",
+    srcs = glob(["pkg/include/**"]),
+    visibility = ["//visibility:public"],
+)
+
 # builder is an executable used by rules_go_simple to perform most actions.
 # builder mostly acts as a wrapper around the compiler and linker.
 go_tool_binary(
@@ -30,11 +37,24 @@ go_tool_binary(
     tools = [":tools"],
 )
 
+# builder_stage2 is the builder, rebuilt by :builder using the builder's own
+# compile and link actions. It rebuilds itself once more to check that the
+# result is reproducible. The toolchain uses it when go_download is called
+# with self_hosted_builder = True.
+go_builder_binary(
+    name = "builder_stage2",
+    srcs = ["@rules_go_simple//internal/builder:builder_srcs"],
+    bootstrap = ":builder",
+    std_pkgs = [":std_pkgs"],
+    tools = [":tools"],
+)
+
 # toolchain_impl gathers information about the Go toolchain.
 # See the GoToolchain provider.
 go_toolchain(
     name = "toolchain_impl",
-    builder = ":builder",
+    builder = "{builder}",
+    headers = [":headers"],
     std_pkgs = [":std_pkgs"],
     tools = [":tools"],
 )
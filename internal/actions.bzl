@@ -10,35 +10,122 @@ call functions to create actions. This allows action code to be shared
 by multiple rules.
 """
 
-def go_compile(ctx, srcs, out, importpath = "", deps = []):
+def go_compile(
+        ctx,
+        srcs,
+        out,
+        importpath = "",
+        deps = [],
+        export = None,
+        objdir = None,
+        metadata = None,
+        abi = None,
+        asm_out = None,
+        forbid = [],
+        allowed_imports = [],
+        denied_imports = [],
+        optimization = "",
+        inline = True):
     """Compiles a single Go package from sources.
 
     Args:
         ctx: analysis context.
-        srcs: list of source Files to be compiled.
+        srcs: list of source Files to be compiled. May include assembly
+            (.s) files and headers (.h) they include.
         out: output .a File.
         importpath: the path other libraries may use to import this package.
         deps: list of GoLibraryInfo objects for direct dependencies.
+        export: optional output File. If set, an archive containing only the
+            package's export data is written here.
+        objdir: optional output directory (from declare_directory). If set,
+            the object files in the archive are written here.
+        metadata: optional output File. If set, a JSON file describing the
+            compiled package is written here.
+        abi: optional output File. If set, digests of the export data each
+            import was compiled against are written here. go_link checks
+            them.
+        asm_out: optional output File. If set, the compiler's assembly
+            listing for the package is written here.
+        forbid: list of features the sources may not use: "cgo",
+            "linkname", or "unsafe".
+        allowed_imports: list of import path patterns like "net" or
+            "net/...". If not empty, every import must match one.
+        denied_imports: list of import path patterns the sources may not
+            import. These take precedence over allowed_imports.
+        optimization: optimization level: "0", "1", "2", or "s". If empty,
+            the level depends on --compilation_mode.
+        inline: whether the compiler may inline functions.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
     args = ctx.actions.args()
     args.add("compile")
+    args.add("-compiler", toolchain.internal.compiler)
+    args.add("-assembler", toolchain.internal.assembler)
+    args.add("-packer", toolchain.internal.packer)
     args.add("-stdimportcfg", toolchain.internal.stdimportcfg)
+    if toolchain.internal.goexperiment:
+        args.add("-goexperiment", toolchain.internal.goexperiment)
+    if toolchain.internal.tool_retries:
+        args.add("-tool-retries", str(toolchain.internal.tool_retries))
+    if toolchain.internal.std_overlap != "warn":
+        args.add("-std-overlap", toolchain.internal.std_overlap)
+    if toolchain.internal.std_allowlist:
+        args.add("-std-allowlist", toolchain.internal.std_allowlist)
+    diag_outputs = _add_diag_args(ctx, args, out)
+    diag_outputs += _add_crash_report(ctx, args, out)
     dep_infos = [d.info for d in deps]
-    args.add_all(dep_infos, before_each = "-arc", map_each = _format_arc)
+    transitive_deps = depset(
+        direct = dep_infos,
+        transitive = [d.deps for d in deps],
+    )
+    deps_manifest = _add_deps_manifest(ctx, args, out, dep_infos, transitive_deps)
+    if not deps_manifest:
+        args.add_all(dep_infos, before_each = "-arc", map_each = _format_export_arc)
+        args.add_all(transitive_deps, before_each = "-deplabel", map_each = _format_dep_label)
+        args.add_all(transitive_deps, before_each = "-depedge", map_each = _format_dep_edge)
     if importpath:
         args.add("-p", importpath)
+    args.add("-label", str(ctx.label))
+    if forbid:
+        args.add_joined("-forbid", forbid, join_with = ",")
+    args.add_all(allowed_imports, before_each = "-allow-import")
+    args.add_all(denied_imports, before_each = "-deny-import")
+    _add_opt_args(ctx, args, optimization, inline)
+    args.add_all(srcs, before_each = "-srcmap", map_each = _format_srcmap)
     args.add("-o", out)
+    outputs = [out] + diag_outputs
+    if export:
+        args.add("-export", export)
+        outputs.append(export)
+    if objdir:
+        args.add("-objdir", objdir.path)
+        outputs.append(objdir)
+    if metadata:
+        args.add("-metadata", metadata)
+        outputs.append(metadata)
+    if abi:
+        args.add("-abi", abi)
+        outputs.append(abi)
+    if asm_out:
+        args.add("-emit-asm", asm_out)
+        outputs.append(asm_out)
+    dep_files = [_export_file(dep.info) for dep in deps]
+    digests = _add_input_digests(ctx, args, out, srcs + dep_files)
     args.add_all(srcs)
 
     inputs = (srcs +
-              [dep.info.archive for dep in deps] +
+              dep_files +
+              digests +
+              deps_manifest +
               [toolchain.internal.stdimportcfg] +
               toolchain.internal.tools +
-              toolchain.internal.std_pkgs)
+              toolchain.internal.std_pkgs +
+              toolchain.internal.headers)
+    if toolchain.internal.std_allowlist:
+        inputs.append(toolchain.internal.std_allowlist)
     ctx.actions.run(
-        outputs = [out],
+        outputs = outputs,
         inputs = inputs,
         executable = toolchain.internal.builder,
         arguments = [args],
@@ -46,7 +133,7 @@ def go_compile(ctx, srcs, out, importpath = "", deps = []):
         mnemonic = "GoCompile",
     )
 
-def go_link(ctx, out, main, deps = []):
+def go_link(ctx, out, main, deps = [], x_defs = {}, stamp = False, main_abi = None, link_map = None):
     """Links a Go executable.
 
     Args:
@@ -54,27 +141,66 @@ def go_link(ctx, out, main, deps = []):
         out: output executable file.
         main: archive file for the main package.
         deps: list of GoLibraryInfo objects for direct dependencies.
+        x_defs: dict mapping qualified names of string variables
+            (packagepath.name) to the values they should be set to.
+        stamp: whether to append information about the toolchain and
+            target platform to the executable.
+        main_abi: optional ABI File written when the main package was
+            compiled. The ABI files of the main package and dependencies
+            are checked against the archives being linked.
+        link_map: optional JSON File to write describing the executable's
+            package init order and symbols.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
+    direct_deps = [d.info for d in deps]
     transitive_deps = depset(
-        direct = [d.info for d in deps],
+        direct = direct_deps,
         transitive = [d.deps for d in deps],
     )
+    indirect_deps = depset(transitive = [d.deps for d in deps])
+    dep_files = [d.archive for d in transitive_deps.to_list()]
     inputs = ([main, toolchain.internal.stdimportcfg] +
-              [d.archive for d in transitive_deps.to_list()] +
+              dep_files +
+              [d.abi for d in transitive_deps.to_list() if d.abi] +
               toolchain.internal.tools +
               toolchain.internal.std_pkgs)
+    if main_abi:
+        inputs.append(main_abi)
 
     args = ctx.actions.args()
     args.add("link")
+    inputs += _add_input_digests(ctx, args, out, [main] + dep_files)
+    args.add("-linker", toolchain.internal.linker)
     args.add("-stdimportcfg", toolchain.internal.stdimportcfg)
-    args.add_all(transitive_deps, before_each = "-arc", map_each = _format_arc)
+    if toolchain.internal.goexperiment:
+        args.add("-goexperiment", toolchain.internal.goexperiment)
+    if toolchain.internal.tool_retries:
+        args.add("-tool-retries", str(toolchain.internal.tool_retries))
+    if toolchain.internal.std_overlap != "warn":
+        args.add("-std-overlap", toolchain.internal.std_overlap)
+    deps_manifest = _add_deps_manifest(ctx, args, out, direct_deps, transitive_deps)
+    inputs += deps_manifest
+    if not deps_manifest:
+        args.add_all(direct_deps, before_each = "-direct", map_each = _format_arc)
+        args.add_all(indirect_deps, before_each = "-transitive", map_each = _format_arc)
+        args.add_all(transitive_deps, before_each = "-deplabel", map_each = _format_dep_label)
+        args.add_all(transitive_deps, before_each = "-depedge", map_each = _format_dep_edge)
+    args.add_all(transitive_deps, before_each = "-abi", map_each = _format_abi)
+    if main_abi:
+        args.add("-abi", "main=" + main_abi.path)
     args.add("-main", main)
     args.add("-o", out)
+    args.add_all(["{}={}".format(k, v) for k, v in x_defs.items()], before_each = "-define")
+    if stamp:
+        args.add("-stamp")
+    outputs = [out] + _add_diag_args(ctx, args, out)
+    if link_map:
+        args.add("-link-map", link_map)
+        outputs.append(link_map)
 
     ctx.actions.run(
-        outputs = [out],
+        outputs = outputs,
         inputs = inputs,
         executable = toolchain.internal.builder,
         arguments = [args],
@@ -82,7 +208,16 @@ def go_link(ctx, out, main, deps = []):
         mnemonic = "GoLink",
     )
 
-def go_build_test(ctx, srcs, deps, out, rundir = "", importpath = ""):
+def go_build_test(
+        ctx,
+        srcs,
+        deps,
+        out,
+        rundir = "",
+        importpath = "",
+        x_defs = {},
+        optimization = "",
+        inline = True):
     """Compiles and links a Go test executable.
 
     Args:
@@ -92,6 +227,11 @@ def go_build_test(ctx, srcs, deps, out, rundir = "", importpath = ""):
         out: output executable file.
         importpath: import path of the internal test archive.
         rundir: directory the test should change to before executing.
+        x_defs: dict mapping qualified names of string variables
+            (packagepath.name) to the values they should be set to.
+        optimization: optimization level: "0", "1", "2", or "s". If empty,
+            the level depends on --compilation_mode.
+        inline: whether the compiler may inline functions.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
     direct_dep_infos = [d.info for d in deps]
@@ -105,18 +245,39 @@ def go_build_test(ctx, srcs, deps, out, rundir = "", importpath = ""):
 
     args = ctx.actions.args()
     args.add("test")
+    args.add("-compiler", toolchain.internal.compiler)
+    args.add("-linker", toolchain.internal.linker)
     args.add("-stdimportcfg", toolchain.internal.stdimportcfg)
+    if toolchain.internal.goexperiment:
+        args.add("-goexperiment", toolchain.internal.goexperiment)
+    if toolchain.internal.tool_retries:
+        args.add("-tool-retries", str(toolchain.internal.tool_retries))
+    if toolchain.internal.std_overlap != "warn":
+        args.add("-std-overlap", toolchain.internal.std_overlap)
+    if toolchain.internal.std_allowlist:
+        args.add("-std-allowlist", toolchain.internal.std_allowlist)
+        inputs.append(toolchain.internal.std_allowlist)
     args.add_all(direct_dep_infos, before_each = "-direct", map_each = _format_arc)
     args.add_all(transitive_dep_infos, before_each = "-transitive", map_each = _format_arc)
     if rundir != "":
         args.add("-dir", rundir)
     if importpath != "":
         args.add("-p", importpath)
+    args.add_all(["{}={}".format(k, v) for k, v in x_defs.items()], before_each = "-define")
+    _add_opt_args(ctx, args, optimization, inline)
+    args.add_all(srcs, before_each = "-srcmap", map_each = _format_srcmap)
     args.add("-o", out)
+    outputs = [out] + _add_diag_args(ctx, args, out) + _add_crash_report(ctx, args, out)
+    inputs += _add_input_digests(
+        ctx,
+        args,
+        out,
+        srcs + [d.archive for d in direct_dep_infos + transitive_dep_infos],
+    )
     args.add_all(srcs)
 
     ctx.actions.run(
-        outputs = [out],
+        outputs = outputs,
         inputs = inputs,
         executable = toolchain.internal.builder,
         arguments = [args],
@@ -124,6 +285,241 @@ def go_build_test(ctx, srcs, deps, out, rundir = "", importpath = ""):
         mnemonic = "GoTest",
     )
 
+def go_embed_data(
+        ctx,
+        srcs,
+        out,
+        package,
+        var = "Data",
+        use_map = False,
+        flatten = False,
+        string = False,
+        gzip = False,
+        bindata = None):
+    """Generates a Go source file containing the contents of other files.
+
+    Args:
+        ctx: analysis context.
+        srcs: list of Files to embed. Unless use_map is set, there must be
+            exactly one.
+        out: output .go File.
+        package: name of the package the generated file belongs to.
+        var: name of the variable holding the contents.
+        use_map: whether var is a map from file names to contents. File
+            names are paths relative to the workspace root.
+        flatten: with use_map, use base names of files as keys instead.
+        string: whether contents are strings instead of byte slices.
+        gzip: whether contents are gzip-compressed.
+        bindata: optional go-bindata manifest File, holding the arguments a
+            project passed to go-bindata. If set, the generated file has
+            go-bindata's API instead of a variable, and asset names are
+            relative to the Bazel package directory. package may be empty
+            to use the manifest's -pkg. Other options are ignored.
+    """
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+
+    args = ctx.actions.args()
+    args.add("genembed")
+    args.add("-o", out)
+    inputs = srcs
+    if bindata:
+        args.add("-bindata", bindata)
+        if package:
+            args.add("-package", package)
+        pkg_dir = ctx.label.package
+        if pkg_dir:
+            args.add("-trim", ctx.bin_dir.path + "/" + pkg_dir)
+            args.add("-trim", pkg_dir)
+        else:
+            args.add("-trim", ctx.bin_dir.path)
+        args.add_all(srcs, before_each = "-srcmap", map_each = _format_srcmap)
+        args.add_all(srcs)
+        inputs = srcs + [bindata]
+    else:
+        _add_embed_data_args(ctx, args, package, var, use_map, flatten, string, gzip)
+        args.add_all(srcs)
+
+    ctx.actions.run(
+        outputs = [out],
+        inputs = inputs,
+        executable = toolchain.internal.builder,
+        arguments = [args],
+        env = toolchain.internal.env,
+        mnemonic = "GoEmbedData",
+    )
+
+def go_pack_layer(ctx, out, binary, runfiles, data_manifest = None, dir = "/app"):
+    """Packs an executable and its runfiles into a tar file for a container
+    image layer.
+
+    Args:
+        ctx: analysis context.
+        out: output .tar File.
+        binary: executable File. It's written to dir in the layer, with the
+            same base name.
+        runfiles: dict mapping runfiles paths to Files. Each is written
+            below the executable's .runfiles directory.
+        data_manifest: optional data manifest File. It's written next to
+            the executable, where the runfiles library looks for it.
+        dir: absolute directory in the image where binary is written.
+    """
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+
+    args = ctx.actions.args()
+    args.add("pack-layer")
+    args.add("-o", out)
+    args.add("-binary", binary)
+    args.add("-dir", dir)
+    inputs = [binary] + runfiles.values()
+    if data_manifest:
+        args.add("-data-manifest", data_manifest)
+        inputs.append(data_manifest)
+    args.add_all(["{}={}".format(k, v.path) for k, v in runfiles.items()], before_each = "-runfile")
+
+    ctx.actions.run(
+        outputs = [out],
+        inputs = inputs,
+        executable = toolchain.internal.builder,
+        arguments = [args],
+        env = toolchain.internal.env,
+        mnemonic = "GoPackLayer",
+    )
+
+def _add_embed_data_args(ctx, args, package, var, use_map, flatten, string, gzip):
+    """Adds genembed flags for a variable holding file contents to args."""
+    args.add("-package", package)
+    args.add("-var", var)
+    if use_map:
+        args.add("-map")
+        args.add("-trim", ctx.bin_dir.path)
+    if flatten:
+        args.add("-flatten")
+    if string:
+        args.add("-string")
+    if gzip:
+        args.add("-gzip")
+
+def _add_opt_args(ctx, args, optimization, inline):
+    """Adds compiler optimization flags to args. If optimization is empty,
+    optimization is disabled with --compilation_mode=dbg, so binaries are
+    easier to debug, and the compiler's default is used otherwise."""
+    if not optimization and ctx.var.get("COMPILATION_MODE") == "dbg":
+        optimization = "0"
+    if optimization:
+        args.add("-O", optimization)
+    if not inline:
+        args.add("-no-inline")
+
+def _add_diag_args(ctx, args, out):
+    """Adds arguments that limit the lines of tool output an action prints,
+    if the toolchain sets a limit. The complete output is written to a log
+    file next to out. Returns a list of the files to add to the action's
+    outputs."""
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    if not toolchain.internal.max_output_lines:
+        return []
+    full_log = ctx.actions.declare_file(out.basename + ".log", sibling = out)
+    args.add("-max-output-lines", str(toolchain.internal.max_output_lines))
+    args.add("-full-log", full_log)
+    return [full_log]
+
+def _add_crash_report(ctx, args, out):
+    """Adds an argument that makes the builder write a crash report next to
+    out if the compiler crashes, if the toolchain enables crash reports.
+    Returns a list of the files to add to the action's outputs."""
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    if not toolchain.internal.crash_reports:
+        return []
+    report = ctx.actions.declare_file(out.basename + ".crash.tar.gz", sibling = out)
+    args.add("-crash-report", report)
+    return [report]
+
+def _add_deps_manifest(ctx, args, out, direct_deps, transitive_deps):
+    """If the toolchain enables dependency manifests, writes a JSON manifest
+    describing transitive_deps next to out and adds an argument that makes
+    the builder read it instead of -arc, -deplabel, and -depedge flags.
+    direct_deps are the GoLibraryInfo.info objects of direct dependencies.
+    Returns a list of the files to add to the action's inputs, which is
+    empty if the toolchain doesn't enable manifests."""
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    if not toolchain.internal.deps_manifest:
+        return []
+    direct = {lib.importpath: True for lib in direct_deps}
+    entries = []
+    for lib in transitive_deps.to_list():
+        entry = {
+            "importpath": lib.importpath,
+            "archive": lib.archive.path,
+            "label": lib.label,
+            "imports": lib.dep_importpaths,
+        }
+        if lib.export:
+            entry["export"] = lib.export.path
+        if lib.importpath in direct:
+            entry["direct"] = True
+        entries.append(struct(**entry))
+    manifest = ctx.actions.declare_file(out.basename + ".deps.json", sibling = out)
+    ctx.actions.write(manifest, struct(version = 1, deps = entries).to_json())
+    args.add("-deps-manifest", manifest)
+    return [manifest]
+
+def _add_input_digests(ctx, args, out, files):
+    """If the toolchain verifies input digests, declares a manifest of the
+    digests of files next to out, written by a separate action, and adds an
+    argument that makes the builder check the files against it. Returns a
+    list of the files to add to the action's inputs."""
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    if not toolchain.internal.verify_input_digests:
+        return []
+    manifest = ctx.actions.declare_file(out.basename + ".digests", sibling = out)
+    digest_args = ctx.actions.args()
+    digest_args.add("inputdigests")
+    digest_args.add("-o", manifest)
+    digest_args.add_all(files)
+    ctx.actions.run(
+        outputs = [manifest],
+        inputs = files,
+        executable = toolchain.internal.builder,
+        arguments = [digest_args],
+        env = toolchain.internal.env,
+        mnemonic = "GoInputDigests",
+    )
+    args.add("-input-digests", manifest)
+    return [manifest]
+
 def _format_arc(lib):
     """Formats a GoLibraryInfo.info object as an -arc argument"""
     return "{}={}".format(lib.importpath, lib.archive.path)
+
+def _format_export_arc(lib):
+    """Formats a GoLibraryInfo.info object as an -arc argument for compiling
+    an importer, which only needs the library's export data."""
+    return "{}={}".format(lib.importpath, _export_file(lib).path)
+
+def _export_file(lib):
+    """Returns the File with a library's export data: its export-only archive,
+    or its full archive for libraries that don't have one."""
+    return lib.export or lib.archive
+
+def _format_abi(lib):
+    """Formats a GoLibraryInfo.info object as an -abi argument. Returns None
+    for libraries without an ABI file."""
+    if not lib.abi:
+        return None
+    return "{}={}".format(lib.importpath, lib.abi.path)
+
+def _format_dep_label(lib):
+    """Formats a GoLibraryInfo.info object as a -deplabel argument"""
+    return "{}={}".format(lib.importpath, lib.label)
+
+def _format_dep_edge(lib):
+    """Formats a GoLibraryInfo.info object as a -depedge argument"""
+    return "{}={}".format(lib.importpath, ",".join(lib.dep_importpaths))
+
+def _format_srcmap(src):
+    """Formats a generated source File as a -srcmap argument, so it appears
+    at its logical location instead of under bazel-out. Returns None for
+    source files that aren't generated."""
+    if src.is_source:
+        return None
+    return "{}={}".format(src.path, src.short_path)
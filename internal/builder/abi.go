@@ -0,0 +1,206 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// An ABI file records the export data each import of a package was compiled
+// against. The compiler reads only export data (the __.PKGDEF member of an
+// archive) from dependencies, so if a dependency's export data changes, code
+// compiled against the old version may not be compatible with the new one.
+// This happens when archives come from caches or configurations that don't
+// agree. The gc linker doesn't detect this, so link checks ABI files instead.
+//
+// Each line of an ABI file has an import path and the hex-encoded SHA-256
+// digest of that package's export data, separated by a space.
+
+// abiFlag parses -abi arguments for link. Values have the form
+// "packagepath=file", where file is the ABI file written when packagepath
+// was compiled.
+type abiFlag struct {
+	abiFiles map[string]string
+}
+
+func (f abiFlag) String() string {
+	var parts []string
+	for pkg, file := range f.abiFiles {
+		parts = append(parts, pkg+"="+file)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f abiFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("malformed -abi value; expected packagepath=file: %q", value)
+	}
+	f.abiFiles[value[:i]] = value[i+1:]
+	return nil
+}
+
+// exportDataHash returns the hex-encoded SHA-256 digest of the export data
+// in an archive.
+func exportDataHash(arcPath string) (string, error) {
+	f, err := os.Open(arcPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	members, err := readArchive(f, fi.Size())
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", arcPath, err)
+	}
+	for _, m := range members {
+		if m.name == "__.PKGDEF" {
+			h := sha256.New()
+			if _, err := io.Copy(h, io.NewSectionReader(f, m.offset, m.size)); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+	return "", fmt.Errorf("%s: archive has no export data", arcPath)
+}
+
+// writeABIFile writes an ABI file for a package with the given imports.
+// archiveMap maps import paths to the archives the package was compiled
+// against.
+func writeABIFile(path string, imports []string, archiveMap map[string]string) error {
+	sorted := append([]string{}, imports...)
+	sort.Strings(sorted)
+	b := &strings.Builder{}
+	for _, imp := range sorted {
+		arcPath, ok := archiveMap[imp]
+		if !ok {
+			// unsafe and C have no archive.
+			continue
+		}
+		hash, err := exportDataHash(arcPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s %s\n", imp, hash)
+	}
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// checkABIFiles verifies that the archives being linked have the export
+// data recorded in ABI files when their importers were compiled. abiFiles
+// maps package paths to ABI files. archiveMap maps package paths to the
+// archives that will be linked. Every mismatch is listed in the returned
+// error.
+func checkABIFiles(abiFiles map[string]string, archiveMap map[string]string, graph depGraph) error {
+	// Read all the ABI files first, then hash the archives they mention
+	// concurrently. Big links may check hundreds of archives.
+	type abiEntry struct {
+		pkg, imp, want string
+	}
+	var entries []abiEntry
+	pkgs := make([]string, 0, len(abiFiles))
+	for pkg := range abiFiles {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	var imps []string
+	hashIndex := make(map[string]int)
+	for _, pkg := range pkgs {
+		f, err := os.Open(abiFiles[pkg])
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 2 {
+				f.Close()
+				return fmt.Errorf("%s:%d: malformed line", abiFiles[pkg], lineNum)
+			}
+			imp, want := fields[0], fields[1]
+			if _, ok := archiveMap[imp]; !ok {
+				// The linker will report the missing package.
+				continue
+			}
+			if _, ok := hashIndex[imp]; !ok {
+				hashIndex[imp] = len(imps)
+				imps = append(imps, imp)
+			}
+			entries = append(entries, abiEntry{pkg, imp, want})
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	hashes := make([]string, len(imps))
+	errs := make([]error, len(imps))
+	parallelFor(len(imps), func(i int) {
+		hashes[i], errs[i] = exportDataHash(archiveMap[imps[i]])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	var mismatches []string
+	for _, e := range entries {
+		if got := hashes[hashIndex[e.imp]]; got != e.want {
+			mismatches = append(mismatches, fmt.Sprintf("%s was compiled against different export data for %s than %s contains", describePackage(e.pkg, graph), describePackage(e.imp, graph), archiveMap[e.imp]))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("archives are out of sync with their importers; they may come from different caches or configurations:\n\t%s", strings.Join(mismatches, "\n\t"))
+	}
+	return nil
+}
+
+// describePackage returns a package path and, if known, the label of the
+// target that provides it and whether it's a direct or transitive
+// dependency, for use in error messages.
+func describePackage(pkg string, graph depGraph) string {
+	var details []string
+	if label := graph.labels[pkg]; label != "" {
+		details = append(details, label)
+	}
+	if graph.direct != nil && pkg != "main" {
+		if graph.direct[pkg] {
+			details = append(details, "direct")
+		} else if graph.labels[pkg] != "" {
+			details = append(details, "transitive")
+		}
+	}
+	if len(details) == 0 {
+		return pkg
+	}
+	return fmt.Sprintf("%s (%s)", pkg, strings.Join(details, ", "))
+}
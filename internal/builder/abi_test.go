@@ -0,0 +1,82 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckABIFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCheckABIFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeArchive := func(name, exportData string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		data := arMagic + arEntry("__.PKGDEF", exportData) + arEntry("_go_.o", "code")
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// main and b were compiled against the first versions of a and c.
+	archiveMap := map[string]string{
+		"example.com/a": writeArchive("a.a", "a export data"),
+		"example.com/c": writeArchive("c.a", "c export data"),
+	}
+	mainABI := filepath.Join(dir, "main.abi")
+	if err := writeABIFile(mainABI, []string{"example.com/a", "unsafe"}, archiveMap); err != nil {
+		t.Fatal(err)
+	}
+	bABI := filepath.Join(dir, "b.abi")
+	if err := writeABIFile(bABI, []string{"example.com/c", "example.com/a"}, archiveMap); err != nil {
+		t.Fatal(err)
+	}
+	abiFiles := map[string]string{"main": mainABI, "example.com/b": bABI}
+	graph := depGraph{
+		labels: map[string]string{"example.com/a": "//a", "example.com/b": "//b", "example.com/c": "//c"},
+		direct: map[string]bool{"example.com/b": true},
+	}
+	if err := checkABIFiles(abiFiles, archiveMap, graph); err != nil {
+		t.Fatalf("archives match: %v", err)
+	}
+
+	// Replacing a's export data breaks both of its importers; c still
+	// matches.
+	archiveMap["example.com/a"] = writeArchive("a2.a", "new a export data")
+	err = checkABIFiles(abiFiles, archiveMap, graph)
+	if err == nil {
+		t.Fatal("a changed: unexpected success")
+	}
+	want := "archives are out of sync with their importers; they may come from different caches or configurations:\n" +
+		"\texample.com/b (//b, direct) was compiled against different export data for example.com/a (//a, transitive) than " + archiveMap["example.com/a"] + " contains\n" +
+		"\tmain was compiled against different export data for example.com/a (//a, transitive) than " + archiveMap["example.com/a"] + " contains"
+	if got := err.Error(); got != want {
+		t.Errorf("a changed: got error:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Packages missing from the link are left for the linker to report.
+	delete(archiveMap, "example.com/a")
+	if err := checkABIFiles(abiFiles, archiveMap, graph); err != nil {
+		t.Errorf("a missing: %v", err)
+	}
+
+	badABI := filepath.Join(dir, "bad.abi")
+	if err := ioutil.WriteFile(badABI, []byte("example.com/c\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = checkABIFiles(map[string]string{"main": badABI}, archiveMap, graph)
+	if err == nil || !strings.Contains(err.Error(), badABI+":1: malformed line") {
+		t.Errorf("malformed ABI file: got error %v; want malformed line error", err)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// apiCheck compares the exported API of a compiled package with a baseline
+// file and fails if anything in the baseline is missing or changed.
+//
+//	apicheck -p packagepath [-arc packagepath=file...] [-write] baseline file.a
+//
+// The API is read from the export data in file.a. It's described as a
+// sorted list of features, one per line, similar to the files in the Go
+// distribution's api directory. Adding features is compatible; removing or
+// changing one is not. With -write, the baseline is replaced with the
+// current API instead.
+func apiCheck(args []string) error {
+	// Process command line arguments.
+	var packagePath, stdImportcfgPath string
+	var write bool
+	var archives []archive
+	fs := flag.NewFlagSet("apicheck", flag.ExitOnError)
+	fs.StringVar(&packagePath, "p", "", "package path of the package being checked")
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library, used if the export data refers to other packages")
+	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies, formatted as packagepath=file (may be repeated)")
+	fs.BoolVar(&write, "write", false, "write the current API to the baseline file instead of checking it")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: apicheck -p packagepath [-arc packagepath=file...] [-write] baseline file.a")
+	}
+	if packagePath == "" {
+		return errors.New("-p must be set")
+	}
+	baselinePath, arcPath := fs.Arg(0), fs.Arg(1)
+
+	archiveMap := make(map[string]string)
+	if stdImportcfgPath != "" {
+		stdArchiveMap, err := readImportcfg(stdImportcfgPath)
+		if err != nil {
+			return err
+		}
+		for pkg, file := range stdArchiveMap {
+			archiveMap[pkg] = file
+		}
+	}
+	for _, arc := range archives {
+		archiveMap[arc.packagePath] = arc.filePath
+	}
+	archiveMap[packagePath] = arcPath
+
+	features, err := exportedFeatures(packagePath, archiveMap)
+	if err != nil {
+		return err
+	}
+	if write {
+		return writeFileAtomic(baselinePath, []byte(strings.Join(features, "\n")+"\n"))
+	}
+
+	data, err := ioutil.ReadFile(baselinePath)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, f := range features {
+		current[f] = true
+	}
+	baseline := make(map[string]bool)
+	var removed []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		baseline[line] = true
+		if !current[line] {
+			removed = append(removed, line)
+		}
+	}
+	var added []string
+	for _, f := range features {
+		if !baseline[f] {
+			added = append(added, f)
+		}
+	}
+	if len(removed) > 0 {
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "%s: incompatible API changes since %s:", packagePath, baselinePath)
+		for _, f := range removed {
+			fmt.Fprintf(b, "\n\t- %s", f)
+		}
+		for _, f := range added {
+			fmt.Fprintf(b, "\n\t+ %s", f)
+		}
+		return errors.New(b.String())
+	}
+	if len(added) > 0 {
+		fmt.Fprintf(os.Stderr, "note: %s has %d features not in %s; use -write to record them\n", packagePath, len(added), baselinePath)
+	}
+	return nil
+}
+
+// exportedFeatures loads a package from export data and returns its exported
+// API as a sorted list of features. archiveMap maps package paths to archive
+// files for the package and anything its export data refers to.
+func exportedFeatures(packagePath string, archiveMap map[string]string) ([]string, error) {
+	fset := token.NewFileSet()
+	lookup := func(path string) (io.ReadCloser, error) {
+		file, ok := archiveMap[path]
+		if !ok {
+			return nil, fmt.Errorf("no archive for package %q", path)
+		}
+		return os.Open(file)
+	}
+	pkg, err := importer.ForCompiler(fset, "gc", lookup).Import(packagePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading export data for %s: %v", packagePath, err)
+	}
+
+	qual := types.RelativeTo(pkg)
+	var features []string
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Const:
+			features = append(features, fmt.Sprintf("const %s %s = %s", name, types.TypeString(obj.Type(), qual), obj.Val().ExactString()))
+
+		case *types.Var:
+			features = append(features, fmt.Sprintf("var %s %s", name, types.TypeString(obj.Type(), qual)))
+
+		case *types.Func:
+			features = append(features, "func "+name+signatureString(obj.Type().(*types.Signature), qual))
+
+		case *types.TypeName:
+			features = append(features, typeFeatures(obj, qual)...)
+		}
+	}
+	sort.Strings(features)
+	return features, nil
+}
+
+// typeFeatures returns features for an exported type: the type itself,
+// each exported field of a struct, and each exported method. Interfaces are
+// a single feature including all their methods, since adding a method to an
+// interface breaks implementations.
+func typeFeatures(obj *types.TypeName, qual types.Qualifier) []string {
+	name := obj.Name()
+	if obj.IsAlias() {
+		return []string{fmt.Sprintf("type %s = %s", name, types.TypeString(obj.Type(), qual))}
+	}
+	var features []string
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct:
+		features = append(features, fmt.Sprintf("type %s struct", name))
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if !f.Exported() {
+				continue
+			}
+			if f.Embedded() {
+				features = append(features, fmt.Sprintf("type %s struct, embedded %s", name, types.TypeString(f.Type(), qual)))
+			} else {
+				features = append(features, fmt.Sprintf("type %s struct, %s %s", name, f.Name(), types.TypeString(f.Type(), qual)))
+			}
+		}
+	default:
+		features = append(features, fmt.Sprintf("type %s %s", name, types.TypeString(u, qual)))
+	}
+
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return features
+	}
+	for i := 0; i < named.NumMethods(); i++ {
+		m := named.Method(i)
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		recv := name
+		if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+			recv = "*" + name
+		}
+		features = append(features, fmt.Sprintf("method (%s) %s%s", recv, m.Name(), signatureString(sig, qual)))
+	}
+	return features
+}
+
+// signatureString formats a function signature without the func keyword,
+// receiver, or parameter names, for example, "(int, string) error".
+// Parameter names are omitted because renaming one doesn't affect callers.
+func signatureString(sig *types.Signature, qual types.Qualifier) string {
+	b := &strings.Builder{}
+	b.WriteString("(")
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		t := params.At(i).Type()
+		if sig.Variadic() && i == params.Len()-1 {
+			b.WriteString("...")
+			t = t.(*types.Slice).Elem()
+		}
+		b.WriteString(types.TypeString(t, qual))
+	}
+	b.WriteString(")")
+	results := sig.Results()
+	if results.Len() == 1 {
+		b.WriteString(" " + types.TypeString(results.At(0).Type(), qual))
+	} else if results.Len() > 1 {
+		b.WriteString(" (")
+		for i := 0; i < results.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(types.TypeString(results.At(i).Type(), qual))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
@@ -0,0 +1,291 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// arMagic is the signature at the beginning of an ar archive. Go archives
+// written by the compiler and pack use this format. Their members include
+// __.PKGDEF (export data), _go_.o (compiled Go code), and object files
+// written by the assembler.
+const arMagic = "!<arch>\n"
+
+// arHeaderSize is the size of the header before each archive member.
+const arHeaderSize = 60
+
+// arMember describes a file stored in an ar archive.
+type arMember struct {
+	name   string
+	mode   int64
+	size   int64
+	offset int64 // offset of the member's data in the archive
+}
+
+// readArchive reads the table of contents of an ar archive. Both GNU
+// ("/123" names referring to a "//" table) and BSD ("#1/len" names
+// followed by the name) long names are supported. GNU symbol tables
+// ("/" and "/SYM64/") are skipped.
+func readArchive(r io.ReaderAt, size int64) ([]arMember, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != arMagic {
+		return nil, errors.New("not an ar archive")
+	}
+
+	var members []arMember
+	var longNames []byte
+	hdr := make([]byte, arHeaderSize)
+	for off := int64(len(arMagic)); off < size; {
+		if _, err := r.ReadAt(hdr, off); err != nil {
+			return nil, fmt.Errorf("reading member header at offset %d: %v", off, err)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("malformed member header at offset %d", off)
+		}
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		mode, err := strconv.ParseInt(strings.TrimSpace(string(hdr[40:48])), 8, 64)
+		if err != nil && strings.TrimSpace(string(hdr[40:48])) != "" {
+			return nil, fmt.Errorf("malformed mode in member header at offset %d", off)
+		}
+		memberSize, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || memberSize < 0 {
+			return nil, fmt.Errorf("malformed size in member header at offset %d", off)
+		}
+		dataOff := off + arHeaderSize
+		if dataOff+memberSize > size {
+			return nil, fmt.Errorf("member at offset %d extends past end of archive", off)
+		}
+		next := dataOff + memberSize + memberSize%2
+
+		switch {
+		case name == "/" || name == "/SYM64/":
+			// GNU symbol table.
+			off = next
+			continue
+
+		case name == "//":
+			// GNU long name table.
+			longNames = make([]byte, memberSize)
+			if _, err := r.ReadAt(longNames, dataOff); err != nil {
+				return nil, err
+			}
+			off = next
+			continue
+
+		case strings.HasPrefix(name, "#1/"):
+			// BSD long name, stored at the beginning of the data.
+			n, err := strconv.ParseInt(name[len("#1/"):], 10, 64)
+			if err != nil || n < 0 || n > memberSize {
+				return nil, fmt.Errorf("malformed long name in member header at offset %d", off)
+			}
+			buf := make([]byte, n)
+			if _, err := r.ReadAt(buf, dataOff); err != nil {
+				return nil, err
+			}
+			name = string(bytes.TrimRight(buf, "\x00"))
+			dataOff += n
+			memberSize -= n
+
+		case len(name) > 1 && name[0] == '/':
+			// GNU long name, an offset into the long name table.
+			i, err := strconv.Atoi(name[1:])
+			if err != nil || i < 0 || i >= len(longNames) {
+				return nil, fmt.Errorf("malformed long name in member header at offset %d", off)
+			}
+			end := bytes.Index(longNames[i:], []byte("/\n"))
+			if end < 0 {
+				return nil, fmt.Errorf("malformed long name in member header at offset %d", off)
+			}
+			name = string(longNames[i : i+end])
+
+		default:
+			// GNU ar terminates short names with '/'.
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		members = append(members, arMember{
+			name:   name,
+			mode:   mode,
+			size:   memberSize,
+			offset: dataOff,
+		})
+		off = next
+	}
+	return members, nil
+}
+
+// arWriter writes an ar archive. Member headers have zero timestamps and
+// owners, so the output depends only on member names, modes, and data.
+// Names longer than 16 bytes are written in the BSD long name format.
+type arWriter struct {
+	w io.Writer
+}
+
+func newArWriter(w io.Writer) (*arWriter, error) {
+	if _, err := io.WriteString(w, arMagic); err != nil {
+		return nil, err
+	}
+	return &arWriter{w: w}, nil
+}
+
+// writeMember copies a member with the given name, mode, and size from r.
+func (aw *arWriter) writeMember(name string, mode int64, size int64, r io.Reader) error {
+	hdrName, prefix := name, ""
+	if len(name) > 16 || strings.ContainsAny(name, " ") {
+		hdrName = fmt.Sprintf("#1/%d", len(name))
+		prefix = name
+	}
+	totalSize := int64(len(prefix)) + size
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", hdrName, 0, 0, 0, mode, totalSize)
+	if _, err := io.WriteString(aw.w, hdr+prefix); err != nil {
+		return err
+	}
+	if n, err := io.Copy(aw.w, io.LimitReader(r, size)); err != nil {
+		return err
+	} else if n != size {
+		return fmt.Errorf("member %s: expected %d bytes; got %d", name, size, n)
+	}
+	if totalSize%2 == 1 {
+		if _, err := io.WriteString(aw.w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveCmd inspects archives written by the compiler and pack.
+//
+//	archive list [-l] file.a
+//	archive extract [-o dir] file.a [member...]
+//
+// list prints the names of members, or with -l, their sizes and names.
+// extract writes members to files in a directory. If no members are named,
+// all members are extracted.
+func archiveCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: archive list|extract options... file.a")
+	}
+	switch args[0] {
+	case "list":
+		return archiveList(args[1:])
+	case "extract":
+		return archiveExtract(args[1:])
+	default:
+		return fmt.Errorf("unknown archive command: %s", args[0])
+	}
+}
+
+func archiveList(args []string) error {
+	// Process command line arguments.
+	var long bool
+	fs := flag.NewFlagSet("archive list", flag.ExitOnError)
+	fs.BoolVar(&long, "l", false, "print the size of each member before its name")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected 1 positional argument; got %d", fs.NArg())
+	}
+
+	members, err := openArchive(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if long {
+			fmt.Printf("%10d %s\n", m.size, m.name)
+		} else {
+			fmt.Println(m.name)
+		}
+	}
+	return nil
+}
+
+func archiveExtract(args []string) error {
+	// Process command line arguments.
+	var outDir string
+	fs := flag.NewFlagSet("archive extract", flag.ExitOnError)
+	fs.StringVar(&outDir, "o", ".", "directory where members should be written")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return errors.New("expected archive file argument")
+	}
+	arcPath := fs.Arg(0)
+
+	want := make(map[string]bool)
+	for _, name := range fs.Args()[1:] {
+		want[name] = true
+	}
+	f, err := os.Open(arcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	members, err := readArchive(f, fi.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %v", arcPath, err)
+	}
+	found := make(map[string]bool)
+	for _, m := range members {
+		if len(want) > 0 && !want[m.name] {
+			continue
+		}
+		found[m.name] = true
+		// Members are written directly into outDir, never elsewhere.
+		if m.name == "" || m.name == "." || m.name == ".." || strings.ContainsAny(m.name, `/\`) {
+			return fmt.Errorf("%s: refusing to extract member with unsafe name %q", arcPath, m.name)
+		}
+		if err := extractMember(f, m, filepath.Join(outDir, m.name)); err != nil {
+			return err
+		}
+	}
+	for name := range want {
+		if !found[name] {
+			return fmt.Errorf("%s: no member named %q", arcPath, name)
+		}
+	}
+	return nil
+}
+
+func openArchive(path string) ([]arMember, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	members, err := readArchive(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return members, nil
+}
+
+func extractMember(r io.ReaderAt, m arMember, outPath string) error {
+	w, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, m.offset, m.size)); err != nil {
+		w.abort()
+		return err
+	}
+	return w.commit()
+}
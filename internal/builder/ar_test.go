@@ -0,0 +1,101 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// arEntry formats an archive member header and data by hand, so the reader
+// is tested independently of any writer.
+func arEntry(name string, data string) string {
+	s := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data)) + data
+	if len(data)%2 == 1 {
+		s += "\n"
+	}
+	return s
+}
+
+func TestReadArchive(t *testing.T) {
+	longNames := "a_very_long_member_name.o/\n"
+	arc := arMagic +
+		arEntry("/", "symtab") +
+		arEntry("//", longNames) +
+		arEntry("__.PKGDEF", "export data") +
+		arEntry("_go_.o/", "code") +
+		arEntry("/0", "long") +
+		arEntry("#1/12", "bsd_name.o\x00\x00odd")
+
+	members, err := readArchive(bytes.NewReader([]byte(arc)), int64(len(arc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, data string }{
+		{"__.PKGDEF", "export data"},
+		{"_go_.o", "code"},
+		{"a_very_long_member_name.o", "long"},
+		{"bsd_name.o", "odd"},
+	}
+	if len(members) != len(want) {
+		t.Fatalf("got %d members; want %d", len(members), len(want))
+	}
+	for i, m := range members {
+		data := arc[m.offset : m.offset+m.size]
+		if m.name != want[i].name || data != want[i].data {
+			t.Errorf("member %d: got %q with data %q; want %q with data %q", i, m.name, data, want[i].name, want[i].data)
+		}
+	}
+}
+
+func TestReadArchiveErrors(t *testing.T) {
+	for _, arc := range []string{
+		"not an archive",
+		arMagic + "truncated header",
+		arMagic + arEntry("big.o", "data")[:arHeaderSize+2],
+		arMagic + arEntry("/5", "no long name table"),
+	} {
+		if _, err := readArchive(bytes.NewReader([]byte(arc)), int64(len(arc))); err == nil {
+			t.Errorf("readArchive(%q): got nil error; want error", arc)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	want := []struct{ name, data string }{
+		{"__.PKGDEF", "export data"},
+		{"odd.o", "odd"},
+		{"a_name_longer_than_sixteen_bytes.o", "long"},
+		{"empty.o", ""},
+	}
+	buf := &bytes.Buffer{}
+	aw, err := newArWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range want {
+		if err := aw.writeMember(m.name, 0644, int64(len(m.data)), strings.NewReader(m.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	arc := buf.String()
+	members, err := readArchive(strings.NewReader(arc), int64(len(arc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != len(want) {
+		t.Fatalf("got %d members; want %d", len(members), len(want))
+	}
+	for i, m := range members {
+		data := arc[m.offset : m.offset+m.size]
+		if m.name != want[i].name || data != want[i].data || m.mode != 0644 {
+			t.Errorf("member %d: got %q (mode %o) with data %q; want %q (mode 644) with data %q", i, m.name, m.mode, data, want[i].name, want[i].data)
+		}
+	}
+}
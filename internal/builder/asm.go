@@ -0,0 +1,145 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// compileWithAsm compiles a package that contains assembly sources. This
+// follows the same steps as 'go build':
+//
+//  1. Header files are staged into a temporary include directory, so
+//     assembly sources can include them regardless of where Bazel put them.
+//  2. The assembler generates a symbol ABI file for functions defined in
+//     assembly.
+//  3. The compiler compiles the Go sources, reading the symbol ABI file
+//     and writing go_asm.h into the include directory.
+//  4. The assembler assembles each assembly source into an object file.
+//  5. The object files are appended to the archive written by the compiler.
+func compileWithAsm(packagePath, importcfgPath string, goSrcPaths, asmSrcPaths, hdrPaths []string, outPath string) error {
+	incDir, err := createTempDir("asm-include-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(incDir)
+	if err := stageHeaders(incDir, hdrPaths); err != nil {
+		return err
+	}
+
+	progress.stepf("generating symbol ABIs")
+	symabisPath := filepath.Join(incDir, "symabis")
+	if err := runAssembler(incDir, append([]string{"-gensymabis", "-o", symabisPath}, asmSrcPaths...)); err != nil {
+		return err
+	}
+
+	progress.stepf("compiling %d Go files", len(goSrcPaths))
+	asmhdrPath := filepath.Join(incDir, "go_asm.h")
+	if err := runCompiler(packagePath, importcfgPath, goSrcPaths, outPath, "-symabis", symabisPath, "-asmhdr", asmhdrPath); err != nil {
+		return err
+	}
+
+	progress.stepf("assembling %d files", len(asmSrcPaths))
+	objPaths := make([]string, len(asmSrcPaths))
+	for i, asmSrcPath := range asmSrcPaths {
+		base := filepath.Base(asmSrcPath)
+		objPaths[i] = filepath.Join(incDir, fmt.Sprintf("%d_%s.o", i, base[:len(base)-len(filepath.Ext(base))]))
+		if err := runAssembler(incDir, []string{"-o", objPaths[i], asmSrcPath}); err != nil {
+			return err
+		}
+	}
+
+	progress.stepf("packing %d object files", len(objPaths))
+	return runPacker(outPath, objPaths)
+}
+
+// stageHeaders copies header files into incDir. Headers are included by
+// base name, so two headers with the same base name are an error.
+func stageHeaders(incDir string, hdrPaths []string) error {
+	staged := make(map[string]string)
+	for _, hdrPath := range hdrPaths {
+		base := filepath.Base(hdrPath)
+		if other, ok := staged[base]; ok {
+			return fmt.Errorf("%s: header has the same name as %s", hdrPath, other)
+		}
+		staged[base] = hdrPath
+		if err := copyFile(hdrPath, filepath.Join(incDir, base)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file. It's used to stage headers in a private temporary
+// directory, not to write outputs, so dst is written in place.
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// runAssembler invokes the Go assembler. incDir is searched for included
+// files before the distribution's own headers (like textflag.h).
+func runAssembler(incDir string, args []string) error {
+	assembler, err := tools.path("assembler", tools.assembler)
+	if err != nil {
+		return err
+	}
+	goroot, err := getenv("GOROOT")
+	if err != nil {
+		return err
+	}
+	asmArgs := []string{
+		"-I", incDir,
+		"-I", filepath.Join(goroot, "pkg", "include"),
+	}
+	asmArgs = append(asmArgs, target.asmDefines()...)
+	trimpath, err := trimpathArgs()
+	if err != nil {
+		return err
+	}
+	asmArgs = append(asmArgs, trimpath...)
+	asmArgs = append(asmArgs, args...)
+	return runTool(exec.Command(assembler, asmArgs...))
+}
+
+// runPacker appends object files to an archive. pack doesn't read response
+// files, so if there are too many files for one command line, pack is run
+// several times.
+func runPacker(archivePath string, objPaths []string) error {
+	packer, err := tools.path("packer", tools.packer)
+	if err != nil {
+		return err
+	}
+	for len(objPaths) > 0 {
+		args := []string{packer, "r", archivePath}
+		n := 0
+		for n < len(objPaths) && (n == 0 || commandLineLen(args)+len(objPaths[n])+1 <= maxCommandLineLen) {
+			args = append(args, objPaths[n])
+			n++
+		}
+		if err := runTool(exec.Command(packer, args[1:]...)); err != nil {
+			return err
+		}
+		objPaths = objPaths[n:]
+	}
+	return nil
+}
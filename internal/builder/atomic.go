@@ -0,0 +1,108 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// atomicOutput is an output file that's written under a temporary name in
+// the same directory, then renamed to its real path when it's complete. If
+// an action is interrupted or fails partway through writing an archive,
+// binary, or importcfg, the real path is never left with a truncated file
+// that a local cache could keep and later actions would fail to read.
+//
+// The temporary file is in a directory named after the output, and it has
+// the same base name as the output, since tools may record it: for
+// example, the linker uses it as the code signing identifier on macOS.
+type atomicOutput struct {
+	path, dir string
+}
+
+// newAtomicOutput creates the temporary directory for an output. The caller
+// writes tmpPath, then calls commit, and calls cleanup in any case.
+func newAtomicOutput(path string) (atomicOutput, error) {
+	o := atomicOutput{path: path, dir: path + ".tmp"}
+	if err := os.RemoveAll(o.dir); err != nil {
+		return atomicOutput{}, err
+	}
+	if err := os.Mkdir(o.dir, 0777); err != nil {
+		return atomicOutput{}, err
+	}
+	return o, nil
+}
+
+func (o atomicOutput) tmpPath() string {
+	return filepath.Join(o.dir, filepath.Base(o.path))
+}
+
+// commit renames the temporary file to the output path.
+func (o atomicOutput) commit() error {
+	if err := os.Rename(o.tmpPath(), o.path); err != nil {
+		return err
+	}
+	return os.Remove(o.dir)
+}
+
+// cleanup removes the temporary directory and anything left in it. It does
+// nothing after commit.
+func (o atomicOutput) cleanup() {
+	os.RemoveAll(o.dir)
+}
+
+// atomicFile is an atomicOutput written by the builder itself.
+type atomicFile struct {
+	*os.File
+	out atomicOutput
+}
+
+// createAtomic creates a file that's renamed to path by commit.
+func createAtomic(path string) (*atomicFile, error) {
+	out, err := newAtomicOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(out.tmpPath())
+	if err != nil {
+		out.cleanup()
+		return nil, err
+	}
+	return &atomicFile{File: f, out: out}, nil
+}
+
+// commit closes the file and renames it to its real path.
+func (f *atomicFile) commit() error {
+	if err := f.File.Close(); err != nil {
+		f.out.cleanup()
+		return err
+	}
+	if err := f.out.commit(); err != nil {
+		f.out.cleanup()
+		return err
+	}
+	return nil
+}
+
+// abort closes and removes the file without writing the real path.
+func (f *atomicFile) abort() {
+	f.File.Close()
+	f.out.cleanup()
+}
+
+// writeFileAtomic writes data to a file that's renamed to path when it's
+// complete. It's like ioutil.WriteFile for outputs.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
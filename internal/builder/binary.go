@@ -0,0 +1,455 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// binaryFile is an executable written by the linker. The builder reads
+// binaries in the formats its targets use: ELF, Mach-O, PE, and
+// WebAssembly.
+type binaryFile struct {
+	format   string
+	sections []binarySection
+	symbols  []binarySymbol
+
+	// ptrSize and byteOrder describe pointers in the binary's data. They
+	// aren't set for WebAssembly, which has no data symbols to read.
+	ptrSize   int
+	byteOrder binary.ByteOrder
+
+	// dwarf is the binary's debug information, or nil if it was linked
+	// with -w or stripped.
+	dwarf *dwarf.Data
+
+	closer io.Closer
+}
+
+// binarySection is a section of a binary, like .text or __data. data reads
+// the section's contents; it's nil for sections with no contents in the
+// file, like .bss, and for WebAssembly.
+type binarySection struct {
+	name       string
+	addr, size uint64
+	data       io.ReaderAt
+}
+
+// binarySymbol is a symbol in a binary's symbol table. Mach-O and PE
+// symbols don't record sizes, so those are computed from the address of
+// the next symbol in the same section.
+type binarySymbol struct {
+	name       string
+	addr, size uint64
+	section    string
+}
+
+// openBinary reads the sections, symbols, and debug information of a
+// binary. The caller must call close.
+func openBinary(path string) (*binaryFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var bin *binaryFile
+	if ef, err := elf.NewFile(f); err == nil {
+		bin, err = readELF(ef)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		bin = readMachO(mf)
+	} else if pf, err := pe.NewFile(f); err == nil {
+		bin = readPE(pf)
+	} else if isWasm(f) {
+		bin, err = readWasm(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an ELF, Mach-O, PE, or WebAssembly binary", path)
+	}
+	bin.closer = f
+	return bin, nil
+}
+
+func (b *binaryFile) close() error {
+	return b.closer.Close()
+}
+
+func readELF(f *elf.File) (*binaryFile, error) {
+	bin := &binaryFile{format: "elf", ptrSize: 4, byteOrder: f.ByteOrder}
+	if f.Class == elf.ELFCLASS64 {
+		bin.ptrSize = 8
+	}
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC != 0 {
+			sect := binarySection{name: s.Name, addr: s.Addr, size: s.Size}
+			if s.Type != elf.SHT_NOBITS {
+				sect.data = s
+			}
+			bin.sections = append(bin.sections, sect)
+		}
+	}
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	for _, s := range syms {
+		if int(s.Section) <= 0 || int(s.Section) >= len(f.Sections) {
+			continue
+		}
+		bin.symbols = append(bin.symbols, binarySymbol{s.Name, s.Value, s.Size, f.Sections[s.Section].Name})
+	}
+	bin.dwarf, _ = f.DWARF()
+	return bin, nil
+}
+
+func readMachO(f *macho.File) *binaryFile {
+	bin := &binaryFile{format: "macho", ptrSize: 4, byteOrder: f.ByteOrder}
+	if f.Magic == macho.Magic64 {
+		bin.ptrSize = 8
+	}
+	for _, s := range f.Sections {
+		sect := binarySection{name: s.Name, addr: s.Addr, size: s.Size}
+		if s.Offset != 0 {
+			sect.data = s
+		}
+		bin.sections = append(bin.sections, sect)
+	}
+	if f.Symtab != nil {
+		for _, s := range f.Symtab.Syms {
+			if s.Sect == 0 || int(s.Sect) > len(f.Sections) {
+				continue
+			}
+			bin.symbols = append(bin.symbols, binarySymbol{name: s.Name, addr: s.Value, section: f.Sections[s.Sect-1].Name})
+		}
+	}
+	bin.computeSymbolSizes()
+	bin.dwarf, _ = f.DWARF()
+	return bin
+}
+
+func readPE(f *pe.File) *binaryFile {
+	bin := &binaryFile{format: "pe", ptrSize: 4, byteOrder: binary.LittleEndian}
+	var imageBase uint64
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		imageBase = uint64(oh.ImageBase)
+	case *pe.OptionalHeader64:
+		imageBase = oh.ImageBase
+		bin.ptrSize = 8
+	}
+	for _, s := range f.Sections {
+		sect := binarySection{name: s.Name, addr: imageBase + uint64(s.VirtualAddress), size: uint64(s.VirtualSize)}
+		if s.Offset != 0 {
+			sect.data = s
+		}
+		bin.sections = append(bin.sections, sect)
+	}
+	for _, s := range f.Symbols {
+		if s.SectionNumber <= 0 || int(s.SectionNumber) > len(f.Sections) {
+			continue
+		}
+		sect := f.Sections[s.SectionNumber-1]
+		bin.symbols = append(bin.symbols, binarySymbol{name: s.Name, addr: imageBase + uint64(sect.VirtualAddress) + uint64(s.Value), section: sect.Name})
+	}
+	bin.computeSymbolSizes()
+	bin.dwarf, _ = f.DWARF()
+	return bin
+}
+
+// wasmMagic is the header of a WebAssembly module: "\0asm" and version 1.
+const wasmMagic = "\x00asm\x01\x00\x00\x00"
+
+// wasmSectionNames are the names of standard WebAssembly sections, indexed
+// by section ID. Custom sections (ID 0) have their own names.
+var wasmSectionNames = []string{"custom", "type", "import", "function", "table", "memory", "global", "export", "start", "element", "code", "data", "datacount"}
+
+func isWasm(f io.ReaderAt) bool {
+	var buf [len(wasmMagic)]byte
+	_, err := f.ReadAt(buf[:], 0)
+	return err == nil && string(buf[:]) == wasmMagic
+}
+
+// readWasm reads a WebAssembly module. WebAssembly has no address space for
+// code, so section and symbol addresses are file offsets. Symbols are the
+// module's functions, named by the "name" custom section the Go linker
+// writes; their sizes are the sizes of their bodies in the code section.
+// The linker replaces characters other than letters, digits, '_', and '.'
+// in those names with '_', so "internal/cpu.Initialize" is named
+// "internal_cpu.Initialize".
+func readWasm(r io.Reader) (*binaryFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bin := &binaryFile{format: "wasm"}
+	var numImportedFuncs int
+	var code wasmReader
+	var codeOffset int
+	funcNames := make(map[int]string)
+	d := wasmReader{data: data, off: len(wasmMagic)}
+	for d.off < len(d.data) {
+		id := d.byte()
+		size := int(d.uleb())
+		start := d.off
+		if d.err == nil && (size < 0 || size > len(d.data)-start) {
+			d.err = errors.New("section extends past end of file")
+		}
+		if d.err != nil {
+			return nil, fmt.Errorf("malformed WebAssembly module: %v", d.err)
+		}
+		sect := wasmReader{data: d.data[:start+size], off: start}
+		name := fmt.Sprintf("section%d", id)
+		if int(id) < len(wasmSectionNames) {
+			name = wasmSectionNames[id]
+		}
+		switch name {
+		case "custom":
+			name = sect.name()
+			if name == "name" {
+				readWasmFuncNames(&sect, funcNames)
+			}
+		case "import":
+			numImportedFuncs = readWasmImports(&sect)
+		case "code":
+			code, codeOffset = sect, start
+		}
+		if sect.err != nil {
+			return nil, fmt.Errorf("malformed WebAssembly module: %s section: %v", name, sect.err)
+		}
+		bin.sections = append(bin.sections, binarySection{name: name, addr: uint64(start), size: uint64(size)})
+		d.off = start + size
+	}
+
+	if code.data != nil {
+		n := int(code.uleb())
+		for i := 0; i < n && code.err == nil; i++ {
+			size := int(code.uleb())
+			if code.err == nil && (size < 0 || size > len(code.data)-code.off) {
+				code.err = errors.New("function body extends past end of section")
+				break
+			}
+			index := numImportedFuncs + i
+			name, ok := funcNames[index]
+			if !ok {
+				name = fmt.Sprintf("wasm-function[%d]", index)
+			}
+			bin.symbols = append(bin.symbols, binarySymbol{name, uint64(code.off), uint64(size), "code"})
+			code.off += size
+		}
+		if code.err != nil {
+			return nil, fmt.Errorf("malformed WebAssembly module: code section at offset %d: %v", codeOffset, code.err)
+		}
+	}
+	return bin, nil
+}
+
+// readWasmImports returns the number of functions a module imports.
+// Imported functions come first in the function index space, so this is
+// the index of the first function in the code section.
+func readWasmImports(r *wasmReader) int {
+	n := int(r.uleb())
+	funcs := 0
+	for i := 0; i < n && r.err == nil; i++ {
+		r.name()
+		r.name()
+		switch kind := r.byte(); kind {
+		case 0: // function: type index
+			r.uleb()
+			funcs++
+		case 1: // table: reference type and limits
+			r.byte()
+			r.limits()
+		case 2: // memory: limits
+			r.limits()
+		case 3: // global: value type and mutability
+			r.byte()
+			r.byte()
+		default:
+			r.err = fmt.Errorf("unknown import kind %d", kind)
+		}
+	}
+	return funcs
+}
+
+// readWasmFuncNames reads the function names subsection of a "name" custom
+// section into names, keyed by function index.
+func readWasmFuncNames(r *wasmReader, names map[int]string) {
+	for r.off < len(r.data) && r.err == nil {
+		id := r.byte()
+		size := int(r.uleb())
+		if r.err == nil && (size < 0 || size > len(r.data)-r.off) {
+			r.err = errors.New("subsection extends past end of section")
+		}
+		if r.err != nil {
+			return
+		}
+		end := r.off + size
+		if id == 1 {
+			n := int(r.uleb())
+			for i := 0; i < n && r.err == nil; i++ {
+				index := int(r.uleb())
+				names[index] = r.name()
+			}
+		}
+		r.off = end
+	}
+}
+
+// wasmReader decodes values from a WebAssembly module. After an error,
+// methods return zero values, and err is set to the first error.
+type wasmReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *wasmReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.off < 0 || r.off >= len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[r.off]
+	r.off++
+	return b
+}
+
+// uleb reads an unsigned LEB128 integer.
+func (r *wasmReader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := r.byte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	if r.err == nil {
+		r.err = errors.New("integer too large")
+	}
+	return 0
+}
+
+func (r *wasmReader) name() string {
+	n := int(r.uleb())
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || n > len(r.data)-r.off {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(r.data[r.off : r.off+n])
+	r.off += n
+	return s
+}
+
+func (r *wasmReader) limits() {
+	if r.byte()&1 != 0 {
+		r.uleb()
+	}
+	r.uleb()
+}
+
+// computeSymbolSizes sets the size of each symbol to the distance to the
+// next symbol in the same section, or to the end of the section.
+func (b *binaryFile) computeSymbolSizes() {
+	sort.Slice(b.symbols, func(i, j int) bool { return b.symbols[i].addr < b.symbols[j].addr })
+	sectEnd := make(map[string]uint64)
+	for _, s := range b.sections {
+		sectEnd[s.name] = s.addr + s.size
+	}
+	for i := range b.symbols {
+		end := sectEnd[b.symbols[i].section]
+		for j := i + 1; j < len(b.symbols); j++ {
+			if b.symbols[j].section == b.symbols[i].section && b.symbols[j].addr > b.symbols[i].addr {
+				end = b.symbols[j].addr
+				break
+			}
+		}
+		if end > b.symbols[i].addr {
+			b.symbols[i].size = end - b.symbols[i].addr
+		}
+	}
+}
+
+// readPointers returns the pointers stored in a data symbol.
+func (b *binaryFile) readPointers(sym binarySymbol) ([]uint64, error) {
+	if b.ptrSize == 0 {
+		return nil, fmt.Errorf("can't read data of symbol %s in %s binary", sym.name, b.format)
+	}
+	var sect *binarySection
+	for i := range b.sections {
+		s := &b.sections[i]
+		if s.name == sym.section && s.addr <= sym.addr && sym.addr+sym.size <= s.addr+s.size {
+			sect = s
+			break
+		}
+	}
+	if sect == nil || sect.data == nil {
+		return nil, fmt.Errorf("symbol %s has no data in section %s", sym.name, sym.section)
+	}
+	buf := make([]byte, sym.size)
+	if _, err := sect.data.ReadAt(buf, int64(sym.addr-sect.addr)); err != nil {
+		return nil, fmt.Errorf("reading symbol %s: %v", sym.name, err)
+	}
+	ptrs := make([]uint64, 0, len(buf)/b.ptrSize)
+	for i := 0; i+b.ptrSize <= len(buf); i += b.ptrSize {
+		if b.ptrSize == 8 {
+			ptrs = append(ptrs, b.byteOrder.Uint64(buf[i:]))
+		} else {
+			ptrs = append(ptrs, uint64(b.byteOrder.Uint32(buf[i:])))
+		}
+	}
+	return ptrs, nil
+}
+
+// functionNames returns the names of functions that have code in the
+// binary, including functions that were only inlined. Inlined functions
+// don't have symbols, but the DWARF of their callers refers to them.
+func (b *binaryFile) functionNames() (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, s := range b.symbols {
+		names[s.name] = true
+	}
+	if b.dwarf == nil {
+		return names, nil
+	}
+	r := b.dwarf.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == dwarf.TagSubprogram {
+			if name, ok := e.Val(dwarf.AttrName).(string); ok {
+				names[name] = true
+			}
+		}
+	}
+	return names, nil
+}
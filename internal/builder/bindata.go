@@ -0,0 +1,252 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bindataOptions are read from a go-bindata manifest. A manifest holds the
+// arguments a project used to pass to go-bindata, for example, in a
+// //go:generate comment or a Makefile. Arguments are separated by white
+// space, and lines starting with '#' are comments.
+//
+// The files to embed are named on the genembed command line, since Bazel
+// needs to know about them; positional arguments in the manifest are ignored.
+// -ignore patterns are matched against workspace-relative paths, with
+// generated files mapped to their source locations with -srcmap, so a
+// pattern doesn't depend on the output directory or configuration.
+// -debug and -dev aren't supported, since they read files at run time.
+type bindataOptions struct {
+	pkg, prefix string
+	ignore      []*regexp.Regexp
+	noCompress  bool
+	tags        string
+}
+
+// readBindataManifest parses a go-bindata manifest.
+func readBindataManifest(manifestPath string) (bindataOptions, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return bindataOptions{}, err
+	}
+	var args []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		args = append(args, strings.Fields(line)...)
+	}
+	if len(args) > 0 && (args[0] == "go-bindata" || strings.HasSuffix(args[0], "/go-bindata")) {
+		args = args[1:]
+	}
+
+	opts := bindataOptions{pkg: "main"}
+	var ignore []string
+	var debug, dev bool
+	var unused string
+	fs := flag.NewFlagSet(manifestPath, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&opts.pkg, "pkg", opts.pkg, "")
+	fs.StringVar(&opts.prefix, "prefix", "", "")
+	fs.Var(stringListFlag{&ignore}, "ignore", "")
+	fs.BoolVar(&opts.noCompress, "nocompress", false, "")
+	fs.StringVar(&opts.tags, "tags", "", "")
+	fs.BoolVar(&debug, "debug", false, "")
+	fs.BoolVar(&dev, "dev", false, "")
+	// These affect only the output location and metadata go-bindata records,
+	// which genembed doesn't generate.
+	fs.StringVar(&unused, "o", "", "")
+	fs.StringVar(&unused, "mode", "", "")
+	fs.StringVar(&unused, "modtime", "", "")
+	fs.Bool("nomemcopy", false, "")
+	fs.Bool("nometadata", false, "")
+	fs.Bool("fs", false, "")
+	if err := fs.Parse(args); err != nil {
+		return bindataOptions{}, fmt.Errorf("%s: %v", manifestPath, err)
+	}
+	if debug || dev {
+		return bindataOptions{}, fmt.Errorf("%s: -debug and -dev are not supported; embedded files can't be read from disk at run time", manifestPath)
+	}
+	for _, pattern := range ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return bindataOptions{}, fmt.Errorf("%s: -ignore: %v", manifestPath, err)
+		}
+		opts.ignore = append(opts.ignore, re)
+	}
+	return opts, nil
+}
+
+// genBindata generates a Go source file with the same API as files
+// generated by go-bindata: Asset, MustAsset, AssetNames, and AssetDir.
+// Assets are stored in a map named _bindata, gzip-compressed unless the
+// manifest says -nocompress. AssetInfo and RestoreAsset aren't generated,
+// since files in a Bazel sandbox have no meaningful metadata.
+//
+// Asset names are file names with -trim prefixes removed (so they're
+// relative to the directory go-bindata ran in), then the manifest's -prefix.
+// pkgName overrides the manifest's -pkg if set.
+func genBindata(outPath, pkgName, manifestPath string, trimPrefixes, srcPaths []string) error {
+	opts, err := readBindataManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if pkgName == "" {
+		pkgName = opts.pkg
+	}
+	if !token.IsIdentifier(pkgName) {
+		return fmt.Errorf("invalid package name %q", pkgName)
+	}
+
+	names := make(map[string]string)
+srcs:
+	for _, p := range srcPaths {
+		shortPath := filepath.ToSlash(mapSourcePath(p))
+		for _, re := range opts.ignore {
+			if re.MatchString(shortPath) {
+				continue srcs
+			}
+		}
+		name := strings.TrimPrefix(embedKey(p, false, trimPrefixes), opts.prefix)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s both have the asset name %q", other, p, name)
+		}
+		names[name] = p
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	f, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "// Code generated by genembed from %s. DO NOT EDIT.\n\n", manifestPath)
+	if opts.tags != "" {
+		fmt.Fprintf(w, "// +build %s\n\n", opts.tags)
+	}
+	fmt.Fprintf(w, "package %s\n\n", pkgName)
+	if opts.noCompress {
+		fmt.Fprintf(w, "import (\n\t\"fmt\"\n\t\"sort\"\n\t\"strings\"\n)\n\n")
+	} else {
+		fmt.Fprintf(w, "import (\n\t\"bytes\"\n\t\"compress/gzip\"\n\t\"fmt\"\n\t\"io/ioutil\"\n\t\"sort\"\n\t\"strings\"\n)\n\n")
+	}
+	fmt.Fprintf(w, "var _bindata = map[string][]byte{\n")
+	for _, name := range sortedNames {
+		fmt.Fprintf(w, "\t%s: ", strconv.Quote(name))
+		if err := writeEmbedValue(w, names[name], false, !opts.noCompress); err != nil {
+			f.abort()
+			return err
+		}
+		fmt.Fprintf(w, ",\n")
+	}
+	fmt.Fprintf(w, "}\n")
+	if opts.noCompress {
+		io.WriteString(w, bindataAssetPlain)
+	} else {
+		io.WriteString(w, bindataAssetGzip)
+	}
+	io.WriteString(w, bindataAPI)
+	if err := w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+const bindataAssetPlain = `
+// Asset loads and returns the asset for the given name.
+// It returns an error if the asset could not be found.
+func Asset(name string) ([]byte, error) {
+	data, ok := _bindata[strings.Replace(name, "\\", "/", -1)]
+	if !ok {
+		return nil, fmt.Errorf("Asset %s not found", name)
+	}
+	return data, nil
+}
+`
+
+const bindataAssetGzip = `
+// Asset loads and returns the asset for the given name.
+// It returns an error if the asset could not be found or
+// could not be loaded.
+func Asset(name string) ([]byte, error) {
+	data, ok := _bindata[strings.Replace(name, "\\", "/", -1)]
+	if !ok {
+		return nil, fmt.Errorf("Asset %s not found", name)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Read %q: %v", name, err)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+`
+
+const bindataAPI = `
+// MustAsset is like Asset but panics when Asset would return an error.
+func MustAsset(name string) []byte {
+	a, err := Asset(name)
+	if err != nil {
+		panic("asset: Asset(" + name + "): " + err.Error())
+	}
+	return a
+}
+
+// AssetNames returns the names of the assets.
+func AssetNames() []string {
+	names := make([]string, 0, len(_bindata))
+	for name := range _bindata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AssetDir returns the file names below a certain directory
+// in the embedded assets. An empty name returns the top level.
+func AssetDir(name string) ([]string, error) {
+	prefix := strings.Trim(strings.Replace(name, "\\", "/", -1), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := make(map[string]bool)
+	var children []string
+	for key := range _bindata {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		child := key[len(prefix):]
+		if i := strings.IndexByte(child, '/'); i >= 0 {
+			child = child[:i]
+		}
+		if !seen[child] {
+			seen[child] = true
+			children = append(children, child)
+		}
+	}
+	if len(children) == 0 {
+		return nil, fmt.Errorf("Asset %s not found", name)
+	}
+	sort.Strings(children)
+	return children, nil
+}
+`
@@ -0,0 +1,81 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestGenBindataIgnore checks that -ignore patterns match workspace-relative
+// paths, including for generated files under the output directory.
+func TestGenBindataIgnore(t *testing.T) {
+	defer func(old []srcMapping) { srcMap = old }(srcMap)
+	dir, err := ioutil.TempDir("", "TestGenBindataIgnore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	// Paths are relative to the execroot, as Bazel passes them.
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	binDir := filepath.Join("bazel-out", "k8-fastbuild", "bin")
+	srcPaths := []string{
+		filepath.Join("pkg", "keep.txt"),
+		filepath.Join("pkg", "skip.txt"),
+		filepath.Join(binDir, "pkg", "gen_keep.txt"),
+		filepath.Join(binDir, "pkg", "gen_skip.txt"),
+	}
+	for _, p := range srcPaths {
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(filepath.Base(p)), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// go_embed_data maps each generated file to its short path.
+	for _, name := range []string{"gen_keep.txt", "gen_skip.txt"} {
+		srcMap = append(srcMap, srcMapping{from: filepath.Join(binDir, "pkg", name), to: filepath.Join("pkg", name)})
+	}
+
+	manifestPath := "manifest"
+	manifest := `-pkg assets -ignore ^pkg/skip\.txt$ -ignore ^pkg/gen_skip\.txt$`
+	if err := ioutil.WriteFile(manifestPath, []byte(manifest), 0666); err != nil {
+		t.Fatal(err)
+	}
+	outPath := "bindata.go"
+	trim := []string{filepath.Join(binDir, "pkg"), "pkg"}
+	if err := genBindata(outPath, "", manifestPath, trim, srcPaths); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for name, want := range map[string]bool{
+		"keep.txt":     true,
+		"skip.txt":     false,
+		"gen_keep.txt": true,
+		"gen_skip.txt": false,
+	} {
+		if got := strings.Contains(out, strconv.Quote(name)); got != want {
+			t.Errorf("asset %s: got embedded %v; want %v", name, got, want)
+		}
+	}
+}
@@ -11,13 +11,14 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("builder: ")
-	if len(os.Args) <= 2 {
-		log.Fatalf("usage: %s stdimportcfg|compile|link|test options...", os.Args[0])
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: %s stdimportcfg|stdmanifest|inputdigests|compile|link|test|demangle|version|archive|combine|replay|apicheck|genembed|platforms|pack-layer|genstubs|deadapi|sizediff|disasm|dwarfcheck|debug|symbolize options...", os.Args[0])
 	}
 	verb := os.Args[1]
 	args := os.Args[2:]
@@ -26,18 +27,64 @@ func main() {
 	switch verb {
 	case "stdimportcfg":
 		action = stdImportcfg
+	case "stdmanifest":
+		action = stdManifestCmd
+	case "inputdigests":
+		action = inputDigests
 	case "compile":
 		action = compile
 	case "link":
 		action = link
 	case "test":
 		action = test
+	case "demangle":
+		action = demangle
+	case "version":
+		action = version
+	case "archive":
+		action = archiveCmd
+	case "combine":
+		action = combine
+	case "replay":
+		action = replay
+	case "apicheck":
+		action = apiCheck
+	case "genembed":
+		action = genEmbed
+	case "platforms":
+		action = platformsCmd
+	case "pack-layer":
+		action = packLayer
+	case "genstubs":
+		action = genStubs
+	case "deadapi":
+		action = deadAPI
+	case "sizediff":
+		action = sizeDiff
+	case "disasm":
+		action = disasm
+	case "dwarfcheck":
+		action = dwarfCheck
+	case "debug":
+		action = debug
+	case "symbolize":
+		action = symbolize
 	default:
 		log.Fatalf("unknown action: %s", verb)
 	}
 	log.SetPrefix(verb + ": ")
+	events.verb = verb
+	events.start = time.Now()
 
 	err := action(args)
+	if eventsErr := events.finish(err); eventsErr != nil && err == nil {
+		err = eventsErr
+	}
+	if err != nil && replayFilePath != "" {
+		if replayErr := writeReplayFile(replayFilePath, err); replayErr != nil {
+			log.Printf("writing replay file: %v", replayErr)
+		}
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,124 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// combine merges the members of several archives into one archive. Members
+// are written in order.
+//
+// Every archive written by the compiler has members named __.PKGDEF and
+// _go_.o, so names are expected to collide. Only the first archive's
+// __.PKGDEF is kept: it's the export data the combined archive is imported
+// by, and the other archives contribute only object code. Other members
+// whose names were already used are renamed with the number of the archive
+// they came from, like 2__go_.o, since tools that extract or look up
+// members by name would otherwise only see one.
+func combine(args []string) error {
+	// Process command line arguments.
+	var outPath string
+	fs := flag.NewFlagSet("combine", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "path to the combined archive")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if outPath == "" {
+		return errors.New("-o must be set")
+	}
+	if fs.NArg() == 0 {
+		return errors.New("expected at least one archive to combine")
+	}
+	if err := checkSandbox(append([]string{outPath}, fs.Args()...)...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+	return combineArchives(outPath, fs.Args())
+}
+
+// combineArchives writes the members of the archives at inPaths to a new
+// archive at outPath, as described for combine.
+func combineArchives(outPath string, inPaths []string) error {
+	// Read all the tables of contents first, so malformed archives are
+	// reported before anything is written.
+	type input struct {
+		path    string
+		f       *os.File
+		members []arMember
+	}
+	var inputs []input
+	defer func() {
+		for _, in := range inputs {
+			in.f.Close()
+		}
+	}()
+	for _, path := range inPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, input{path: path, f: f})
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		members, err := readArchive(f, fi.Size())
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		inputs[len(inputs)-1].members = members
+	}
+
+	w, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	aw, err := newArWriter(w)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	used := make(map[string]bool)
+	for i, in := range inputs {
+		for _, m := range in.members {
+			name := m.name
+			if used[name] {
+				if name == "__.PKGDEF" {
+					continue
+				}
+				name = combinedMemberName(name, i+1, used)
+			}
+			used[name] = true
+			r := io.NewSectionReader(in.f, m.offset, m.size)
+			if err := aw.writeMember(name, m.mode, m.size, r); err != nil {
+				w.abort()
+				return fmt.Errorf("%s: %v", in.path, err)
+			}
+		}
+	}
+	return w.commit()
+}
+
+// combinedMemberName returns a name for a member of the n'th input archive
+// whose name is already in used, prefixed with n and, if that's also used,
+// a counter.
+func combinedMemberName(name string, n int, used map[string]bool) string {
+	renamed := fmt.Sprintf("%d_%s", n, name)
+	for i := 2; used[renamed]; i++ {
+		renamed = fmt.Sprintf("%d.%d_%s", n, i, name)
+	}
+	return renamed
+}
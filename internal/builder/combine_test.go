@@ -0,0 +1,108 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestCombineArchives combines archives written by the compiler, which
+// always have members with the same names.
+func TestCombineArchives(t *testing.T) {
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir, err := ioutil.TempDir("", "TestCombineArchives")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var inPaths []string
+	for _, pkg := range []string{"a", "b"} {
+		srcPath := filepath.Join(dir, pkg+".go")
+		if err := ioutil.WriteFile(srcPath, []byte("package "+pkg+"\n\nfunc F() int { return 1 }\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		arcPath := filepath.Join(dir, pkg+".a")
+		cmd := exec.Command(goPath, "tool", "compile", "-pack", "-p", pkg, "-o", arcPath, srcPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("compiling %s: %v\n%s", pkg, err, out)
+		}
+		inPaths = append(inPaths, arcPath)
+	}
+
+	outPath := filepath.Join(dir, "combined.a")
+	if err := combineArchives(outPath, inPaths); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(path string) ([]byte, []arMember) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members, err := readArchive(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return data, members
+	}
+	memberData := func(data []byte, members []arMember, name string) []byte {
+		for _, m := range members {
+			if m.name == name {
+				return data[m.offset : m.offset+m.size]
+			}
+		}
+		t.Fatalf("no member named %s", name)
+		return nil
+	}
+	aData, aMembers := read(inPaths[0])
+	bData, bMembers := read(inPaths[1])
+	outData, outMembers := read(outPath)
+
+	var names []string
+	for _, m := range outMembers {
+		names = append(names, m.name)
+	}
+	if want := len(aMembers) + len(bMembers) - 1; len(outMembers) != want {
+		t.Fatalf("got members %q; want %d members", names, want)
+	}
+	pkgdefs := 0
+	for _, name := range names {
+		if name == "__.PKGDEF" {
+			pkgdefs++
+		}
+	}
+	if pkgdefs != 1 {
+		t.Errorf("got members %q; want exactly one __.PKGDEF", names)
+	}
+	if got, want := memberData(outData, outMembers, "__.PKGDEF"), memberData(aData, aMembers, "__.PKGDEF"); !bytes.Equal(got, want) {
+		t.Errorf("__.PKGDEF is not the first archive's export data")
+	}
+	if got, want := memberData(outData, outMembers, "_go_.o"), memberData(aData, aMembers, "_go_.o"); !bytes.Equal(got, want) {
+		t.Errorf("_go_.o is not the first archive's object")
+	}
+	if got, want := memberData(outData, outMembers, "2__go_.o"), memberData(bData, bMembers, "_go_.o"); !bytes.Equal(got, want) {
+		t.Errorf("2__go_.o is not the second archive's object")
+	}
+}
+
+func TestCombinedMemberName(t *testing.T) {
+	used := map[string]bool{"_go_.o": true, "2__go_.o": true}
+	if got, want := combinedMemberName("_go_.o", 3, used), "3__go_.o"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := combinedMemberName("_go_.o", 2, used), "2.2__go_.o"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
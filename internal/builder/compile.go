@@ -6,64 +6,203 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
-// compile produces a Go archive file (.a) from a list of .go sources.  This
+// compile produces a Go archive file (.a) from a list of .go sources, plus
+// optional assembly (.s) sources and headers (.h) they include. This
 // function will filter sources using build constraints (OS and architecture
 // file name suffixes and +build comments) and will build an importcfg file
-// before invoking the Go compiler.
+// before invoking the Go compiler. Directories among the sources are expanded
+// to the files they contain.
 func compile(args []string) error {
 	// Process command line arguments.
-	var stdImportcfgPath, packagePath, outPath string
+	var stdImportcfgPath, stdAllowlistPath, packagePath, label, outPath, exportPath, objDir, metadataPath, abiPath, asmOutPath string
+	var allowEmpty, explainSrcs, strict bool
 	var archives []archive
+	var graph depGraph
+	forbid := forbidFlag{make(map[string]bool)}
+	var policy importPolicy
 	fs := flag.NewFlagSet("compile", flag.ExitOnError)
 	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library")
-	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies, formatted as packagepath=file (may be repeated)")
+	fs.StringVar(&stdAllowlistPath, "std-allowlist", "", "path to a manifest of standard library import path patterns the target supports; other standard library imports are errors")
+	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies, formatted as packagepath=file or packagepath=file;optional (may be repeated)")
+	fs.Var(depLabelFlag{&graph}, "deplabel", "label of a direct or transitive dependency, formatted as packagepath=label (may be repeated)")
+	fs.Var(depEdgeFlag{&graph}, "depedge", "imports of a direct or transitive dependency, formatted as packagepath=imp1,imp2 (may be repeated)")
+	fs.Var(depsManifestFlag{&archives, &graph, true}, "deps-manifest", "JSON manifest describing dependencies, an alternative to -arc, -deplabel, and -depedge; only direct dependencies are imported")
 	fs.StringVar(&packagePath, "p", "", "package path for the package being compiled")
+	fs.StringVar(&label, "label", "", "label of the target being compiled, used in error messages")
 	fs.StringVar(&outPath, "o", "", "path to archive file the compiler should produce")
+	fs.StringVar(&exportPath, "export", "", "path to an archive containing only the package's export data, which is enough to compile importers")
+	fs.StringVar(&objDir, "objdir", "", "directory where the object files in the archive should be written")
+	fs.StringVar(&abiPath, "abi", "", "path to a file recording the export data each import was compiled against, checked by link")
+	fs.StringVar(&asmOutPath, "emit-asm", "", "path to a file where the compiler's assembly listing (compile -S) should be written")
+	fs.Var(crashReportFlag{}, "crash-report", "path where a tarball with the inputs of the compiler should be written if it crashes; an empty file is written otherwise")
+	fs.StringVar(&metadataPath, "metadata", "", "path to a JSON file describing the compiled package: its filtered sources, dependencies, platform, and output digests")
+	fs.Var(forbid, "forbid", "comma-separated list of features the sources may not use: cgo, linkname, unsafe (may be repeated)")
+	fs.Var(importPatternFlag{&policy.allowed}, "allow-import", "import path pattern the sources may import, like net or net/...; if given, all imports must match one (may be repeated)")
+	fs.Var(importPatternFlag{&policy.denied}, "deny-import", "import path pattern the sources may not import, like os/exec or net/... (may be repeated)")
+	fs.BoolVar(&explainSrcs, "explain-srcs", false, "print whether each source matches build constraints and, if not, which constraint excludes it")
+	addStdOverlapFlag(fs)
+	fs.BoolVar(&allowEmpty, "allow-empty", false, "produce an empty archive instead of failing when build constraints exclude all Go sources")
+	fs.BoolVar(&strict, "strict", false, "require -p; without it, the compiler and assembler don't qualify symbols with the package path")
+	addCommonFlags(fs)
+	addOptFlags(fs)
+	addToolFlags(fs)
+	addPlatformFlags(fs)
 	fs.Parse(args)
-	srcPaths := fs.Args()
+	events.addOutput(outPath)
+	for _, out := range []string{exportPath, metadataPath, abiPath, asmOutPath} {
+		if out != "" {
+			events.addOutput(out)
+		}
+	}
+	events.label = label
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if err := requirePackagePath(fs, strict); err != nil {
+		return err
+	}
+	srcArgs, err := expandSourceDirs(fs.Args())
+	if err != nil {
+		return err
+	}
+	srcGroups, err := classifySources(srcArgs, goKind, asmKind, headerKind)
+	if err != nil {
+		return err
+	}
+	srcPaths := srcGroups[goKind]
+	sandboxPaths := append([]string{stdImportcfgPath, stdAllowlistPath, outPath, exportPath, objDir, metadataPath, abiPath, asmOutPath}, fs.Args()...)
+	if err := checkSandbox(append(sandboxPaths, archivePaths(archives)...)...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+	if archives, err = skipMissingOptionalArchives(archives); err != nil {
+		return err
+	}
 
 	// Extract metadata from source files and filter out sources using
 	// build constraints.
 	srcs := make([]sourceInfo, 0, len(srcPaths))
 	filteredSrcPaths := make([]string, 0, len(srcPaths))
-	bctx := &build.Default
-	for _, srcPath := range srcPaths {
-		if src, err := loadSourceInfo(bctx, srcPath); err != nil {
+	var excludedPaths []string
+	bctx := target.buildContext()
+	if explainSrcs {
+		if err := explainSources(os.Stderr, bctx, append(srcPaths, srcGroups[asmKind]...)); err != nil {
 			return err
-		} else if src.match {
+		}
+	}
+	infos, err := loadSourceInfos(bctx, srcPaths)
+	if err != nil {
+		return err
+	}
+	for _, src := range infos {
+		if src.match {
 			srcs = append(srcs, src)
-			filteredSrcPaths = append(filteredSrcPaths, srcPath)
+			filteredSrcPaths = append(filteredSrcPaths, src.fileName)
+		} else {
+			excludedPaths = append(excludedPaths, src.fileName)
+		}
+	}
+	if len(srcs) == 0 {
+		if !allowEmpty {
+			return noMatchingSourcesError(bctx, excludedPaths)
+		}
+		emptyPath, err := writeEmptySource(excludedPaths)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(emptyPath)
+		filteredSrcPaths = append(filteredSrcPaths, emptyPath)
+	}
+	if err := checkPackageNames(packagePath, srcs); err != nil {
+		return err
+	}
+	if err := checkForbidden(forbid.features, filteredSrcPaths); err != nil {
+		return err
+	}
+	if err := checkImportPolicy(policy, filteredSrcPaths); err != nil {
+		return err
+	}
+	var filteredAsmPaths, excludedAsmPaths []string
+	for _, asmPath := range srcGroups[asmKind] {
+		if match, err := bctx.MatchFile(filepath.Dir(asmPath), filepath.Base(asmPath)); err != nil {
+			return err
+		} else if match {
+			filteredAsmPaths = append(filteredAsmPaths, asmPath)
+		} else {
+			excludedAsmPaths = append(excludedAsmPaths, asmPath)
 		}
 	}
+	if len(srcs) == 0 && len(filteredAsmPaths) > 0 {
+		return fmt.Errorf("%s: assembly sources match build constraints, but no Go sources do", filteredAsmPaths[0])
+	}
 
 	// Build an importcfg file that maps this package's imports to archive files
 	// from the standard library or direct dependencies.
-	stdArchiveMap, err := readImportcfg(stdImportcfgPath)
+	stdCfg, err := readImportcfgFile(stdImportcfgPath)
 	if err != nil {
 		return err
 	}
+	if err := checkStdTarget(stdCfg, stdImportcfgPath); err != nil {
+		return err
+	}
+	stdArchiveMap := stdCfg.archives
+	stdAllow, err := readStdAllowlist(stdAllowlistPath)
+	if err != nil {
+		return err
+	}
+	if err := stdAllow.check(srcs, stdArchiveMap); err != nil {
+		return err
+	}
+	if archives, err = resolveStdOverlap(stdArchiveMap, archives); err != nil {
+		return err
+	}
 
 	directArchiveMap := make(map[string]string)
+	directPkgPaths := make([]string, 0, len(archives))
 	for _, arc := range archives {
 		directArchiveMap[arc.packagePath] = arc.filePath
+		directPkgPaths = append(directPkgPaths, arc.packagePath)
+	}
+
+	// Check that no dependency imports the package being compiled. Packages
+	// in a cycle may come from different targets that declare the same
+	// import path, so Bazel won't detect this.
+	if packagePath != "" {
+		if cycle := graph.findCycle(packagePath, directPkgPaths); cycle != nil {
+			return graph.cycleError(cycle, label)
+		}
 	}
 
 	archiveMap := make(map[string]string)
+	importSet := make(map[string]bool)
 	for _, src := range srcs {
 		for _, imp := range src.imports {
+			importSet[imp] = true
 			switch {
 			case imp == "unsafe":
 				continue
 
+			case imp == packagePath:
+				return fmt.Errorf("%s: %v", mapSourcePath(src.fileName), graph.cycleError([]string{imp, imp}, label))
+
 			case imp == "C":
-				return fmt.Errorf("%s: cgo not supported", src.fileName)
+				return fmt.Errorf("%s: cgo is on, but cgo packages are not supported", mapSourcePath(src.fileName))
 
 			case stdArchiveMap[imp] != "":
 				archiveMap[imp] = stdArchiveMap[imp]
@@ -71,35 +210,273 @@ func compile(args []string) error {
 			case directArchiveMap[imp] != "":
 				archiveMap[imp] = directArchiveMap[imp]
 
+			case graph.labels[imp] != "":
+				return fmt.Errorf("%s: import %q is not provided by any direct dependency; it's already in the transitive closure, provided by %s, which must be added as a direct dependency", mapSourcePath(src.fileName), imp, graph.labels[imp])
+
 			default:
-				return fmt.Errorf("%s: import %q is not provided by any direct dependency", src.fileName, imp)
+				return fmt.Errorf("%s: import %q is not provided by any direct dependency; add a dependency on a library that provides it", mapSourcePath(src.fileName), imp)
 			}
 		}
 	}
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	importcfgPath, err := writeTempImportcfg(archiveMap, stdCfg.toolLines("compile")...)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(importcfgPath)
 
-	// Invoke the compiler.
-	return runCompiler(packagePath, importcfgPath, filteredSrcPaths, outPath)
+	// Invoke the compiler, and the assembler if there are assembly sources.
+	if len(filteredAsmPaths) > 0 {
+		progress.plan(4)
+	} else {
+		progress.plan(1)
+	}
+	if exportPath != "" || objDir != "" {
+		progress.plan(1)
+	}
+	for _, out := range []string{abiPath, asmOutPath, metadataPath} {
+		if out != "" {
+			progress.plan(1)
+		}
+	}
+	archiveOut, err := newAtomicOutput(outPath)
+	if err != nil {
+		return err
+	}
+	defer archiveOut.cleanup()
+	if len(filteredAsmPaths) > 0 {
+		err = compileWithAsm(packagePath, importcfgPath, filteredSrcPaths, filteredAsmPaths, srcGroups[headerKind], archiveOut.tmpPath())
+	} else {
+		progress.stepf("compiling %d Go files", len(filteredSrcPaths))
+		err = runCompiler(packagePath, importcfgPath, filteredSrcPaths, archiveOut.tmpPath())
+	}
+	if err != nil {
+		return err
+	}
+	if err := archiveOut.commit(); err != nil {
+		return err
+	}
+
+	// Split the archive into other outputs, if requested.
+	if exportPath != "" || objDir != "" {
+		progress.stepf("splitting archive")
+	}
+	if err := splitArchive(outPath, exportPath, objDir); err != nil {
+		return err
+	}
+	imports := make([]string, 0, len(importSet))
+	for imp := range importSet {
+		imports = append(imports, imp)
+	}
+	if abiPath != "" {
+		progress.stepf("writing ABI file")
+		if err := writeABIFile(abiPath, imports, archiveMap); err != nil {
+			return err
+		}
+	}
+	if asmOutPath != "" {
+		progress.stepf("writing assembly listing")
+		if err := emitAssembly(packagePath, importcfgPath, filteredSrcPaths, asmOutPath); err != nil {
+			return err
+		}
+	}
+	if metadataPath != "" {
+		progress.stepf("writing metadata")
+		md := packageMetadata{
+			ImportPath: packagePath,
+			Label:      label,
+			Srcs:       append(append([]string{}, filteredSrcPaths...), filteredAsmPaths...),
+			Excluded:   append(append([]string{}, excludedPaths...), excludedAsmPaths...),
+			Deps:       directPkgPaths,
+		}
+		if len(srcs) == 0 {
+			// Don't report the temporary file written for -allow-empty.
+			md.Srcs = []string{}
+		}
+		md.Imports = imports
+		return writeMetadata(metadataPath, md, outPath, exportPath)
+	}
+	return nil
 }
 
-func runCompiler(packagePath, importcfgPath string, srcPaths []string, outPath string) error {
-	args := []string{"tool", "compile"}
+// runCompiler invokes the Go compiler. extraArgs are passed to the compiler
+// before the list of sources.
+func runCompiler(packagePath, importcfgPath string, srcPaths []string, outPath string, extraArgs ...string) error {
+	cmd, err := compilerCommand(packagePath, importcfgPath, srcPaths, outPath, extraArgs...)
+	if err != nil {
+		return err
+	}
+	out, err := runToolOutput(cmd)
+	if err != nil {
+		reportCompilerCrash(cmd, out, err)
+	}
+	return err
+}
+
+// emitAssembly compiles a package again with -S and writes the assembly
+// listing the compiler prints to asmOutPath. This is done in a separate
+// invocation after the package compiles successfully, since the compiler
+// prints errors and the listing to the same stream.
+func emitAssembly(packagePath, importcfgPath string, srcPaths []string, asmOutPath string) error {
+	outDir, err := createTempDir("emitasm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+	cmd, err := compilerCommand(packagePath, importcfgPath, srcPaths, filepath.Join(outDir, "out.a"), "-S")
+	if err != nil {
+		return err
+	}
+	w, err := createAtomic(asmOutPath)
+	if err != nil {
+		return err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Env = toolEnv()
+	cmd.Stdout = w.File
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		w.abort()
+		writeDiagnostics(io.MultiWriter(os.Stderr, events.stderrWriter()), stderr.Bytes(), diagOpts)
+		if logErr := appendFullLog(stderr.Bytes()); logErr != nil {
+			log.Print(logErr)
+		}
+		return fmt.Errorf("compiling with -S: %v", err)
+	}
+	return w.commit()
+}
+
+// compilerCommand returns a command that invokes the Go compiler.
+func compilerCommand(packagePath, importcfgPath string, srcPaths []string, outPath string, extraArgs ...string) (*exec.Cmd, error) {
+	compiler, err := tools.path("compiler", tools.compiler)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
 	if packagePath != "" {
 		args = append(args, "-p", packagePath)
 	}
 	args = append(args, "-importcfg", importcfgPath)
+	trimpath, err := trimpathArgs()
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, trimpath...)
+	args = append(args, optArgs()...)
+	args = append(args, extraArgs...)
 	args = append(args, "-o", outPath, "--")
 	args = append(args, srcPaths...)
-	goTool, err := findGoTool()
+	return exec.Command(compiler, args...), nil
+}
+
+// noMatchingSourcesError returns an error explaining why each of the
+// excluded files didn't match build constraints.
+func noMatchingSourcesError(bctx *build.Context, excludedPaths []string) error {
+	if len(excludedPaths) == 0 {
+		return fmt.Errorf("no Go sources")
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "build constraints exclude all Go sources for GOOS=%s GOARCH=%s:", bctx.GOOS, bctx.GOARCH)
+	for _, path := range excludedPaths {
+		reasons, err := explainConstraints(bctx, path)
+		if err != nil {
+			return err
+		}
+		if len(reasons) == 0 {
+			reasons = []string{"excluded by build constraints"}
+		}
+		fmt.Fprintf(b, "\n\t%s: %s", path, strings.Join(reasons, "; "))
+	}
+	b.WriteString("\nuse -allow-empty to produce an empty archive")
+	return fmt.Errorf("%s", b.String())
+}
+
+// writeEmptySource writes a temporary Go source file containing only a
+// package clause, so the compiler can produce an empty archive. The package
+// name is taken from the excluded sources.
+func writeEmptySource(excludedPaths []string) (string, error) {
+	if len(excludedPaths) == 0 {
+		return "", fmt.Errorf("no Go sources")
+	}
+	fset := token.NewFileSet()
+	tree, err := parser.ParseFile(fset, excludedPaths[0], nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	f, err := createTempFile("empty-*.go")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "package %s\n", tree.Name.Name); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// splitArchive writes parts of a compiled archive to separate outputs. If
+// exportPath is set, an archive containing only the export data member
+// (__.PKGDEF) is written there. If objDir is set, every other member (the
+// compiled Go object, _go_.o, and assembled objects) is written as a
+// separate file in that directory.
+func splitArchive(archivePath, exportPath, objDir string) error {
+	if exportPath == "" && objDir == "" {
+		return nil
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command(goTool, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	members, err := readArchive(f, fi.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %v", archivePath, err)
+	}
+
+	if exportPath != "" {
+		w, err := createAtomic(exportPath)
+		if err != nil {
+			return err
+		}
+		aw, err := newArWriter(w)
+		if err != nil {
+			w.abort()
+			return err
+		}
+		for _, m := range members {
+			if m.name != "__.PKGDEF" {
+				continue
+			}
+			if err := aw.writeMember(m.name, m.mode, m.size, io.NewSectionReader(f, m.offset, m.size)); err != nil {
+				w.abort()
+				return err
+			}
+		}
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+
+	if objDir != "" {
+		if err := os.MkdirAll(objDir, 0777); err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m.name == "__.PKGDEF" {
+				continue
+			}
+			if err := extractMember(f, m, filepath.Join(objDir, filepath.Base(m.name))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
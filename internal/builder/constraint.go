@@ -0,0 +1,215 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// knownOS and knownArch are the operating systems and architectures that
+// may appear as file name suffixes, as in go/build in Go 1.13.
+var knownOS = stringSet("aix android darwin dragonfly freebsd illumos js linux nacl netbsd openbsd plan9 solaris windows zos")
+
+var knownArch = stringSet("386 amd64 amd64p32 arm armbe arm64 arm64be ppc64 ppc64le mips mipsle mips64 mips64le mips64p32 mips64p32le ppc riscv riscv64 s390 s390x sparc sparc64 wasm")
+
+func stringSet(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, f := range strings.Fields(s) {
+		m[f] = true
+	}
+	return m
+}
+
+// explainConstraints returns the reasons a source file is excluded by build
+// constraints in bctx: a GOOS or GOARCH file name suffix, a build constraint
+// comment that isn't satisfied, or an import of "C" when cgo is off. It
+// returns nil if the file matches. loadSourceInfo should be used to decide
+// whether a file matches; explainConstraints is only for error messages.
+// Constraint comments are checked with build.Context.MatchFile, like in
+// loadSourceInfo, so explanations follow the same rules. In Go 1.13, that
+// means "// +build" lines are read, and "//go:build" lines are ignored.
+func explainConstraints(bctx *build.Context, path string) ([]string, error) {
+	var reasons []string
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+		reasons = append(reasons, "file names beginning with _ or . are ignored")
+	}
+	if ok, err := matchHeader(bctx, name); err != nil {
+		return nil, err
+	} else if !ok {
+		if reason := explainFileName(bctx, name); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	goBuild, plusBuild, err := readConstraintLines(path)
+	if err != nil {
+		return nil, err
+	}
+	goBuildOK := true
+	if goBuild != "" {
+		// If go/build reads //go:build lines, it ignores +build lines.
+		if goBuildOK, err = matchHeader(bctx, "header.go", goBuild); err != nil {
+			return nil, err
+		} else if !goBuildOK {
+			reasons = append(reasons, fmt.Sprintf("%q is not satisfied", goBuild))
+		}
+	}
+	if goBuildOK {
+		for _, line := range plusBuild {
+			if ok, err := matchHeader(bctx, "header.go", line); err != nil {
+				return nil, err
+			} else if !ok {
+				reasons = append(reasons, fmt.Sprintf("%q is not satisfied", line))
+			}
+		}
+	}
+
+	if !bctx.CgoEnabled && strings.HasSuffix(path, ".go") {
+		if tree, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly); err == nil && importsC(tree) {
+			reasons = append(reasons, `imports "C", but cgo is off`)
+		}
+	}
+	return reasons, nil
+}
+
+// explainSources writes a line to w for each source file, saying whether
+// it matches build constraints in bctx and, if not, why it's excluded.
+func explainSources(w io.Writer, bctx *build.Context, paths []string) error {
+	for _, path := range paths {
+		var match bool
+		var err error
+		if strings.HasSuffix(path, ".go") {
+			var src sourceInfo
+			src, err = loadSourceInfo(bctx, path)
+			match = src.match
+		} else {
+			match, err = bctx.MatchFile(filepath.Dir(path), filepath.Base(path))
+		}
+		if err != nil {
+			return err
+		}
+		if match {
+			fmt.Fprintf(w, "%s: matched\n", path)
+			continue
+		}
+		reasons, err := explainConstraints(bctx, path)
+		if err != nil {
+			return err
+		}
+		if len(reasons) == 0 {
+			reasons = []string{"excluded by build constraints"}
+		}
+		fmt.Fprintf(w, "%s: excluded: %s\n", path, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// explainFileName returns why a file name's GOOS or GOARCH suffix doesn't
+// match bctx, or "" if it matches. Suffixes are recognized as in
+// build.Context.MatchFile: name_GOOS, name_GOARCH, or name_GOOS_GOARCH,
+// optionally followed by _test.
+func explainFileName(bctx *build.Context, name string) string {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[:dot]
+	}
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return ""
+	}
+	l := strings.Split(name[i:], "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+	n := len(l)
+	if n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		if matchTag(bctx, l[n-2]) && matchTag(bctx, l[n-1]) {
+			return ""
+		}
+		return fmt.Sprintf("file name suffix _%s_%s does not match GOOS=%s GOARCH=%s", l[n-2], l[n-1], bctx.GOOS, bctx.GOARCH)
+	}
+	if n >= 1 && knownOS[l[n-1]] && !matchTag(bctx, l[n-1]) {
+		return fmt.Sprintf("file name suffix _%s does not match GOOS=%s", l[n-1], bctx.GOOS)
+	}
+	if n >= 1 && knownArch[l[n-1]] && !matchTag(bctx, l[n-1]) {
+		return fmt.Sprintf("file name suffix _%s does not match GOARCH=%s", l[n-1], bctx.GOARCH)
+	}
+	return ""
+}
+
+// readConstraintLines returns the //go:build line and +build lines from
+// the header of a source file. Like go/build, only comments before the
+// package clause that are followed by a blank line are considered.
+func readConstraintLines(path string) (goBuild string, plusBuild []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var pending []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			// Comments so far are separated from the package clause.
+			for _, c := range pending {
+				if isGoBuildLine(c) && goBuild == "" {
+					goBuild = c
+				} else if fields := strings.Fields(c); len(fields) > 1 && fields[0] == "//" && fields[1] == "+build" {
+					plusBuild = append(plusBuild, c)
+				}
+			}
+			pending = nil
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		pending = append(pending, line)
+	}
+	return goBuild, plusBuild, sc.Err()
+}
+
+func isGoBuildLine(line string) bool {
+	return strings.HasPrefix(line, "//go:build") &&
+		(len(line) == len("//go:build") || line[len("//go:build")] == ' ' || line[len("//go:build")] == '\t')
+}
+
+// matchHeader reports whether go/build, as linked into the builder,
+// accepts a file with the given base name whose header is the given
+// constraint comment lines.
+func matchHeader(bctx *build.Context, name string, lines ...string) (bool, error) {
+	content := strings.Join(lines, "\n") + "\n\npackage p\n"
+	ctx := *bctx
+	ctx.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	}
+	return ctx.MatchFile(".", name)
+}
+
+// matchTag reports whether a GOOS or GOARCH file name suffix is satisfied
+// by bctx, as in go/build in Go 1.13.
+func matchTag(bctx *build.Context, tag string) bool {
+	switch {
+	case tag == bctx.GOOS || tag == bctx.GOARCH:
+		return true
+	case tag == "linux" && bctx.GOOS == "android":
+		return true
+	case tag == "solaris" && bctx.GOOS == "illumos":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExplainSources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []struct {
+		name, content string
+	}{
+		{"ok.go", "package a\n"},
+		{"a_windows.go", "package a\n"},
+		{"a_linux_arm64.go", "package a\n"},
+		{"plus.go", "// +build ignore\n\npackage a\n"},
+		{"goline.go", "//go:build ignore\n\npackage a\n"},
+		{"both.go", "//go:build linux\n// +build ignore\n\npackage a\n"},
+		{"cgo.go", "package a\n\nimport \"C\"\n"},
+	}
+	var paths []string
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := ioutil.WriteFile(path, []byte(f.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	bctx := build.Default
+	bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled = "linux", "amd64", false
+	buf := &bytes.Buffer{}
+	if err := explainSources(buf, &bctx, paths); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(paths) {
+		t.Fatalf("got %d lines; want %d:\n%s", len(lines), len(paths), buf.String())
+	}
+
+	// Whether each file matches comes from go/build, which only reads
+	// //go:build lines in releases newer than Go 1.13, so explanations are
+	// checked for agreement with it. both.go matches if go/build reads its
+	// //go:build line, and otherwise it's excluded by its +build line.
+	wantReason := map[string]string{
+		"a_windows.go":     "file name suffix _windows does not match GOOS=linux",
+		"a_linux_arm64.go": "file name suffix _linux_arm64 does not match GOOS=linux GOARCH=amd64",
+		"plus.go":          `"// +build ignore" is not satisfied`,
+		"goline.go":        `"//go:build ignore" is not satisfied`,
+		"both.go":          `"// +build ignore" is not satisfied`,
+		"cgo.go":           `imports "C", but cgo is off`,
+	}
+	for i, path := range paths {
+		src, err := loadSourceInfo(&bctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := files[i].name
+		if src.match {
+			if want := path + ": matched"; lines[i] != want {
+				t.Errorf("got %q; want %q", lines[i], want)
+			}
+			continue
+		}
+		want := path + ": excluded: " + wantReason[name]
+		if wantReason[name] == "" || lines[i] != want {
+			t.Errorf("got %q; want %q", lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// crashReportPath is set by the -crash-report flag. If the compiler
+// crashes, a tarball with everything needed to reproduce the crash is
+// written there.
+var crashReportPath string
+
+// crashReportFlag sets the path of the crash report. An empty file is
+// created when the flag is parsed, so it exists even if the compiler
+// doesn't crash, as Bazel requires of declared outputs.
+type crashReportFlag struct{}
+
+func (crashReportFlag) String() string { return crashReportPath }
+
+func (crashReportFlag) Set(path string) error {
+	crashReportPath = path
+	return ioutil.WriteFile(path, nil, 0666)
+}
+
+// isCompilerCrash returns whether a failed compiler invocation crashed,
+// as opposed to reporting errors in the sources. The compiler crashed if it
+// was killed by a signal, reported an internal compiler error, or panicked.
+func isCompilerCrash(out []byte, err error) bool {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == -1 {
+		return true
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, "internal compiler error") ||
+			strings.HasPrefix(line, "panic: ") ||
+			strings.HasPrefix(line, "fatal error: ") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCrashReport writes a gzipped tarball containing the inputs of a
+// compiler command that crashed, the compiler's output and version, and a
+// script that runs the command again. Files are stored under repro/, and
+// the script refers to them with relative paths, so the tarball can be
+// unpacked and run on another machine with the same Go version. Inputs,
+// which include every archive the compiler could import, are copied into
+// the tarball as it's written, so they're never all in memory at once.
+func writeCrashReport(reportPath string, cmd *exec.Cmd, out []byte) (err error) {
+	f, err := createAtomic(reportPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.abort()
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	gz := gzip.NewWriter(bw)
+	tw := tar.NewWriter(gz)
+	addFile := func(name string, data []byte, mode int64) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: path.Join("repro", name),
+			Mode: mode,
+			Size: int64(len(data)),
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	added := make(map[string]bool)
+	addPath := func(name, p string) error {
+		if added[name] {
+			return nil
+		}
+		added[name] = true
+		r, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		fi, err := r.Stat()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: path.Join("repro", name),
+			Mode: 0666,
+			Size: fi.Size(),
+		}); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		return err
+	}
+
+	// localName returns the name of an input file within the tarball.
+	localNames := make(map[string]string)
+	localName := func(p string) string {
+		if name, ok := localNames[p]; ok {
+			return name
+		}
+		name := filepath.ToSlash(filepath.Clean(p))
+		name = strings.TrimLeft(strings.Replace(name, "../", "", -1), "/")
+		name = path.Join("files", name)
+		localNames[p] = name
+		return name
+	}
+
+	args := cmd.Args[1:]
+	var scriptArgs []string
+	for i, arg := range args {
+		prev := ""
+		if i > 0 {
+			prev = args[i-1]
+		}
+		switch {
+		case prev == "-o" || prev == "-asmhdr":
+			scriptArgs = append(scriptArgs, path.Join("out", filepath.Base(arg)))
+
+		case prev == "-importcfg":
+			importcfg, err := rewriteImportcfg(arg, localName, addPath)
+			if err != nil {
+				return err
+			}
+			if err := addFile("importcfg", importcfg, 0666); err != nil {
+				return err
+			}
+			scriptArgs = append(scriptArgs, "importcfg")
+
+		case isRegularFile(arg):
+			name := localName(arg)
+			if err := addPath(name, arg); err != nil {
+				return err
+			}
+			scriptArgs = append(scriptArgs, name)
+
+		default:
+			scriptArgs = append(scriptArgs, arg)
+		}
+	}
+
+	version, err := exec.Command(cmd.Path, "-V").CombinedOutput()
+	if err != nil {
+		version = []byte(fmt.Sprintf("%s -V: %v\n", cmd.Path, err))
+	}
+	env := strings.Join(cmd.Env, "\n") + "\n"
+	script := &strings.Builder{}
+	fmt.Fprintf(script, "#!/bin/sh\n")
+	fmt.Fprintf(script, "# Reproduces a crash of the Go compiler. Use the same version of Go\n")
+	fmt.Fprintf(script, "# (see version.txt). Set GO_COMPILER to the compiler's path if\n")
+	fmt.Fprintf(script, "# 'go tool compile' isn't the right one.\n")
+	fmt.Fprintf(script, "cd \"$(dirname \"$0\")\"\n")
+	fmt.Fprintf(script, "mkdir -p out\n")
+	fmt.Fprintf(script, "${GO_COMPILER:-go tool compile} %s\n", shellQuote(scriptArgs))
+	for _, f := range []struct {
+		name string
+		data []byte
+		mode int64
+	}{
+		{"repro.sh", []byte(script.String()), 0777},
+		{"version.txt", version, 0666},
+		{"env.txt", []byte(env), 0666},
+		{"output.txt", out, 0666},
+	} {
+		if err := addFile(f.name, f.data, f.mode); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// rewriteImportcfg adds the archives named in an importcfg file to a crash
+// report and returns a copy of the file that refers to them by their names
+// in the report.
+func rewriteImportcfg(importcfgPath string, localName func(string) string, addPath func(name, path string) error) ([]byte, error) {
+	f, err := os.Open(importcfgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := &bytes.Buffer{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "packagefile ") {
+			if i := strings.IndexByte(line, '='); i >= 0 {
+				arcPath := line[i+1:]
+				name := localName(arcPath)
+				if err := addPath(name, arcPath); err != nil {
+					return nil, err
+				}
+				line = line[:i+1] + name
+			}
+		}
+		fmt.Fprintln(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func isRegularFile(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// shellQuote quotes arguments for a POSIX shell.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=+,:", r))
+		}) < 0 {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// reportCompilerCrash writes a crash report if -crash-report is set and a
+// failed compiler invocation crashed, then explains how to use it.
+func reportCompilerCrash(cmd *exec.Cmd, out []byte, err error) {
+	if crashReportPath == "" || !isCompilerCrash(out, err) {
+		return
+	}
+	w := io.MultiWriter(os.Stderr, events.stderrWriter())
+	if reportErr := writeCrashReport(crashReportPath, cmd, out); reportErr != nil {
+		fmt.Fprintf(w, "warning: the compiler crashed, but a crash report could not be written: %v\n", reportErr)
+		return
+	}
+	fmt.Fprintf(w, "note: the compiler crashed; its inputs were saved to %s. To reproduce:\n\ttar -xzf %s && sh repro/repro.sh\n", crashReportPath, crashReportPath)
+}
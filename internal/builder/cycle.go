@@ -0,0 +1,210 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// depGraph is the import graph declared by a target's dependencies. Bazel
+// rejects cycles between targets, but two targets may declare the same
+// import path, which can still produce a cycle between packages. Nodes are
+// package paths. Each node may have the label of the Bazel target that
+// declared it, which is used when reporting errors.
+type depGraph struct {
+	labels map[string]string
+	edges  map[string][]string
+
+	// direct records which packages are direct dependencies of the package
+	// being linked. It's nil if that isn't known, for example, when all
+	// dependencies are passed with -arc.
+	direct map[string]bool
+}
+
+// markDirect records that a package is a direct dependency.
+func (g *depGraph) markDirect(pkgPath string) {
+	if g.direct == nil {
+		g.direct = make(map[string]bool)
+	}
+	g.direct[pkgPath] = true
+}
+
+// importers returns the packages in the graph that import pkgPath, sorted.
+func (g depGraph) importers(pkgPath string) []string {
+	var importers []string
+	for from, imports := range g.edges {
+		for _, imp := range imports {
+			if imp == pkgPath {
+				importers = append(importers, from)
+				break
+			}
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+// depLabelFlag parses labels from command line arguments. Values have the
+// form "packagePath=label".
+type depLabelFlag struct {
+	graph *depGraph
+}
+
+func (f depLabelFlag) String() string {
+	if f.graph == nil {
+		return ""
+	}
+	return fmt.Sprint(f.graph.labels)
+}
+
+func (f depLabelFlag) Set(value string) error {
+	pos := strings.IndexByte(value, '=')
+	if pos < 0 {
+		return fmt.Errorf("malformed -deplabel flag: %q", value)
+	}
+	if f.graph.labels == nil {
+		f.graph.labels = make(map[string]string)
+	}
+	f.graph.labels[value[:pos]] = value[pos+1:]
+	return nil
+}
+
+// depEdgeFlag parses edges from command line arguments. Values have the form
+// "packagePath=importPath1,importPath2". The list of imports may be empty.
+type depEdgeFlag struct {
+	graph *depGraph
+}
+
+func (f depEdgeFlag) String() string {
+	if f.graph == nil {
+		return ""
+	}
+	return fmt.Sprint(f.graph.edges)
+}
+
+func (f depEdgeFlag) Set(value string) error {
+	pos := strings.IndexByte(value, '=')
+	if pos < 0 {
+		return fmt.Errorf("malformed -depedge flag: %q", value)
+	}
+	if f.graph.edges == nil {
+		f.graph.edges = make(map[string][]string)
+	}
+	from := value[:pos]
+	var imports []string
+	if value[pos+1:] != "" {
+		imports = strings.Split(value[pos+1:], ",")
+	}
+	f.graph.edges[from] = append(f.graph.edges[from], imports...)
+	return nil
+}
+
+// findCycle returns a path of package paths that starts at from and leads
+// back to from, or nil if there is no such path. imports is the list of
+// packages from imports directly; it's used instead of any edges recorded
+// for from in the graph.
+func (g *depGraph) findCycle(from string, imports []string) []string {
+	visited := make(map[string]bool)
+	var path []string
+	var visit func(pkgPath string) bool
+	visit = func(pkgPath string) bool {
+		path = append(path, pkgPath)
+		if pkgPath == from && len(path) > 1 {
+			return true
+		}
+		if !visited[pkgPath] {
+			visited[pkgPath] = true
+			next := g.edges[pkgPath]
+			if len(path) == 1 {
+				next = imports
+			}
+			for _, imp := range next {
+				if visit(imp) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(from) {
+		return path
+	}
+	return nil
+}
+
+// findAnyCycle returns a path of package paths that starts and ends with the
+// same package, or nil if the graph has no cycles. Packages are visited in
+// sorted order, so the result is deterministic.
+func (g *depGraph) findAnyCycle() []string {
+	pkgPaths := make([]string, 0, len(g.edges))
+	for pkgPath := range g.edges {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(pkgPath string) []string
+	visit = func(pkgPath string) []string {
+		switch state[pkgPath] {
+		case visiting:
+			for i, p := range path {
+				if p == pkgPath {
+					return append(append([]string(nil), path[i:]...), pkgPath)
+				}
+			}
+		case done:
+			return nil
+		}
+		state[pkgPath] = visiting
+		path = append(path, pkgPath)
+		for _, imp := range g.edges[pkgPath] {
+			if cycle := visit(imp); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[pkgPath] = done
+		return nil
+	}
+	for _, pkgPath := range pkgPaths {
+		if cycle := visit(pkgPath); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// cycleError formats an error describing an import cycle. firstLabel is
+// the label of the first package in the cycle; if it's empty, the label
+// recorded in the graph is used instead.
+func (g *depGraph) cycleError(cycle []string, firstLabel string) error {
+	b := &strings.Builder{}
+	b.WriteString("import cycle not allowed:")
+	for i, pkgPath := range cycle {
+		b.WriteString("\n\t")
+		if i > 0 {
+			b.WriteString("imports ")
+		}
+		b.WriteString(pkgPath)
+		label := g.labels[pkgPath]
+		if i == 0 && firstLabel != "" {
+			label = firstLabel
+		}
+		if label != "" {
+			fmt.Fprintf(b, " (%s)", label)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
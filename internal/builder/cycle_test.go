@@ -0,0 +1,121 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCycle(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		edges   map[string][]string
+		from    string
+		imports []string
+		want    []string
+	}{
+		{
+			desc:    "self_import",
+			from:    "a",
+			imports: []string{"a"},
+			want:    []string{"a", "a"},
+		}, {
+			desc:    "indirect",
+			edges:   map[string][]string{"b": {"c"}, "c": {"a"}},
+			from:    "a",
+			imports: []string{"b"},
+			want:    []string{"a", "b", "c", "a"},
+		}, {
+			// Edges recorded for from are replaced by its imports.
+			desc:    "stale_edges",
+			edges:   map[string][]string{"a": {"a"}, "b": {"c"}},
+			from:    "a",
+			imports: []string{"b"},
+		}, {
+			desc:    "cycle_not_through_from",
+			edges:   map[string][]string{"b": {"c"}, "c": {"b"}},
+			from:    "a",
+			imports: []string{"b"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := &depGraph{edges: tc.edges}
+			if got := g.findCycle(tc.from, tc.imports); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindAnyCycle(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		edges map[string][]string
+		want  []string
+	}{
+		{
+			desc:  "none",
+			edges: map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": nil},
+		}, {
+			desc:  "self_import",
+			edges: map[string][]string{"a": nil, "b": {"b"}},
+			want:  []string{"b", "b"},
+		}, {
+			desc:  "indirect",
+			edges: map[string][]string{"main": {"x"}, "x": {"y"}, "y": {"z"}, "z": {"x"}},
+			want:  []string{"x", "y", "z", "x"},
+		}, {
+			// Both cycles are reachable; the one found first in sorted
+			// order is reported every time.
+			desc:  "deterministic",
+			edges: map[string][]string{"d": {"e"}, "e": {"d"}, "b": {"c"}, "c": {"b"}},
+			want:  []string{"b", "c", "b"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := &depGraph{edges: tc.edges}
+			for i := 0; i < 10; i++ {
+				if got := g.findAnyCycle(); !reflect.DeepEqual(got, tc.want) {
+					t.Fatalf("got %q; want %q", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCycleError(t *testing.T) {
+	g := &depGraph{
+		labels: map[string]string{"a": "//a:old", "b": "//b"},
+	}
+	for _, tc := range []struct {
+		desc, firstLabel, want string
+		cycle                  []string
+	}{
+		{
+			desc:  "graph_labels",
+			cycle: []string{"a", "b", "c", "a"},
+			want: "import cycle not allowed:\n" +
+				"\ta (//a:old)\n" +
+				"\timports b (//b)\n" +
+				"\timports c\n" +
+				"\timports a (//a:old)",
+		}, {
+			desc:       "first_label",
+			cycle:      []string{"a", "a"},
+			firstLabel: "//a",
+			want: "import cycle not allowed:\n" +
+				"\ta (//a)\n" +
+				"\timports a (//a:old)",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := g.cycleError(tc.cycle, tc.firstLabel).Error(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
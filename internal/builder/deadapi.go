@@ -0,0 +1,152 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"sort"
+)
+
+// deadAPI reports exported functions and methods of packages in a binary's
+// link closure that the binary doesn't contain. The linker drops code that
+// nothing reachable from main refers to, so these functions are never
+// referenced. This guides pruning the API of a package, like a custom
+// runtime, to what its users need.
+//
+//	deadapi [-stdimportcfg file] -arc packagepath=file... [-p pattern...] binary
+//
+// Exported functions and methods are read from export data. A function is
+// used if the binary has a symbol for it, or if its debug information says
+// it was inlined somewhere. Inlined calls that were optimized away
+// completely leave no trace, nor do inlined calls in binaries linked with
+// -w, so those functions are reported as unused. Methods that satisfy
+// interfaces may be kept by the linker even if they're never called.
+func deadAPI(args []string) error {
+	// Process command line arguments.
+	var stdImportcfgPath string
+	var archives []archive
+	var patterns []string
+	fs := flag.NewFlagSet("deadapi", flag.ExitOnError)
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library, used if export data refers to standard library packages")
+	fs.Var(archiveFlag{&archives}, "arc", "archive of a package in the binary's link closure, formatted as packagepath=file (may be repeated)")
+	fs.Var(importPatternFlag{&patterns}, "p", "import path pattern of packages to report on, like example.com/rt/...; by default, all -arc packages (may be repeated)")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: deadapi [-stdimportcfg file] -arc packagepath=file... [-p pattern...] binary")
+	}
+	binPath := fs.Arg(0)
+	if err := checkSandbox(append([]string{stdImportcfgPath, binPath}, archivePaths(archives)...)...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+
+	archiveMap := make(map[string]string)
+	if stdImportcfgPath != "" {
+		stdArchiveMap, err := readImportcfg(stdImportcfgPath)
+		if err != nil {
+			return err
+		}
+		archiveMap = stdArchiveMap
+	}
+	var pkgPaths []string
+	for _, arc := range archives {
+		archiveMap[arc.packagePath] = arc.filePath
+		if len(patterns) == 0 || (importPolicy{allowed: patterns}).check(arc.packagePath) == "" {
+			pkgPaths = append(pkgPaths, arc.packagePath)
+		}
+	}
+	if len(pkgPaths) == 0 {
+		return errors.New("no -arc packages to report on")
+	}
+	sort.Strings(pkgPaths)
+
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return err
+	}
+	defer bin.close()
+	if len(bin.symbols) == 0 {
+		return fmt.Errorf("%s has no symbol table; it may have been linked with -s or stripped", binPath)
+	}
+	present, err := bin.functionNames()
+	if err != nil {
+		return fmt.Errorf("%s: reading debug information: %v", binPath, err)
+	}
+
+	lookup := func(path string) (io.ReadCloser, error) {
+		file, ok := archiveMap[path]
+		if !ok {
+			return nil, fmt.Errorf("no archive for package %q", path)
+		}
+		return os.Open(file)
+	}
+	imp := importer.ForCompiler(token.NewFileSet(), "gc", lookup)
+	total, dead := 0, 0
+	for _, pkgPath := range pkgPaths {
+		pkg, err := imp.Import(pkgPath)
+		if err != nil {
+			return fmt.Errorf("reading export data for %s: %v", pkgPath, err)
+		}
+		for _, name := range exportedFuncNames(pkg) {
+			total++
+			if present[pkgPath+"."+name] || present[pathToPrefix(pkgPath)+"."+name] {
+				continue
+			}
+			dead++
+			fmt.Printf("%s.%s\n", pkgPath, name)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "note: %d of %d exported functions and methods in %d packages are not in %s\n", dead, total, len(pkgPaths), binPath)
+	return nil
+}
+
+// exportedFuncNames returns the names of a package's exported functions and
+// the exported methods of its types, formatted like the symbols the linker
+// writes: "F", "T.M" for value receivers, and "(*T).M" for pointer
+// receivers.
+func exportedFuncNames(pkg *types.Package) []string {
+	var names []string
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Func:
+			if obj.Exported() {
+				names = append(names, name)
+			}
+		case *types.TypeName:
+			named, ok := obj.Type().(*types.Named)
+			if !ok || !obj.Exported() || obj.IsAlias() || types.IsInterface(named) {
+				continue
+			}
+			for i := 0; i < named.NumMethods(); i++ {
+				m := named.Method(i)
+				if !m.Exported() {
+					continue
+				}
+				if _, ok := m.Type().(*types.Signature).Recv().Type().(*types.Pointer); ok {
+					names = append(names, "(*"+name+")."+m.Name())
+				} else {
+					names = append(names, name+"."+m.Name())
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
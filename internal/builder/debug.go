@@ -0,0 +1,195 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// debug runs gdb or lldb on a binary, configured to find its sources.
+//
+//	debug [-debugger gdb|lldb|path] [-workspace dir] [-goroot dir] [-output-base dir] [-n] binary [args...]
+//
+// Debug information refers to workspace sources by workspace-relative
+// paths, since the compiler and assembler trim the execroot. Generated
+// sources are named by their short paths, like other sources, but they're
+// in the bazel-bin directory of the workspace. The debugger is told to look
+// for sources in the workspace (by default $BUILD_WORKSPACE_DIRECTORY, set
+// by "bazel run", or the current directory).
+//
+// Standard library sources are named by the GOROOT the binary was linked
+// with, since the linker replaces the $GOROOT prefix the compiler records.
+// That's read from the binary: usually an absolute path, which needs no
+// mapping, or a path like external/go_sdk when the Go distribution is a
+// Bazel repository. The debugger is told to substitute the -goroot
+// directory for it or, by default for a repository path, the repository in
+// Bazel's output base.
+// Arguments after the binary are passed to it. With -n, the debugger
+// command is printed instead of run.
+func debug(args []string) error {
+	// Process command line arguments.
+	var debugger, workspace, goroot, outputBase string
+	var dryRun bool
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	fs.StringVar(&debugger, "debugger", "gdb", "debugger to run: gdb, lldb, or a path to either")
+	fs.StringVar(&workspace, "workspace", "", "workspace directory containing sources; defaults to $BUILD_WORKSPACE_DIRECTORY or the current directory")
+	fs.StringVar(&goroot, "goroot", "", "directory of the Go distribution the binary was built with, for standard library sources")
+	fs.StringVar(&outputBase, "output-base", "", "Bazel output base, for standard library sources in an external repository; defaults to the output base the workspace's bazel-out link points into")
+	fs.BoolVar(&dryRun, "n", false, "print the debugger command without running it")
+	addSandboxFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return errors.New("usage: debug [-debugger gdb|lldb|path] [-workspace dir] [-goroot dir] [-output-base dir] [-n] binary [args...]")
+	}
+	binPath, binArgs := fs.Arg(0), fs.Args()[1:]
+	if workspace == "" {
+		var err error
+		if workspace, err = lookupEnv("BUILD_WORKSPACE_DIRECTORY"); err != nil {
+			return err
+		}
+	}
+	if workspace == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		workspace = wd
+	}
+	workspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return err
+	}
+	if goroot != "" {
+		if goroot, err = filepath.Abs(goroot); err != nil {
+			return err
+		}
+	}
+	linkedGoroot, err := binaryGoroot(binPath)
+	if err != nil {
+		return err
+	}
+	var gorootMap [2]string
+	switch {
+	case linkedGoroot == "":
+		log.Printf("warning: %s has no standard library line tables; the debugger may not find standard library sources", binPath)
+	case goroot != "":
+		gorootMap = [2]string{linkedGoroot, goroot}
+	case strings.HasPrefix(filepath.ToSlash(linkedGoroot), "external/"):
+		if outputBase == "" {
+			outputBase = workspaceOutputBase(workspace)
+		}
+		if outputBase == "" {
+			log.Printf("warning: standard library sources are in %s, but the output base is unknown; set -goroot or -output-base", linkedGoroot)
+		} else {
+			gorootMap = [2]string{linkedGoroot, filepath.Join(outputBase, linkedGoroot)}
+		}
+	}
+
+	var debugArgs []string
+	switch kind := strings.TrimSuffix(filepath.Base(debugger), ".exe"); {
+	case strings.HasPrefix(kind, "gdb"):
+		debugArgs = gdbArgs(workspace, gorootMap, binPath, binArgs)
+	case strings.HasPrefix(kind, "lldb"):
+		debugArgs = lldbArgs(workspace, gorootMap, binPath, binArgs)
+	default:
+		return fmt.Errorf("unknown debugger %q; expected gdb or lldb", debugger)
+	}
+	debuggerPath := debugger
+	if p, err := exec.LookPath(debugger); err == nil {
+		debuggerPath = p
+	} else if !dryRun {
+		return err
+	}
+
+	if dryRun {
+		fmt.Println(shellQuote(append([]string{debuggerPath}, debugArgs...)))
+		return nil
+	}
+	cmd := exec.Command(debuggerPath, debugArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// binaryGoroot returns the GOROOT a binary's standard library sources are
+// named by, found from the runtime's sources in its line tables, or "" if
+// it has none.
+func binaryGoroot(binPath string) (string, error) {
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return "", err
+	}
+	defer bin.close()
+	if bin.dwarf == nil {
+		return "", nil
+	}
+	lines, err := readLineTable(bin.dwarf)
+	if err != nil {
+		return "", fmt.Errorf("%s: reading debug information: %v", binPath, err)
+	}
+	for _, e := range lines {
+		file := filepath.ToSlash(e.file)
+		if i := strings.Index(file, "/src/runtime/"); i > 0 {
+			return filepath.FromSlash(file[:i]), nil
+		}
+	}
+	return "", nil
+}
+
+// workspaceOutputBase returns Bazel's output base for a workspace, found
+// from the bazel-out link, which points to output_base/execroot/name/bazel-out,
+// or "" if there's no link.
+func workspaceOutputBase(workspace string) string {
+	out, err := filepath.EvalSymlinks(filepath.Join(workspace, "bazel-out"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Dir(filepath.Dir(filepath.Dir(out)))
+}
+
+// gdbArgs returns gdb arguments for debugging a binary. gdb searches each
+// source directory for relative paths; "directory" adds to the front of the
+// list, so the workspace is searched before bazel-bin. gorootMap, if set,
+// is the GOROOT recorded in the binary and the directory to find it in.
+func gdbArgs(workspace string, gorootMap [2]string, binPath string, binArgs []string) []string {
+	var args []string
+	if gorootMap[0] != "" && gorootMap[0] != gorootMap[1] {
+		args = append(args, "-ex", "set substitute-path "+gorootMap[0]+" "+gorootMap[1])
+	}
+	args = append(args,
+		"-ex", "directory "+filepath.Join(workspace, "bazel-bin"),
+		"-ex", "directory "+workspace,
+		"--args", binPath)
+	return append(args, binArgs...)
+}
+
+// lldbArgs returns lldb arguments for debugging a binary. lldb joins
+// relative paths with the compilation directory, which Go records as ".",
+// so "." is mapped to the workspace. lldb only applies the first matching
+// mapping, so the GOROOT mapping comes first, and generated sources in
+// bazel-bin aren't found.
+func lldbArgs(workspace string, gorootMap [2]string, binPath string, binArgs []string) []string {
+	sourceMap := "settings set target.source-map"
+	if gorootMap[0] != "" && gorootMap[0] != gorootMap[1] {
+		from := gorootMap[0]
+		if !filepath.IsAbs(from) {
+			from = "./" + filepath.ToSlash(from)
+		}
+		sourceMap += " " + strconv.Quote(from) + " " + strconv.Quote(gorootMap[1])
+	}
+	sourceMap += " . " + strconv.Quote(workspace)
+	args := []string{"-o", sourceMap, "--", binPath}
+	return append(args, binArgs...)
+}
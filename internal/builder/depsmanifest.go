@@ -0,0 +1,135 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// depsManifest is a JSON alternative to the -arc, -deplabel, and -depedge
+// flags, read by compile and link with -deps-manifest. Each dependency is
+// described in one place, with room for information the flat flag formats
+// can't carry, like whether a dependency is direct.
+//
+//	{
+//	  "version": 1,
+//	  "deps": [
+//	    {
+//	      "importpath": "example.com/a",
+//	      "archive": "bazel-out/.../a.a",
+//	      "export": "bazel-out/.../a.x",
+//	      "label": "//a",
+//	      "direct": true,
+//	      "imports": ["example.com/b"]
+//	    }
+//	  ]
+//	}
+//
+// Unknown fields are errors, so typos aren't silently ignored.
+type depsManifest struct {
+	Version int                 `json:"version"`
+	Deps    []depsManifestEntry `json:"deps"`
+}
+
+type depsManifestEntry struct {
+	// ImportPath and Archive are required.
+	ImportPath string `json:"importpath"`
+	Archive    string `json:"archive"`
+
+	// Export is an archive containing only export data. compile uses it
+	// instead of Archive if it's set.
+	Export string `json:"export,omitempty"`
+
+	Label string `json:"label,omitempty"`
+
+	// Direct is true for dependencies of the package being compiled or
+	// linked, as opposed to dependencies of those dependencies.
+	Direct bool `json:"direct,omitempty"`
+
+	Imports []string `json:"imports,omitempty"`
+
+	// Optional is like the ";optional" suffix of -arc.
+	Optional bool `json:"optional,omitempty"`
+}
+
+const depsManifestVersion = 1
+
+// readDepsManifest reads and validates a dependency manifest.
+func readDepsManifest(path string) (depsManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return depsManifest{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m depsManifest
+	if err := dec.Decode(&m); err != nil {
+		return depsManifest{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if m.Version != depsManifestVersion {
+		return depsManifest{}, fmt.Errorf("%s: unsupported version %d; expected %d", path, m.Version, depsManifestVersion)
+	}
+	seen := make(map[string]bool)
+	for i, dep := range m.Deps {
+		if dep.ImportPath == "" {
+			return depsManifest{}, fmt.Errorf("%s: deps[%d]: importpath is not set", path, i)
+		}
+		if dep.Archive == "" {
+			return depsManifest{}, fmt.Errorf("%s: deps[%d] (%s): archive is not set", path, i, dep.ImportPath)
+		}
+		if seen[dep.ImportPath] {
+			return depsManifest{}, fmt.Errorf("%s: deps[%d]: %s is listed more than once", path, i, dep.ImportPath)
+		}
+		seen[dep.ImportPath] = true
+	}
+	return m, nil
+}
+
+// depsManifestFlag reads a dependency manifest named by -deps-manifest and
+// adds its dependencies to the same lists the -arc, -deplabel, and -depedge
+// flags do. With directOnly (for compile), only direct dependencies are
+// added as archives, and export files are used where available.
+type depsManifestFlag struct {
+	archives   *[]archive
+	graph      *depGraph
+	directOnly bool
+}
+
+func (f depsManifestFlag) String() string { return "" }
+
+func (f depsManifestFlag) Set(path string) error {
+	m, err := readDepsManifest(path)
+	if err != nil {
+		return err
+	}
+	if f.graph.labels == nil {
+		f.graph.labels = make(map[string]string)
+	}
+	if f.graph.edges == nil {
+		f.graph.edges = make(map[string][]string)
+	}
+	for _, dep := range m.Deps {
+		if dep.Direct {
+			f.graph.markDirect(dep.ImportPath)
+		}
+		if dep.Label != "" {
+			f.graph.labels[dep.ImportPath] = dep.Label
+		}
+		f.graph.edges[dep.ImportPath] = append(f.graph.edges[dep.ImportPath], dep.Imports...)
+		if f.directOnly && !dep.Direct {
+			continue
+		}
+		arc := archive{packagePath: dep.ImportPath, filePath: dep.Archive, optional: dep.Optional}
+		if f.directOnly && dep.Export != "" {
+			arc.filePath = dep.Export
+		}
+		*f.archives = append(*f.archives, arc)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDepsManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestReadDepsManifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		desc, data string
+		want       depsManifest
+		wantErr    string
+	}{
+		{
+			desc: "empty",
+			data: `{"version": 1}`,
+			want: depsManifest{Version: 1},
+		}, {
+			desc: "deps",
+			data: `{
+  "version": 1,
+  "deps": [
+    {"importpath": "example.com/a", "archive": "a.a", "export": "a.x", "label": "//a", "direct": true, "imports": ["example.com/b"]},
+    {"importpath": "example.com/b", "archive": "b.a", "optional": true}
+  ]
+}`,
+			want: depsManifest{
+				Version: 1,
+				Deps: []depsManifestEntry{
+					{ImportPath: "example.com/a", Archive: "a.a", Export: "a.x", Label: "//a", Direct: true, Imports: []string{"example.com/b"}},
+					{ImportPath: "example.com/b", Archive: "b.a", Optional: true},
+				},
+			},
+		}, {
+			desc:    "malformed",
+			data:    `{"version": 1,`,
+			wantErr: "unexpected EOF",
+		}, {
+			desc:    "unknown_field",
+			data:    `{"version": 1, "deps": [{"importpath": "a", "archive": "a.a", "labels": "//a"}]}`,
+			wantErr: `unknown field "labels"`,
+		}, {
+			desc:    "no_version",
+			data:    `{"deps": []}`,
+			wantErr: "unsupported version 0",
+		}, {
+			desc:    "future_version",
+			data:    `{"version": 2}`,
+			wantErr: "unsupported version 2",
+		}, {
+			desc:    "no_importpath",
+			data:    `{"version": 1, "deps": [{"archive": "a.a"}]}`,
+			wantErr: "deps[0]: importpath is not set",
+		}, {
+			desc:    "no_archive",
+			data:    `{"version": 1, "deps": [{"importpath": "a"}]}`,
+			wantErr: "deps[0] (a): archive is not set",
+		}, {
+			desc:    "duplicate",
+			data:    `{"version": 1, "deps": [{"importpath": "a", "archive": "a.a"}, {"importpath": "a", "archive": "b.a"}]}`,
+			wantErr: "deps[1]: a is listed more than once",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(dir, tc.desc+".json")
+			if err := ioutil.WriteFile(path, []byte(tc.data), 0666); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readDepsManifest(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDepsManifestFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDepsManifestFlag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deps.json")
+	data := `{
+  "version": 1,
+  "deps": [
+    {"importpath": "a", "archive": "a.a", "export": "a.x", "label": "//a", "direct": true, "imports": ["b"]},
+    {"importpath": "b", "archive": "b.a", "label": "//b", "optional": true}
+  ]
+}`
+	if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wantGraph := depGraph{
+		labels: map[string]string{"a": "//a", "b": "//b"},
+		edges:  map[string][]string{"a": {"b"}, "b": nil},
+		direct: map[string]bool{"a": true},
+	}
+
+	for _, tc := range []struct {
+		desc         string
+		directOnly   bool
+		wantArchives []archive
+	}{
+		{
+			desc:       "compile",
+			directOnly: true,
+			wantArchives: []archive{
+				{packagePath: "a", filePath: "a.x"},
+			},
+		}, {
+			desc: "link",
+			wantArchives: []archive{
+				{packagePath: "a", filePath: "a.a"},
+				{packagePath: "b", filePath: "b.a", optional: true},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var archives []archive
+			var graph depGraph
+			if err := (depsManifestFlag{&archives, &graph, tc.directOnly}).Set(path); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(archives, tc.wantArchives) {
+				t.Errorf("got archives %#v; want %#v", archives, tc.wantArchives)
+			}
+			if !reflect.DeepEqual(graph, wantGraph) {
+				t.Errorf("got graph %#v; want %#v", graph, wantGraph)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// diagOptions controls how diagnostics printed by the compiler and linker
+// are presented.
+type diagOptions struct {
+	// color is "auto", "always", or "never". With "auto", diagnostics are
+	// colorized only when stderr is a terminal.
+	color string
+
+	// dedup suppresses diagnostics identical to an earlier diagnostic: the
+	// same message at the same file, line, and column. Distinct errors with
+	// the same text at different positions are all printed.
+	dedup bool
+
+	// maxDiags is the number of diagnostics to print before truncating the
+	// rest. Later errors are often caused by earlier ones. 0 means no limit.
+	maxDiags int
+
+	// maxLines is the number of lines of each tool's output to print before
+	// truncating the rest, regardless of how they're grouped into
+	// diagnostics. This keeps error floods from overwhelming Bazel's
+	// output handling. 0 means no limit.
+	maxLines int
+
+	// fullLogPath is a file where the complete, unfiltered output of every
+	// tool is written. It's mentioned when output is truncated.
+	fullLogPath string
+}
+
+// diagOpts is set by flags registered with addDiagFlags. It's used by
+// runTool for every tool the builder invokes.
+var diagOpts = diagOptions{color: "auto"}
+
+// addDiagFlags registers flags that control diagnostics on a flag set.
+func addDiagFlags(fs *flag.FlagSet) {
+	fs.StringVar(&diagOpts.color, "color", diagOpts.color, "whether to colorize diagnostics: auto, always, or never")
+	fs.BoolVar(&diagOpts.dedup, "dedup", diagOpts.dedup, "whether to suppress diagnostics repeated with the same message at the same position")
+	fs.IntVar(&diagOpts.maxDiags, "max-diags", diagOpts.maxDiags, "number of diagnostics to print before truncating (0 means no limit)")
+	fs.IntVar(&diagOpts.maxLines, "max-output-lines", diagOpts.maxLines, "number of lines of each tool's output to print before truncating (0 means no limit)")
+	fs.Var(fullLogFlag{}, "full-log", "path to a file where the complete output of every tool is written")
+}
+
+// fullLogFlag sets the path of the full log. The file is created (or
+// truncated) when the flag is parsed, so it exists even if no tool prints
+// anything, as Bazel requires of declared outputs.
+type fullLogFlag struct{}
+
+func (fullLogFlag) String() string { return diagOpts.fullLogPath }
+
+func (fullLogFlag) Set(path string) error {
+	diagOpts.fullLogPath = path
+	return ioutil.WriteFile(path, nil, 0666)
+}
+
+// appendFullLog appends tool output to the full log, if there is one.
+func appendFullLog(out []byte) error {
+	if diagOpts.fullLogPath == "" || len(out) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(diagOpts.fullLogPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// diagnostic is an error or warning reported by a tool. Lines the tool prints
+// without a position are kept as diagnostics with an empty pos.
+type diagnostic struct {
+	pos, msg string
+	severity string // "error", "warning", or "" for lines without a position.
+	cont     []string
+}
+
+var diagPosRe = regexp.MustCompile(`^(\S+?:\d+(?::\d+)?): (.*)$`)
+
+// parseDiagnostics splits tool output into diagnostics. Indented lines are
+// treated as continuations of the diagnostic before them.
+func parseDiagnostics(out []byte) []diagnostic {
+	var diags []diagnostic
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(diags) > 0 && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ")) {
+			d := &diags[len(diags)-1]
+			d.cont = append(d.cont, line)
+			continue
+		}
+		if m := diagPosRe.FindStringSubmatch(line); m != nil {
+			severity := "error"
+			if strings.HasPrefix(m[2], "warning:") {
+				severity = "warning"
+			}
+			diags = append(diags, diagnostic{pos: m[1], msg: m[2], severity: severity})
+		} else {
+			diags = append(diags, diagnostic{msg: line})
+		}
+	}
+	return diags
+}
+
+// writeDiagnostics formats tool output and writes it to w. With a line
+// limit, notes about what was left out are written after the limit is
+// applied, so they're never dropped themselves.
+func writeDiagnostics(w io.Writer, out []byte, opts diagOptions) {
+	if len(out) == 0 {
+		return
+	}
+	var lw *lineLimitWriter
+	dw := w
+	if opts.maxLines > 0 {
+		lw = &lineLimitWriter{w: w, remaining: opts.maxLines}
+		dw = lw
+	}
+	color := opts.color == "always" || (opts.color == "auto" && isTerminal(os.Stderr))
+
+	seen := make(map[string]bool)
+	printed, duplicates, truncated := 0, 0, 0
+	for _, d := range parseDiagnostics(out) {
+		if d.pos != "" {
+			d.pos = mapDiagnosticPos(d.pos)
+			key := d.pos + "\n" + d.msg + "\n" + strings.Join(d.cont, "\n")
+			if opts.dedup && seen[key] {
+				duplicates++
+				continue
+			}
+			seen[key] = true
+			if opts.maxDiags > 0 && printed >= opts.maxDiags {
+				truncated++
+				continue
+			}
+			printed++
+		}
+		writeDiagnostic(dw, d, color)
+	}
+	if duplicates > 0 {
+		fmt.Fprintf(w, "note: %d repeated diagnostics suppressed\n", duplicates)
+	}
+	if truncated > 0 {
+		fmt.Fprintf(w, "note: %d more diagnostics not shown\n", truncated)
+	}
+	if lw != nil && lw.dropped > 0 {
+		if opts.fullLogPath != "" {
+			fmt.Fprintf(w, "note: %d more lines of output not shown; see %s for the full output\n", lw.dropped, opts.fullLogPath)
+		} else {
+			fmt.Fprintf(w, "note: %d more lines of output not shown\n", lw.dropped)
+		}
+	}
+}
+
+// lineLimitWriter writes up to a number of lines to w, then counts and
+// drops the rest.
+type lineLimitWriter struct {
+	w         io.Writer
+	remaining int
+	dropped   int
+}
+
+func (lw *lineLimitWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+		if lw.remaining == 0 {
+			lw.dropped++
+			continue
+		}
+		if _, err := lw.w.Write(line); err != nil {
+			return 0, err
+		}
+		if line[len(line)-1] == '\n' {
+			lw.remaining--
+		}
+	}
+	return n, nil
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+)
+
+func writeDiagnostic(w io.Writer, d diagnostic, color bool) {
+	switch {
+	case d.pos == "":
+		fmt.Fprintln(w, d.msg)
+	case !color:
+		fmt.Fprintf(w, "%s: %s\n", d.pos, d.msg)
+	default:
+		msgColor := ansiRed
+		if d.severity == "warning" {
+			msgColor = ansiYellow
+		}
+		fmt.Fprintf(w, "%s%s:%s %s%s%s\n", ansiBold, d.pos, ansiReset, msgColor, d.msg, ansiReset)
+	}
+	for _, line := range d.cont {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// isTerminal returns whether f appears to be a terminal. Bazel captures the
+// output of actions, so this is usually false.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
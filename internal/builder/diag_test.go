@@ -0,0 +1,26 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWriteDiagnosticsLineLimit checks that notes about suppressed and
+// truncated output are printed even when the line limit has been reached.
+func TestWriteDiagnosticsLineLimit(t *testing.T) {
+	out := []byte("a.go:1:1: first\na.go:2:1: second\na.go:2:1: second\na.go:3:1: third\n")
+	opts := diagOptions{color: "never", dedup: true, maxLines: 1, fullLogPath: "full.log"}
+	buf := &bytes.Buffer{}
+	writeDiagnostics(buf, out, opts)
+	want := "a.go:1:1: first\n" +
+		"note: 1 repeated diagnostics suppressed\n" +
+		"note: 2 more lines of output not shown; see full.log for the full output\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
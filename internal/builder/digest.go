@@ -0,0 +1,162 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// inputDigestsPath is set by the -input-digests flag. It names a manifest
+// of expected SHA-256 digests of input files, which is checked before any
+// tools are run.
+var inputDigestsPath string
+
+// inputDigests writes a manifest of the SHA-256 digests of files, in the
+// format -input-digests reads. The rules run it as a separate action, so
+// the manifest records the inputs Bazel provided, and the action that
+// checks it catches a sandbox that provides something else.
+//
+//	inputdigests -o manifest file...
+func inputDigests(args []string) error {
+	// Process command line arguments.
+	var outPath string
+	fs := flag.NewFlagSet("inputdigests", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "path to the manifest to write")
+	fs.Parse(args)
+	if outPath == "" {
+		return errors.New("-o must be set")
+	}
+	paths := fs.Args()
+
+	digests := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	parallelFor(len(paths), func(i int) {
+		digests[i], errs[i] = fileDigest(paths[i])
+	})
+	b := &bytes.Buffer{}
+	for i, path := range paths {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		fmt.Fprintf(b, "%s  %s\n", digests[i], path)
+	}
+	return writeFileAtomic(outPath, b.Bytes())
+}
+
+// verifyInputDigests checks that each file listed in the manifest named by
+// -input-digests has the expected digest. A mismatch means the sandbox
+// contains a stale or wrong input, which would otherwise show up later as a
+// confusing compile or link error, or not at all. The manifest has the same
+// format as the output of sha256sum: one file per line, with a hex digest,
+// two spaces (or a space and '*'), and the path.
+func verifyInputDigests() error {
+	if inputDigestsPath == "" {
+		return nil
+	}
+	f, err := os.Open(inputDigestsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var wants, paths []string
+	sc := bufio.NewScanner(f)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+2 > len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+			return fmt.Errorf("%s:%d: malformed line; expected digest and path", inputDigestsPath, lineNum)
+		}
+		wants = append(wants, strings.ToLower(line[:i]))
+		paths = append(paths, line[i+2:])
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	// Links may have hundreds of inputs, so hash them concurrently.
+	gots := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	parallelFor(len(paths), func(i int) {
+		gots[i], errs[i] = fileDigest(paths[i])
+	})
+	var mismatches []string
+	for i, path := range paths {
+		if errs[i] != nil {
+			mismatches = append(mismatches, fmt.Sprintf("\t%s: %v", path, errs[i]))
+		} else if gots[i] != wants[i] {
+			mismatches = append(mismatches, fmt.Sprintf("\t%s: digest is %s; expected %s", path, gots[i], wants[i]))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("inputs don't match %s:\n%s", inputDigestsPath, strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
+
+// previousDigest is set by the -previous-digest flag. It's the SHA-256
+// digest of the action's primary output from a previous build. If it's set,
+// the event log and package metadata report whether the new output is
+// unchanged, so a pipeline can skip work that depends on it.
+var previousDigest string
+
+// previousDigestFlag parses -previous-digest. The value is either a
+// hex-encoded SHA-256 digest, or the path to a file whose first field is
+// one, like a line written by sha256sum.
+type previousDigestFlag struct{}
+
+func (previousDigestFlag) String() string { return previousDigest }
+
+func (previousDigestFlag) Set(value string) error {
+	if isHexDigest(value) {
+		previousDigest = strings.ToLower(value)
+		return nil
+	}
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return fmt.Errorf("not a SHA-256 digest or a file containing one: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !isHexDigest(fields[0]) {
+		return fmt.Errorf("%s does not start with a SHA-256 digest", value)
+	}
+	previousDigest = strings.ToLower(fields[0])
+	return nil
+}
+
+func isHexDigest(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// outputUnchanged reports whether an output has the digest given with
+// -previous-digest. ok is false if -previous-digest wasn't set or the
+// output can't be read.
+func outputUnchanged(path string) (unchanged, ok bool) {
+	if previousDigest == "" {
+		return false, false
+	}
+	digest, err := fileDigest(path)
+	if err != nil {
+		return false, false
+	}
+	return digest == previousDigest, true
+}
@@ -0,0 +1,133 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// disasm prints the machine code of a function in a linked binary, using
+// objdump from the Go distribution. objdump reads any architecture Go
+// supports, so there's no need for cross binutils.
+//
+//	disasm -objdump path -func pkg.Func [-source=false] binary
+//
+// The function is named by its package path, like
+// "gopkg.in/yaml.v2.(*Decoder).Decode", rather than by its symbol, which
+// has escaped characters. Symbols in the output are demangled the same way.
+// With -source (the default), source lines are interleaved with the code;
+// objdump finds sources by the paths recorded by the compiler, which are
+// relative to the execroot when compiled with -trimpath, so it should run
+// in the workspace root.
+func disasm(args []string) error {
+	// Process command line arguments.
+	var funcName string
+	var source bool
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	fs.StringVar(&tools.objdump, "objdump", "", "path to the Go disassembler (objdump)")
+	fs.StringVar(&funcName, "func", "", "name of the function to disassemble, formatted as packagepath.Name, packagepath.Type.Method, or packagepath.(*Type).Method")
+	fs.BoolVar(&source, "source", true, "interleave source lines with the disassembly")
+	fs.Parse(args)
+	if fs.NArg() != 1 || funcName == "" {
+		return errors.New("usage: disasm -objdump path -func pkg.Func [-source=false] binary")
+	}
+	binPath := fs.Arg(0)
+	objdumpPath, err := tools.path("objdump", tools.objdump)
+	if err != nil {
+		return err
+	}
+
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return err
+	}
+	syms, format := bin.symbols, bin.format
+	bin.close()
+	if format == "wasm" {
+		return fmt.Errorf("%s: objdump can't disassemble WebAssembly", binPath)
+	}
+	if len(syms) == 0 {
+		return fmt.Errorf("%s has no symbol table; it may have been linked with -s or stripped", binPath)
+	}
+	sym, err := findFuncSymbol(syms, funcName)
+	if err != nil {
+		return fmt.Errorf("%s: %v", binPath, err)
+	}
+
+	objdumpArgs := []string{"-s", "^" + regexp.QuoteMeta(sym) + "$"}
+	if source {
+		objdumpArgs = append(objdumpArgs, "-S")
+	}
+	objdumpArgs = append(objdumpArgs, binPath)
+	cmd := exec.Command(objdumpPath, objdumpArgs...)
+	cmd.Env = toolEnv()
+	cmd.Stderr = os.Stderr
+	printExplainedCommand(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(nil, 1024*1024)
+	w := bufio.NewWriter(os.Stdout)
+	for sc.Scan() {
+		fmt.Fprintln(w, demangleText(sc.Text()))
+	}
+	scanErr := sc.Err()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("objdump failed: %v", err)
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	return w.Flush()
+}
+
+// findFuncSymbol returns the symbol for a function named with its package
+// path. If there's no such function, the error suggests functions with
+// similar names.
+func findFuncSymbol(syms []binarySymbol, funcName string) (string, error) {
+	var similar []string
+	seen := make(map[string]bool)
+	for _, s := range syms {
+		name := s.name
+		if pkgPath, rest, err := splitSymbol(s.name); err == nil && pkgPath != "" {
+			name = pkgPath + "." + rest
+		}
+		if name == funcName {
+			return s.name, nil
+		}
+		if strings.Contains(name, funcName) && !seen[name] {
+			seen[name] = true
+			similar = append(similar, name)
+		}
+	}
+	if len(similar) == 0 {
+		return "", fmt.Errorf("no function %s", funcName)
+	}
+	sort.Strings(similar)
+	const maxSimilar = 10
+	msg := fmt.Sprintf("no function %s; similar functions:", funcName)
+	for i, name := range similar {
+		if i == maxSimilar {
+			msg += fmt.Sprintf("\n\t(%d more)", len(similar)-maxSimilar)
+			break
+		}
+		msg += "\n\t" + name
+	}
+	return "", errors.New(msg)
+}
@@ -0,0 +1,190 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"debug/dwarf"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dwarfCheck verifies the debug information in a linked binary, so changes
+// that hurt debuggability are caught before someone needs a debugger.
+//
+//	dwarfcheck [-goroot dir] binary
+//
+// It checks that source paths in line tables are workspace-relative, as
+// the compiler and assembler record them with -trimpath, rather than
+// absolute paths into a sandbox or a developer's machine. Standard library
+// paths are allowed: the compiler records them as $GOROOT/src/..., but the
+// linker replaces $GOROOT with GOROOT_FINAL or GOROOT, so paths under
+// -goroot, which defaults to the same directory, are allowed. It also
+// checks that each package with code in the binary has line tables.
+func dwarfCheck(args []string) error {
+	// Process command line arguments.
+	var goroot string
+	fs := flag.NewFlagSet("dwarfcheck", flag.ExitOnError)
+	fs.StringVar(&goroot, "goroot", "", "GOROOT the binary was linked with; standard library paths under it are allowed; defaults to $GOROOT_FINAL or $GOROOT")
+	addSandboxFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: dwarfcheck [-goroot dir] binary")
+	}
+	if goroot == "" {
+		for _, key := range []string{"GOROOT_FINAL", "GOROOT"} {
+			value, err := lookupEnv(key)
+			if err != nil {
+				return err
+			}
+			if value != "" {
+				goroot = value
+				break
+			}
+		}
+	}
+	binPath := fs.Arg(0)
+
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return err
+	}
+	defer bin.close()
+	if bin.dwarf == nil {
+		return fmt.Errorf("%s has no debug information; it may have been linked with -w or stripped", binPath)
+	}
+	problems, err := checkDWARF(bin, goroot)
+	if err != nil {
+		return fmt.Errorf("%s: reading debug information: %v", binPath, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has problems with its debug information:\n\t%s", binPath, strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// checkDWARF returns descriptions of problems in a binary's debug
+// information. Absolute paths under goroot are allowed.
+func checkDWARF(bin *binaryFile, goroot string) ([]string, error) {
+	var problems []string
+	absPaths := make(map[string]string)
+	var covered [][2]uint64
+	r := bin.dwarf.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		r.SkipChildren()
+		pkgPath, _ := e.Val(dwarf.AttrName).(string)
+		lr, err := bin.dwarf.LineReader(e)
+		if err != nil {
+			return nil, err
+		}
+		if lr == nil {
+			continue
+		}
+		hasLines := false
+		var entry dwarf.LineEntry
+		for {
+			if err := lr.Next(&entry); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			hasLines = true
+			if entry.File == nil || !isAbsSourcePath(entry.File.Name, goroot) {
+				continue
+			}
+			if _, ok := absPaths[entry.File.Name]; !ok {
+				absPaths[entry.File.Name] = pkgPath
+			}
+		}
+		if hasLines {
+			ranges, err := bin.dwarf.Ranges(e)
+			if err != nil {
+				return nil, err
+			}
+			covered = append(covered, ranges...)
+		}
+	}
+
+	var names []string
+	for name := range absPaths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, fmt.Sprintf("%s: absolute source path %s; it should be relative to the workspace", absPaths[name], name))
+	}
+
+	// A package's functions may be in another package's compilation unit,
+	// for example when they're defined with go:linkname, so packages are
+	// matched to line tables by the addresses of their functions.
+	isCovered := func(addr uint64) bool {
+		for _, r := range covered {
+			if r[0] <= addr && addr < r[1] {
+				return true
+			}
+		}
+		return false
+	}
+	pkgs := make(map[string]bool)
+	for _, s := range bin.symbols {
+		if s.size == 0 || !strings.Contains(strings.ToLower(s.section), "text") {
+			continue
+		}
+		if pkgPath := symbolPackage(s.name); isPackagePath(pkgPath) {
+			pkgs[pkgPath] = pkgs[pkgPath] || isCovered(s.addr)
+		}
+	}
+	var missing []string
+	for pkgPath, ok := range pkgs {
+		if !ok {
+			missing = append(missing, pkgPath)
+		}
+	}
+	sort.Strings(missing)
+	for _, pkgPath := range missing {
+		problems = append(problems, fmt.Sprintf("%s: package has code but no line table", pkgPath))
+	}
+	return problems, nil
+}
+
+// isAbsSourcePath returns whether a path in a line table is absolute and
+// not in goroot. Paths are written with slashes.
+func isAbsSourcePath(path, goroot string) bool {
+	if !filepath.IsAbs(path) && !strings.HasPrefix(path, "/") {
+		return false
+	}
+	if goroot == "" {
+		return true
+	}
+	root := strings.TrimSuffix(filepath.ToSlash(filepath.Clean(goroot)), "/") + "/"
+	return !strings.HasPrefix(filepath.ToSlash(path), root)
+}
+
+// isPackagePath returns whether symbolPackage returned a package path,
+// rather than a group of symbols that don't belong to a package. Symbols
+// for float constants and the like ("$f64.3ff0...") and linker-defined
+// symbols ("_") don't belong to packages either.
+func isPackagePath(pkgPath string) bool {
+	if pkgPath == "" || pkgPath == "_" || pkgPath == "<other>" {
+		return false
+	}
+	return !strings.HasPrefix(pkgPath, "$")
+}
@@ -0,0 +1,36 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import "testing"
+
+func TestIsAbsSourcePath(t *testing.T) {
+	// File names from the line table of a binary built by the builder in a
+	// sandbox, with $GOROOT expanded the way the Go 1.13 linker does when
+	// GOROOT is /usr/local/go. The last one was compiled without trimming
+	// the sandbox directory.
+	const goroot = "/usr/local/go"
+	for _, tc := range []struct {
+		path string
+		abs  bool
+	}{
+		{"/usr/local/go/src/runtime/proc.go", false},
+		{"/usr/local/go/src/runtime/asm_amd64.s", false},
+		{"/usr/local/go/src/fmt/print.go", false},
+		{"<autogenerated>", false},
+		{"da/lib.go", false},
+		{"external/go_sdk/src/runtime/chan.go", false},
+		{"/usr/local/gopath/src/example.com/a/a.go", true},
+		{"/home/user/.cache/bazel/execroot/ws/sandbox/linux-sandbox/12/execroot/ws/da/main.go", true},
+	} {
+		if got := isAbsSourcePath(tc.path, goroot); got != tc.abs {
+			t.Errorf("isAbsSourcePath(%q, %q): got %v; want %v", tc.path, goroot, got, tc.abs)
+		}
+	}
+	if !isAbsSourcePath("/usr/local/go/src/runtime/proc.go", "") {
+		t.Errorf("isAbsSourcePath with no GOROOT: got false for an absolute path; want true")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// genEmbed generates a Go source file that contains the contents of other
+// files as variables. It's an alternative to //go:embed for toolchains or
+// runtimes that don't support it.
+//
+//	genembed -o out.go -package name [-var name] [-map] [-flatten]
+//	         [-trim prefix...] [-string] [-gzip] file...
+//	genembed -o out.go -bindata manifest [-package name] [-trim prefix...] file...
+//
+// Without -map, there must be exactly one file, and the variable holds its
+// contents. With -map, the variable is a map from file names to contents.
+// File names are slash-separated paths with -trim prefixes removed, or base
+// names with -flatten. With -string, contents are strings instead of byte
+// slices. With -gzip, contents are gzip-compressed.
+//
+// With -bindata, the generated file has the API of a file generated by
+// go-bindata with the options in the given manifest. See genBindata.
+func genEmbed(args []string) error {
+	// Process command line arguments.
+	var outPath, pkgName, varName, bindataPath string
+	var useMap, flatten, useString, useGzip bool
+	var trimPrefixes []string
+	fs := flag.NewFlagSet("genembed", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "path to the Go source file to generate")
+	fs.StringVar(&pkgName, "package", "", "name of the package the generated file belongs to")
+	fs.StringVar(&varName, "var", "Data", "name of the variable holding the contents")
+	fs.BoolVar(&useMap, "map", false, "generate a map from file names to contents instead of a single value")
+	fs.BoolVar(&flatten, "flatten", false, "with -map, use base names of files as keys")
+	fs.Var(stringListFlag{&trimPrefixes}, "trim", "with -map, a prefix to remove from file names used as keys (may be repeated)")
+	fs.BoolVar(&useString, "string", false, "store contents as strings instead of byte slices")
+	fs.BoolVar(&useGzip, "gzip", false, "compress contents with gzip")
+	fs.StringVar(&bindataPath, "bindata", "", "path to a go-bindata manifest; generate go-bindata's API with its options")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if outPath == "" {
+		return errors.New("-o must be set")
+	}
+	srcPaths := fs.Args()
+	if err := checkSandbox(append([]string{outPath, bindataPath}, srcPaths...)...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+	if bindataPath != "" {
+		return genBindata(outPath, pkgName, bindataPath, trimPrefixes, srcPaths)
+	}
+	if !token.IsIdentifier(pkgName) {
+		return fmt.Errorf("-package must be set to a valid package name; got %q", pkgName)
+	}
+	if !token.IsIdentifier(varName) {
+		return fmt.Errorf("-var must be a valid identifier; got %q", varName)
+	}
+	if !useMap && len(srcPaths) != 1 {
+		return fmt.Errorf("expected 1 file without -map; got %d", len(srcPaths))
+	}
+
+	keys := make(map[string]string)
+	for _, p := range srcPaths {
+		key := embedKey(p, flatten, trimPrefixes)
+		if other, ok := keys[key]; ok {
+			return fmt.Errorf("%s and %s both have the name %q", other, p, key)
+		}
+		keys[key] = p
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	f, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "// Code generated by genembed. DO NOT EDIT.\n\n")
+	fmt.Fprintf(w, "package %s\n\n", pkgName)
+	valueType := "[]byte"
+	if useString {
+		valueType = "string"
+	}
+	if useGzip {
+		fmt.Fprintf(w, "// %s is gzip-compressed.\n", varName)
+	}
+	if useMap {
+		fmt.Fprintf(w, "var %s = map[string]%s{\n", varName, valueType)
+		for _, key := range sortedKeys {
+			fmt.Fprintf(w, "\t%s: ", strconv.Quote(key))
+			if err := writeEmbedValue(w, keys[key], useString, useGzip); err != nil {
+				f.abort()
+				return err
+			}
+			fmt.Fprintf(w, ",\n")
+		}
+		fmt.Fprintf(w, "}\n")
+	} else {
+		fmt.Fprintf(w, "var %s = ", varName)
+		if err := writeEmbedValue(w, srcPaths[0], useString, useGzip); err != nil {
+			f.abort()
+			return err
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// embedKey returns the name of an embedded file used as a map key.
+func embedKey(p string, flatten bool, trimPrefixes []string) string {
+	p = filepath.ToSlash(p)
+	if flatten {
+		return path.Base(p)
+	}
+	for _, prefix := range trimPrefixes {
+		prefix = strings.TrimSuffix(filepath.ToSlash(prefix), "/") + "/"
+		if strings.HasPrefix(p, prefix) {
+			return p[len(prefix):]
+		}
+	}
+	return p
+}
+
+// writeEmbedValue writes a file's contents as a Go expression: a string
+// literal, or a string literal converted to []byte. The compiler handles
+// large string literals much more efficiently than composite literals.
+func writeEmbedValue(w io.Writer, p string, useString, useGzip bool) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if useGzip {
+		buf := &bytes.Buffer{}
+		zw, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	if !useString {
+		if _, err := io.WriteString(w, "[]byte("); err != nil {
+			return err
+		}
+	}
+	// Write a single literal, even for large files. Concatenating shorter
+	// literals with + would produce a deeply nested expression.
+	if _, err := io.WriteString(w, strconv.Quote(string(data))); err != nil {
+		return err
+	}
+	if !useString {
+		if _, err := io.WriteString(w, ")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// eventLog writes JSON events describing the builder's action to a file.
+// Events are written one per line in a format modeled on Bazel's Build Event
+// Protocol, so pipelines that ingest BEP JSON can also consume compile and
+// link telemetry. Nothing is written unless the -events flag is set.
+type eventLog struct {
+	path    string
+	verb    string
+	label   string
+	start   time.Time
+	started bool
+	outputs []string
+
+	// stderr accumulates a digest of diagnostics printed by tools.
+	stderr    hash.Hash
+	stderrLen int64
+}
+
+// events is the event log for this process. The verb and start time are set
+// by main; other fields are set by flags and by actions as they produce
+// outputs.
+var events = eventLog{stderr: sha256.New()}
+
+// eventsFlag sets the path of the event log.
+type eventsFlag struct{}
+
+func (eventsFlag) String() string { return events.path }
+
+func (eventsFlag) Set(path string) error {
+	events.path = path
+	return nil
+}
+
+// begin writes the start event. Actions call it after parsing flags, when
+// the event log's path and the label are known. finish calls it too, so
+// a log always has a start event, but it's only written once.
+func (l *eventLog) begin() error {
+	if l.path == "" || l.started {
+		return nil
+	}
+	l.started = true
+	return l.write(map[string]interface{}{
+		"id": map[string]interface{}{
+			"actionStarted": map[string]string{
+				"type":  l.verb,
+				"label": l.label,
+			},
+		},
+		"started": map[string]interface{}{
+			"startTime":   l.start.Format(time.RFC3339Nano),
+			"commandLine": os.Args,
+		},
+	})
+}
+
+// stderrWriter returns a writer that should receive everything the builder
+// prints on behalf of tools, so it's included in the stderr digest.
+func (l *eventLog) stderrWriter() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		l.stderrLen += int64(len(p))
+		return l.stderr.Write(p)
+	})
+}
+
+// addOutput records a file produced by the action. The first output recorded
+// is the primary output.
+func (l *eventLog) addOutput(path string) {
+	l.outputs = append(l.outputs, path)
+}
+
+// finish writes events describing the result of the action and the files
+// it produced.
+func (l *eventLog) finish(actionErr error) error {
+	if l.path == "" {
+		return nil
+	}
+	if err := l.begin(); err != nil {
+		return err
+	}
+	exitCode := 0
+	if actionErr != nil {
+		exitCode = 1
+	}
+	primaryOutput := ""
+	if len(l.outputs) > 0 {
+		primaryOutput = l.outputs[0]
+	}
+	files := make([]interface{}, 0, len(l.outputs))
+	for _, out := range l.outputs {
+		if f, err := eventFile(out); err == nil {
+			files = append(files, f)
+		}
+	}
+	action := map[string]interface{}{
+		"success":     actionErr == nil,
+		"type":        l.verb,
+		"exitCode":    exitCode,
+		"label":       l.label,
+		"commandLine": os.Args,
+		"startTime":   l.start.Format(time.RFC3339Nano),
+		"endTime":     time.Now().Format(time.RFC3339Nano),
+		"stderr": map[string]interface{}{
+			"name":   "stderr",
+			"digest": hex.EncodeToString(l.stderr.Sum(nil)),
+			"length": l.stderrLen,
+		},
+	}
+	if len(files) > 0 {
+		action["primaryOutput"] = files[0]
+	}
+	if unchanged, ok := outputUnchanged(primaryOutput); ok && actionErr == nil {
+		action["primaryOutputUnchanged"] = unchanged
+	}
+	if err := l.write(map[string]interface{}{
+		"id": map[string]interface{}{
+			"actionCompleted": map[string]string{
+				"primaryOutput": primaryOutput,
+				"label":         l.label,
+			},
+		},
+		"action": action,
+	}); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return l.write(map[string]interface{}{
+		"id": map[string]interface{}{
+			"namedSet": map[string]string{"id": "0"},
+		},
+		"namedSetOfFiles": map[string]interface{}{"files": files},
+	})
+}
+
+// write appends a single event to the log.
+func (l *eventLog) write(event map[string]interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// eventFile describes an output file in the form of a BEP File message.
+func eventFile(path string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"name":   path,
+		"uri":    "file://" + filepath.ToSlash(absPath),
+		"digest": hex.EncodeToString(h.Sum(nil)),
+		"length": n,
+	}, nil
+}
+
+// writerFunc adapts a function to the io.Writer interface.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,137 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// explainArgs is set with -explain-args. When it's true, each tool command
+// line is printed before the tool runs, one argument per line, annotated
+// with the builder flag or default it came from. Builder flags are in turn
+// set by the toolchain (like -goexperiment) or by rule attributes (like -O
+// from optimization), so this helps track down where a conflicting tool
+// flag was introduced.
+var explainArgs bool
+
+// toolFlagSources says where each tool flag the builder passes comes from.
+// Flags not listed here take no value. -N and -l are handled separately,
+// since they depend on several builder flags.
+var toolFlagSources = map[string]string{
+	"-p":          "-p",
+	"-importcfg":  "generated from -stdimportcfg and dependency flags",
+	"-trimpath":   "sandbox directory and -srcmap",
+	"-symabis":    "generated from assembly sources",
+	"-asmhdr":     "generated for assembly sources",
+	"-o":          "output path",
+	"-I":          "include directory for assembly headers",
+	"-D":          "-goos, -goarch, and -goarch-variant",
+	"-X":          "-define",
+	"-gensymabis": "assembly sources",
+	"-S":          "-emit-asm",
+}
+
+// explainCommand writes cmd's arguments and environment to w, each with
+// its source.
+func explainCommand(w io.Writer, cmd *exec.Cmd) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\t# %s\n", cmd.Path, toolPathSource(cmd.Path))
+	args := cmd.Args[1:]
+	inputs := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case inputs || arg == "--":
+			if arg == "--" {
+				inputs = true
+				fmt.Fprintf(tw, "  --\t# end of flags\n")
+				continue
+			}
+			fmt.Fprintf(tw, "  %s\t# input\n", arg)
+
+		case i == 0 && cmd.Path == tools.packer:
+			fmt.Fprintf(tw, "  %s\t# pack operation: append object files\n", arg)
+
+		case arg == "-N":
+			fmt.Fprintf(tw, "  -N\t# -O 0\n")
+
+		case arg == "-l":
+			src := "-O " + optOptions.level
+			if optOptions.noInline {
+				src = "-no-inline"
+			}
+			fmt.Fprintf(tw, "  -l\t# %s\n", src)
+
+		case toolFlagSources[arg] != "":
+			src := toolFlagSources[arg]
+			if arg == "-gensymabis" || arg == "-S" || i+1 == len(args) {
+				fmt.Fprintf(tw, "  %s\t# %s\n", arg, src)
+				continue
+			}
+			i++
+			fmt.Fprintf(tw, "  %s %s\t# %s\n", arg, args[i], src)
+
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(tw, "  %s\t# builder default\n", arg)
+
+		default:
+			fmt.Fprintf(tw, "  %s\t# input\n", arg)
+		}
+	}
+	for _, kv := range target.env() {
+		fmt.Fprintf(tw, "  env %s\t# %s\n", kv, envSource(kv))
+	}
+	tw.Flush()
+}
+
+// toolPathSource returns the builder flag that set a tool's location.
+func toolPathSource(toolPath string) string {
+	switch toolPath {
+	case tools.compiler:
+		return "-compiler (toolchain)"
+	case tools.linker:
+		return "-linker (toolchain)"
+	case tools.assembler:
+		return "-assembler (toolchain)"
+	case tools.packer:
+		return "-packer (toolchain)"
+	case tools.objdump:
+		return "-objdump"
+	default:
+		return filepath.Base(toolPath)
+	}
+}
+
+// envSource returns the platform flag that sets an environment variable
+// passed to tools. By default, the target is the host platform.
+func envSource(kv string) string {
+	switch kv[:strings.Index(kv, "=")] {
+	case "GOOS":
+		return "-goos"
+	case "GOARCH":
+		return "-goarch"
+	case "CGO_ENABLED":
+		return "-cgo"
+	case "GOEXPERIMENT":
+		return "-goexperiment"
+	default:
+		return "-goarch-variant"
+	}
+}
+
+// printExplainedCommand writes cmd with its sources to stderr and the event
+// log if -explain-args is set.
+func printExplainedCommand(cmd *exec.Cmd) {
+	if explainArgs {
+		explainCommand(io.MultiWriter(os.Stderr, events.stderrWriter()), cmd)
+	}
+}
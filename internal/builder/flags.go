@@ -6,10 +6,42 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"os"
 	"strings"
 )
 
+// addCommonFlags registers flags accepted by all actions.
+func addCommonFlags(fs *flag.FlagSet) {
+	addDiagFlags(fs)
+	addSandboxFlags(fs)
+	addSrcMapFlags(fs)
+	fs.Var(eventsFlag{}, "events", "path to a file where JSON build events should be appended")
+	fs.Var(previousDigestFlag{}, "previous-digest", "SHA-256 digest of the primary output from a previous build, or a file starting with one; the event log and metadata report whether the output is unchanged")
+	fs.StringVar(&inputDigestsPath, "input-digests", "", "path to a manifest of expected input SHA-256 digests in sha256sum format, checked before running tools")
+	fs.StringVar(&replayFilePath, "replay-file", "", "path where a replay record should be written if the action fails; see the replay subcommand")
+	fs.BoolVar(&progress.enabled, "progress", false, "print a line with the elapsed time as each step of the action starts")
+	fs.StringVar(&tmpDir, "tmpdir", "", "directory for temporary files, which are named after the action's output instead of randomly, so logs from identical actions can be compared")
+}
+
+// requirePackagePath returns an error if strict is set and -p was not set
+// to a non-empty path on the command line. Whether the flag was set is
+// checked, not just its value, since some subcommands give -p a default.
+func requirePackagePath(fs *flag.FlagSet, strict bool) error {
+	if !strict {
+		return nil
+	}
+	pSet := false
+	fs.Visit(func(f *flag.Flag) { pSet = pSet || (f.Name == "p" && f.Value.String() != "") })
+	if !pSet {
+		return errors.New("-p is required with -strict")
+	}
+	return nil
+}
+
 // splitArgs splits an argument list into two lists: builder arguments (for this
 // program) and tool arguments (for an underlying tool like the compiler). The
 // "--" argument is used as a separator. If this argument is not found, all
@@ -29,10 +61,64 @@ func splitArgs(args []string) (builderArgs, toolArgs []string) {
 // path to the package's archive (e.g., "/opt/go/pkg/linux_amd64/fmt.a").
 type archive struct {
 	packagePath, filePath string
+
+	// optional archives may be missing, for example, platform-specific stubs
+	// that aren't built for every platform. Missing optional archives are
+	// skipped with a warning.
+	optional bool
+}
+
+// archivePaths returns the file paths of a list of archives.
+func archivePaths(archives []archive) []string {
+	paths := make([]string, len(archives))
+	for i, arc := range archives {
+		paths[i] = arc.filePath
+	}
+	return paths
+}
+
+// skipMissingOptionalArchives returns archives without optional archives
+// whose files don't exist. Skipped archives are reported in a warning and
+// in the event log.
+func skipMissingOptionalArchives(archives []archive) ([]archive, error) {
+	var kept []archive
+	var skipped []map[string]string
+	for _, arc := range archives {
+		if arc.optional {
+			if _, err := os.Stat(arc.filePath); os.IsNotExist(err) {
+				skipped = append(skipped, map[string]string{
+					"packagePath": arc.packagePath,
+					"file":        arc.filePath,
+				})
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		kept = append(kept, arc)
+	}
+	if len(skipped) == 0 {
+		return archives, nil
+	}
+	for _, s := range skipped {
+		log.Printf("warning: skipped missing optional archive for %s: %s", s["packagePath"], s["file"])
+	}
+	if events.path != "" {
+		if err := events.write(map[string]interface{}{
+			"id": map[string]interface{}{
+				"warning": map[string]string{"type": "optionalArchivesSkipped"},
+			},
+			"optionalArchivesSkipped": skipped,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
 }
 
 // archiveFlag parses archives from command line arguments. Archive values
-// have the form "packagePath=filePath".
+// have the form "packagePath=filePath". Values ending with ";optional" mark
+// optional archives.
 type archiveFlag struct {
 	archives *[]archive
 }
@@ -45,6 +131,9 @@ func (f archiveFlag) String() string {
 	sep := ""
 	for _, arc := range *f.archives {
 		fmt.Fprintf(b, "%s%s=%s", sep, arc.packagePath, arc.filePath)
+		if arc.optional {
+			b.WriteString(";optional")
+		}
 		sep = " "
 	}
 	return b.String()
@@ -59,6 +148,27 @@ func (f archiveFlag) Set(value string) error {
 		packagePath: value[:pos],
 		filePath:    value[pos+1:],
 	}
+	if strings.HasSuffix(arc.filePath, ";optional") {
+		arc.filePath = strings.TrimSuffix(arc.filePath, ";optional")
+		arc.optional = true
+	}
 	*f.archives = append(*f.archives, arc)
 	return nil
 }
+
+// stringListFlag appends each value of a repeated flag to a list.
+type stringListFlag struct {
+	values *[]string
+}
+
+func (f stringListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f stringListFlag) Set(value string) error {
+	*f.values = append(*f.values, value)
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file implements restrictions on what sources may use: language
+// features (-forbid) and imported packages (-allow-import, -deny-import).
+
+// forbiddenFeatures are language features that -forbid may reject.
+var forbiddenFeatures = stringSet("cgo linkname unsafe")
+
+// forbidFlag parses a comma-separated list of features for -forbid. It may
+// be repeated.
+type forbidFlag struct {
+	features map[string]bool
+}
+
+func (f forbidFlag) String() string {
+	var names []string
+	for name := range f.features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f forbidFlag) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !forbiddenFeatures[name] {
+			return fmt.Errorf("unknown feature %q; expected cgo, linkname, or unsafe", name)
+		}
+		f.features[name] = true
+	}
+	return nil
+}
+
+// checkForbidden reports uses of forbidden features in Go source files:
+//
+//	cgo: importing "C", or //go:cgo_* directives
+//	linkname: //go:linkname directives
+//	unsafe: importing "unsafe"
+//
+// Every use is listed in the returned error with its file and line.
+func checkForbidden(features map[string]bool, srcPaths []string) error {
+	if len(features) == 0 {
+		return nil
+	}
+	var uses []string
+	for _, path := range srcPaths {
+		fset := token.NewFileSet()
+		tree, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		report := func(pos token.Pos, feature, what string) {
+			p := fset.Position(pos)
+			uses = append(uses, fmt.Sprintf("%s:%d: %s (%s is forbidden)", mapSourcePath(p.Filename), p.Line, what, feature))
+		}
+		for _, spec := range tree.Imports {
+			imp, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if imp == "C" && features["cgo"] {
+				report(spec.Pos(), "cgo", `import "C"`)
+			} else if imp == "unsafe" && features["unsafe"] {
+				report(spec.Pos(), "unsafe", `import "unsafe"`)
+			}
+		}
+		for _, group := range tree.Comments {
+			for _, c := range group.List {
+				directive := strings.Fields(c.Text)
+				if len(directive) == 0 {
+					continue
+				}
+				switch {
+				case directive[0] == "//go:linkname" && features["linkname"]:
+					report(c.Pos(), "linkname", "//go:linkname directive")
+				case strings.HasPrefix(directive[0], "//go:cgo_") && features["cgo"]:
+					report(c.Pos(), "cgo", directive[0]+" directive")
+				}
+			}
+		}
+	}
+	if len(uses) > 0 {
+		return fmt.Errorf("sources use forbidden features:\n\t%s", strings.Join(uses, "\n\t"))
+	}
+	return nil
+}
+
+// importPolicy restricts the packages sources may import. Patterns are import
+// paths, or paths ending in "/..." that match a path and everything under it,
+// like "net/...". If any allowed patterns are given, every import must match
+// one. Denied patterns take precedence over allowed ones.
+type importPolicy struct {
+	allowed, denied []string
+}
+
+func (p importPolicy) isEmpty() bool {
+	return len(p.allowed) == 0 && len(p.denied) == 0
+}
+
+// check returns a reason imp violates the policy, or "" if it doesn't.
+func (p importPolicy) check(imp string) string {
+	for _, pattern := range p.denied {
+		if matchImportPattern(pattern, imp) {
+			return fmt.Sprintf("matches denied pattern %q", pattern)
+		}
+	}
+	if len(p.allowed) == 0 {
+		return ""
+	}
+	for _, pattern := range p.allowed {
+		if matchImportPattern(pattern, imp) {
+			return ""
+		}
+	}
+	return "doesn't match any allowed pattern"
+}
+
+// importPatternFlag appends import path patterns for -allow-import and
+// -deny-import to a list.
+type importPatternFlag struct {
+	patterns *[]string
+}
+
+func (f importPatternFlag) String() string {
+	if f.patterns == nil {
+		return ""
+	}
+	return strings.Join(*f.patterns, ",")
+}
+
+func (f importPatternFlag) Set(value string) error {
+	if value == "" || value == "..." || strings.ContainsAny(value, " \t") {
+		return fmt.Errorf("malformed import path pattern %q", value)
+	}
+	*f.patterns = append(*f.patterns, value)
+	return nil
+}
+
+func matchImportPattern(pattern, imp string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return imp == prefix || strings.HasPrefix(imp, prefix+"/")
+	}
+	return imp == pattern
+}
+
+// checkImportPolicy reports imports in Go source files that violate policy.
+// Every violation is listed in the returned error with its file and line.
+func checkImportPolicy(policy importPolicy, srcPaths []string) error {
+	if policy.isEmpty() {
+		return nil
+	}
+	var violations []string
+	for _, path := range srcPaths {
+		fset := token.NewFileSet()
+		tree, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, spec := range tree.Imports {
+			imp, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if reason := policy.check(imp); reason != "" {
+				p := fset.Position(spec.Pos())
+				violations = append(violations, fmt.Sprintf("%s:%d: import %q %s", mapSourcePath(p.Filename), p.Line, imp, reason))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("sources import packages not allowed by import policy:\n\t%s", strings.Join(violations, "\n\t"))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSources writes Go source files to a temporary directory and returns
+// their paths, in the order given. The caller removes the directory.
+func writeSources(t *testing.T, files ...[2]string) (dir string, paths []string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f[0])
+		if err := ioutil.WriteFile(path, []byte(f[1]), 0666); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths
+}
+
+func TestForbidFlag(t *testing.T) {
+	f := forbidFlag{make(map[string]bool)}
+	if err := f.Set("unsafe, cgo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("linkname,"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "cgo,linkname,unsafe"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if err := f.Set("reflect"); err == nil {
+		t.Error("unknown feature: unexpected success")
+	}
+}
+
+func TestCheckForbidden(t *testing.T) {
+	dir, paths := writeSources(t,
+		[2]string{"clean.go", "package p\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n"},
+		[2]string{"cgo.go", "package p\n\n//go:cgo_import_dynamic f f \"libc.so\"\n\nimport \"C\"\n"},
+		[2]string{"unsafe.go", "package p\n\nimport (\n\t_ \"embed\"\n\t\"unsafe\"\n)\n\n//go:linkname now runtime.nanotime\nfunc now() int64\n\nvar _ unsafe.Pointer\n"},
+	)
+	defer os.RemoveAll(dir)
+	cgoPath, unsafePath := paths[1], paths[2]
+
+	for _, tc := range []struct {
+		desc     string
+		features string
+		want     []string
+	}{
+		{
+			desc: "none",
+		}, {
+			desc:     "cgo",
+			features: "cgo",
+			want: []string{
+				cgoPath + `:5: import "C" (cgo is forbidden)`,
+				cgoPath + `:3: //go:cgo_import_dynamic directive (cgo is forbidden)`,
+			},
+		}, {
+			desc:     "unsafe_linkname",
+			features: "unsafe,linkname",
+			want: []string{
+				unsafePath + `:5: import "unsafe" (unsafe is forbidden)`,
+				unsafePath + `:8: //go:linkname directive (linkname is forbidden)`,
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := forbidFlag{make(map[string]bool)}
+			if err := f.Set(tc.features); err != nil {
+				t.Fatal(err)
+			}
+			err := checkForbidden(f.features, paths)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+			want := "sources use forbidden features:\n\t" + strings.Join(tc.want, "\n\t")
+			if got := err.Error(); got != want {
+				t.Errorf("got error:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestMatchImportPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, imp string
+		want         bool
+	}{
+		{"os/exec", "os/exec", true},
+		{"os/exec", "os", false},
+		{"os/exec", "os/execx", false},
+		{"net/...", "net", true},
+		{"net/...", "net/http", true},
+		{"net/...", "network", false},
+		{"example.com/...", "example.com/a/b", true},
+	} {
+		if got := matchImportPattern(tc.pattern, tc.imp); got != tc.want {
+			t.Errorf("matchImportPattern(%q, %q): got %v; want %v", tc.pattern, tc.imp, got, tc.want)
+		}
+	}
+}
+
+func TestImportPatternFlag(t *testing.T) {
+	var patterns []string
+	f := importPatternFlag{&patterns}
+	for _, bad := range []string{"", "...", "net /http"} {
+		if err := f.Set(bad); err == nil {
+			t.Errorf("%q: unexpected success", bad)
+		}
+	}
+	if err := f.Set("net/..."); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "net/..."; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestCheckImportPolicy(t *testing.T) {
+	dir, paths := writeSources(t,
+		[2]string{"a.go", "package p\n\nimport (\n\t\"fmt\"\n\t\"net/http\"\n\t\"os/exec\"\n)\n"},
+	)
+	defer os.RemoveAll(dir)
+	path := paths[0]
+
+	for _, tc := range []struct {
+		desc   string
+		policy importPolicy
+		want   []string
+	}{
+		{
+			desc: "empty",
+		}, {
+			desc:   "denied",
+			policy: importPolicy{denied: []string{"os/exec", "net/..."}},
+			want: []string{
+				path + `:5: import "net/http" matches denied pattern "net/..."`,
+				path + `:6: import "os/exec" matches denied pattern "os/exec"`,
+			},
+		}, {
+			desc:   "allowed",
+			policy: importPolicy{allowed: []string{"fmt", "net/..."}},
+			want: []string{
+				path + `:6: import "os/exec" doesn't match any allowed pattern`,
+			},
+		}, {
+			// Denied patterns take precedence over allowed ones.
+			desc:   "denied_and_allowed",
+			policy: importPolicy{allowed: []string{"fmt", "net/...", "os/exec"}, denied: []string{"net/http"}},
+			want: []string{
+				path + `:5: import "net/http" matches denied pattern "net/http"`,
+			},
+		}, {
+			desc:   "all_allowed",
+			policy: importPolicy{allowed: []string{"fmt", "net/...", "os/..."}},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkImportPolicy(tc.policy, paths)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+			want := "sources import packages not allowed by import policy:\n\t" + strings.Join(tc.want, "\n\t")
+			if got := err.Error(); got != want {
+				t.Errorf("got error:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
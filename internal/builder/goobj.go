@@ -0,0 +1,145 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// archiveImports returns the paths of the packages a compiled package
+// imports, read from the header of the _go_.o member of its archive. The
+// linker loads packages from the same list, so it includes imports that
+// don't appear in export data.
+func archiveImports(arcPath string) ([]string, error) {
+	f, err := os.Open(arcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	members, err := readArchive(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", arcPath, err)
+	}
+	for _, m := range members {
+		if m.name == "_go_.o" {
+			imports, err := objectImports(io.NewSectionReader(f, m.offset, m.size))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", arcPath, err)
+			}
+			return imports, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: archive has no _go_.o member", arcPath)
+}
+
+// objectImports reads the list of imported packages from a Go object file.
+// The file starts with a text header ending in "\n!\n", followed by a magic
+// string naming the format. In the format Go 1.12 and 1.13 write, imports
+// come next, as length-prefixed strings ending with an empty string. In the
+// format Go 1.16 and later write, a header gives the offset of each block;
+// the first block lists imports as references to strings elsewhere in the
+// file.
+func objectImports(r *io.SectionReader) ([]string, error) {
+	br := bufio.NewReader(r)
+	var last [3]byte
+	n := int64(0)
+	for last != [3]byte{'\n', '!', '\n'} {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, errors.New("malformed Go object: no end of header")
+		}
+		last[0], last[1], last[2] = last[1], last[2], c
+		n++
+	}
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, errors.New("malformed Go object: truncated")
+	}
+	switch {
+	case string(magic) == "\x00go112ld":
+		return legacyObjectImports(br)
+	case bytes.HasPrefix(magic, []byte("\x00go1")) && bytes.HasSuffix(magic, []byte("ld")):
+		if v, err := strconv.Atoi(string(magic[4:6])); err == nil && v >= 16 {
+			return indexedObjectImports(io.NewSectionReader(r, n, r.Size()-n))
+		}
+	}
+	return nil, fmt.Errorf("unsupported Go object format %q", magic[1:])
+}
+
+// legacyObjectImports reads imports in the Go 1.12 object format. Integers
+// are zigzag-encoded varints.
+func legacyObjectImports(br *bufio.Reader) ([]string, error) {
+	if v, err := br.ReadByte(); err != nil || v != 1 {
+		return nil, errors.New("malformed Go object: unknown version")
+	}
+	var imports []string
+	for {
+		u, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, errors.New("malformed Go object: truncated import list")
+		}
+		size := int64(u>>1) ^ -int64(u&1)
+		if size == 0 {
+			return imports, nil
+		}
+		if size < 0 || size > 4096 {
+			return nil, errors.New("malformed Go object: bad import path length")
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, errors.New("malformed Go object: truncated import list")
+		}
+		imports = append(imports, string(buf))
+	}
+}
+
+// indexedObjectImports reads imports in the Go 1.16 object format. r starts
+// at the magic string; offsets in the file are relative to it. The header
+// has the magic string, an 8-byte fingerprint, 4 bytes of flags, and
+// 4-byte block offsets. Each entry in the import block is a string
+// reference (a 4-byte length and offset) and an 8-byte fingerprint.
+func indexedObjectImports(r *io.SectionReader) ([]string, error) {
+	const offsetsStart = 8 + 8 + 4
+	const entrySize = 8 + 8
+	hdr := make([]byte, offsetsStart+8)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, errors.New("malformed Go object: truncated header")
+	}
+	start := int64(binary.LittleEndian.Uint32(hdr[offsetsStart:]))
+	end := int64(binary.LittleEndian.Uint32(hdr[offsetsStart+4:]))
+	if start > end || end > r.Size() || (end-start)%entrySize != 0 {
+		return nil, errors.New("malformed Go object: bad import block")
+	}
+	block := make([]byte, end-start)
+	if _, err := r.ReadAt(block, start); err != nil {
+		return nil, errors.New("malformed Go object: truncated import block")
+	}
+	var imports []string
+	for i := 0; i < len(block); i += entrySize {
+		size := int64(binary.LittleEndian.Uint32(block[i:]))
+		off := int64(binary.LittleEndian.Uint32(block[i+4:]))
+		if off+size > r.Size() {
+			return nil, errors.New("malformed Go object: bad import path")
+		}
+		buf := make([]byte, size)
+		if _, err := r.ReadAt(buf, off); err != nil {
+			return nil, errors.New("malformed Go object: truncated import path")
+		}
+		imports = append(imports, string(buf))
+	}
+	return imports, nil
+}
@@ -0,0 +1,96 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectImportsLegacy(t *testing.T) {
+	obj := &bytes.Buffer{}
+	obj.WriteString("go object linux amd64 go1.13.4 X:none\n\n!\n\x00go112ld\x01")
+	for _, s := range []string{"fmt", "example.com/a", ""} {
+		var n [binary.MaxVarintLen64]byte
+		obj.Write(n[:binary.PutUvarint(n[:], uint64(len(s))<<1)])
+		obj.WriteString(s)
+	}
+	obj.WriteString("rest of object")
+	got, err := objectImports(io.NewSectionReader(bytes.NewReader(obj.Bytes()), 0, int64(obj.Len())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fmt", "example.com/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// TestArchiveImports reads imports from archives written by the compiler,
+// and checks that -std-overlap=replace rejects replacing a package other
+// standard library packages import.
+func TestArchiveImports(t *testing.T) {
+	defer func(old string) { stdOverlap = old }(stdOverlap)
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir, err := ioutil.TempDir("", "TestArchiveImports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// b imports a, and c imports nothing.
+	importcfgPath := filepath.Join(dir, "importcfg")
+	if err := ioutil.WriteFile(importcfgPath, []byte("packagefile a="+filepath.Join(dir, "a.a")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	arcs := make(map[string]string)
+	for _, pkg := range []struct{ name, src string }{
+		{"a", "package a\n\nfunc F() int { return 1 }\n"},
+		{"b", "package b\n\nimport \"a\"\n\nvar V = a.F()\n"},
+		{"c", "package c\n\nfunc F() {}\n"},
+	} {
+		srcPath := filepath.Join(dir, pkg.name+".go")
+		if err := ioutil.WriteFile(srcPath, []byte(pkg.src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		arcs[pkg.name] = filepath.Join(dir, pkg.name+".a")
+		cmd := exec.Command(goPath, "tool", "compile", "-pack", "-p", pkg.name, "-importcfg", importcfgPath, "-o", arcs[pkg.name], srcPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("compiling %s: %v\n%s", pkg.name, err, out)
+		}
+	}
+
+	for name, want := range map[string][]string{"a": nil, "b": {"a"}, "c": nil} {
+		got, err := archiveImports(arcs[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got imports %q; want %q", name, got, want)
+		}
+	}
+
+	stdOverlap = "replace"
+	stdArchives := map[string]string{"a": arcs["a"], "b": arcs["b"], "c": arcs["c"]}
+	_, err = resolveStdOverlap(stdArchives, []archive{{packagePath: "a", filePath: "stub/a.a"}})
+	if err == nil || !strings.Contains(err.Error(), "b imports a") {
+		t.Errorf("replacing a: got error %v; want error saying b imports a", err)
+	}
+	stdArchives = map[string]string{"a": arcs["a"], "b": arcs["b"], "c": arcs["c"]}
+	if _, err := resolveStdOverlap(stdArchives, []archive{{packagePath: "c", filePath: "stub/c.a"}}); err != nil {
+		t.Errorf("replacing c: %v", err)
+	}
+}
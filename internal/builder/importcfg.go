@@ -6,11 +6,15 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha256"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -21,18 +25,77 @@ import (
 // standard library.
 func stdImportcfg(args []string) error {
 	// Process command line arguments.
-	var outPath string
+	var outPath, manifestPath, goPath string
 	fs := flag.NewFlagSet("stdimportcfg", flag.ExitOnError)
 	fs.StringVar(&outPath, "o", "", "path to standard library importcfg")
+	fs.StringVar(&manifestPath, "manifest", "", "path to a JSON manifest of standard library archives, produced by stdmanifest (optional)")
+	fs.StringVar(&goPath, "go", "", "path to the go command of the distribution in GOROOT; its version is recorded in the importcfg (optional)")
+	addCommonFlags(fs)
 	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
 
-	// Walk the directory of compiled archives. Each archive's location
-	// corresponds with its package path, so we don't need to run 'go list'.
-	archiveMap := make(map[string]string)
-	goroot, ok := os.LookupEnv("GOROOT")
-	if !ok {
-		return fmt.Errorf("GOROOT not set")
+	goroot, err := getenv("GOROOT")
+	if err != nil {
+		return err
+	}
+	if err := checkSandbox(goroot, outPath, manifestPath, goPath); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+
+	// If a manifest was given, read archive locations from it. Otherwise, walk
+	// the directory of compiled archives.
+	var archiveMap map[string]string
+	if manifestPath != "" {
+		archiveMap, err = readStdManifest(manifestPath, goroot)
+	} else {
+		archiveMap, err = walkStdArchives(goroot)
+	}
+	if err != nil {
+		return err
+	}
+
+	var other []string
+	if goPath != "" {
+		version, err := goCommandVersion(goPath)
+		if err != nil {
+			return err
+		}
+		other = append(other, "# "+version)
+	}
+	return writeImportcfg(archiveMap, outPath, other...)
+}
+
+// goCommandVersion runs "go version" with a specific go command and returns
+// its output, like "go version go1.13.4 linux/amd64". The command is never
+// looked up in PATH, so the version describes the distribution in use.
+func goCommandVersion(goPath string) (string, error) {
+	if !filepath.IsAbs(goPath) && !strings.ContainsRune(goPath, filepath.Separator) {
+		goPath = "." + string(filepath.Separator) + goPath
+	}
+	cmd := exec.Command(goPath, "version")
+	cmd.Env = toolEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s version: %v", goPath, err)
+	}
+	version := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(version, "go version ") {
+		return "", fmt.Errorf("%s version: unexpected output %q", goPath, out)
 	}
+	return version, nil
+}
+
+// walkStdArchives returns a map from standard library package paths to
+// archive files in goroot. Each archive's location corresponds with its
+// package path, so we don't need to run 'go list'.
+func walkStdArchives(goroot string) (map[string]string, error) {
+	archiveMap := make(map[string]string)
 	pkgDir := filepath.Join(goroot, "pkg", runtime.GOOS+"_"+runtime.GOARCH)
 	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -45,27 +108,86 @@ func stdImportcfg(args []string) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return archiveMap, nil
+}
 
-	return writeImportcfg(archiveMap, outPath)
+// importcfgFile is the parsed contents of an importcfg file.
+type importcfgFile struct {
+	// archives maps package paths to archive file paths.
+	archives map[string]string
+
+	// other holds the lines that aren't packagefile lines, such as comments,
+	// importmap lines, and verbs the builder doesn't know, in their original
+	// order. They're written back when the standard library importcfg itself
+	// is rewritten, so hand-edited or tool-augmented files aren't mangled.
+	// Importcfgs generated for tools get only the lines from toolLines.
+	other []string
+
+	// target is the platform recorded in the file's provenance comments,
+	// formatted as goos/goarch, or "" if the file has none.
+	target string
 }
 
+// toolLines returns the lines of cfg other than packagefile lines that are
+// copied into an importcfg generated for tool, "compile" or "link". Both
+// tools stop with an error on a verb they don't know, and the linker only
+// accepts packagefile and packageshlib lines, so only importmap lines are
+// copied, and only for the compiler.
+func (cfg importcfgFile) toolLines(tool string) []string {
+	if tool != "compile" {
+		return nil
+	}
+	var lines []string
+	for _, line := range cfg.other {
+		if strings.HasPrefix(line, "importmap ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// importcfgHeaderPrefix starts the provenance comments the builder writes at
+// the top of each importcfg. They're dropped when a file is read, so they
+// describe only the file they're in.
+const importcfgHeaderPrefix = "# builder: "
+
 // readImportcfg parses an importcfg file. It returns a map from package paths
 // to archive file paths.
 func readImportcfg(importcfgPath string) (map[string]string, error) {
-	archiveMap := make(map[string]string)
+	cfg, err := readImportcfgFile(importcfgPath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.archives, nil
+}
+
+// readImportcfgFile parses an importcfg file, keeping lines other than
+// packagefile lines.
+func readImportcfgFile(importcfgPath string) (importcfgFile, error) {
+	cfg := importcfgFile{archives: make(map[string]string)}
 
 	data, err := ioutil.ReadFile(importcfgPath)
 	if err != nil {
-		return nil, err
+		return importcfgFile{}, err
 	}
 
 	// based on parsing code in cmd/link/internal/ld/ld.go
 	for lineNum, line := range strings.Split(string(data), "\n") {
 		lineNum++ // 1-based
 		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, importcfgHeaderPrefix) {
+			if t := strings.TrimPrefix(line, importcfgHeaderPrefix+"target "); t != line {
+				cfg.target = t
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			cfg.other = append(cfg.other, line)
 			continue
 		}
 
@@ -80,17 +202,124 @@ func readImportcfg(importcfgPath string) (map[string]string, error) {
 			before, after = args[:i], args[i+1:]
 		}
 		if verb == "packagefile" {
-			archiveMap[before] = after
+			cfg.archives[before] = after
+		} else {
+			cfg.other = append(cfg.other, line)
 		}
 	}
 
-	return archiveMap, nil
+	return cfg, nil
+}
+
+// stdOverlap says what to do when a dependency archive has the same package
+// path as a standard library package. It's set with -std-overlap. Normally
+// the standard library archive takes precedence, since standard library
+// packages are compiled against each other and can't be replaced one at a
+// time. "warn" reports the overlap, "error" fails the action, and "ignore"
+// drops the dependency archive silently. "replace" uses the dependency
+// archive instead, for stubs generated by genstubs; it's an error if any
+// other standard library package imports a replaced package.
+var stdOverlap = "warn"
+
+type stdOverlapFlag struct{}
+
+func (stdOverlapFlag) String() string { return stdOverlap }
+
+func (stdOverlapFlag) Set(value string) error {
+	switch value {
+	case "warn", "error", "ignore", "replace":
+		stdOverlap = value
+		return nil
+	default:
+		return fmt.Errorf("-std-overlap must be warn, error, ignore, or replace; got %q", value)
+	}
+}
+
+// addStdOverlapFlag registers -std-overlap for actions that merge
+// dependency archives with the standard library importcfg.
+func addStdOverlapFlag(fs *flag.FlagSet) {
+	fs.Var(stdOverlapFlag{}, "std-overlap", "what to do when a dependency provides a standard library package path: warn, error, or ignore to use the standard library archive, or replace to use the dependency")
+}
+
+// resolveStdOverlap returns archives without those whose package paths are
+// also in stdArchives, reporting them according to -std-overlap. With
+// -std-overlap=replace, archives are returned unchanged, and the packages
+// they provide are deleted from stdArchives instead. It's an error if other
+// standard library packages import them.
+func resolveStdOverlap(stdArchives map[string]string, archives []archive) ([]archive, error) {
+	if stdOverlap == "replace" {
+		replaced := make(map[string]bool)
+		for _, arc := range archives {
+			if _, ok := stdArchives[arc.packagePath]; ok {
+				replaced[arc.packagePath] = true
+				delete(stdArchives, arc.packagePath)
+			}
+		}
+		if err := checkStdReplaced(stdArchives, replaced); err != nil {
+			return nil, err
+		}
+		return archives, nil
+	}
+	kept := make([]archive, 0, len(archives))
+	var overlaps []string
+	for _, arc := range archives {
+		if stdPath, ok := stdArchives[arc.packagePath]; ok {
+			overlaps = append(overlaps, fmt.Sprintf("%s: dependency archive %s overlaps standard library archive %s", arc.packagePath, arc.filePath, stdPath))
+			continue
+		}
+		kept = append(kept, arc)
+	}
+	if len(overlaps) == 0 || stdOverlap == "ignore" {
+		return kept, nil
+	}
+	if stdOverlap == "error" {
+		return nil, fmt.Errorf("dependencies provide standard library packages:\n\t%s", strings.Join(overlaps, "\n\t"))
+	}
+	for _, o := range overlaps {
+		log.Printf("warning: %s; using the standard library archive", o)
+	}
+	return kept, nil
+}
+
+// checkStdReplaced returns an error if any package in stdArchives imports a
+// package in replaced. Those packages were compiled against the original
+// packages, so they'd refer to symbols replacements don't define, or define
+// differently.
+func checkStdReplaced(stdArchives map[string]string, replaced map[string]bool) error {
+	if len(replaced) == 0 {
+		return nil
+	}
+	pkgPaths := make([]string, 0, len(stdArchives))
+	for pkgPath := range stdArchives {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+	imports := make([][]string, len(pkgPaths))
+	errs := make([]error, len(pkgPaths))
+	parallelFor(len(pkgPaths), func(i int) {
+		imports[i], errs[i] = archiveImports(stdArchives[pkgPaths[i]])
+	})
+	var conflicts []string
+	for i, pkgPath := range pkgPaths {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		for _, imp := range imports[i] {
+			if replaced[imp] {
+				conflicts = append(conflicts, fmt.Sprintf("%s imports %s", pkgPath, imp))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("-std-overlap=replace: standard library packages import replaced packages, so they can't be linked with the replacements:\n\t%s", strings.Join(conflicts, "\n\t"))
+	}
+	return nil
 }
 
 // writeTempImportcfg writes a temporary importcfg file. The caller is
 // responsible for deleting it.
-func writeTempImportcfg(archiveMap map[string]string) (string, error) {
-	tmpFile, err := ioutil.TempFile("", "importcfg-*")
+func writeTempImportcfg(archiveMap map[string]string, other ...string) (string, error) {
+	tmpFile, err := createTempFile("importcfg-*")
 	if err != nil {
 		return "", err
 	}
@@ -99,24 +328,64 @@ func writeTempImportcfg(archiveMap map[string]string) (string, error) {
 		os.Remove(tmpPath)
 		return "", err
 	}
-	if err := writeImportcfg(archiveMap, tmpPath); err != nil {
+	if err := writeImportcfg(archiveMap, tmpPath, other...); err != nil {
 		os.Remove(tmpPath)
 		return "", err
 	}
 	return tmpPath, nil
 }
 
-func writeImportcfg(archiveMap map[string]string, outPath string) error {
+// writeImportcfg writes an importcfg file mapping package paths to archive
+// files. The file starts with comments recording the builder version, the
+// target platform, and a digest of the packagefile lines, followed by other
+// lines, usually preserved from an importcfg that was read. The packagefile
+// lines are written twice, first to a hash and then to the file, so they're
+// never all in memory.
+func writeImportcfg(archiveMap map[string]string, outPath string, other ...string) error {
+	h := sha256.New()
+	if err := writeImportcfgTo(h, archiveMap); err != nil {
+		return err
+	}
+	f, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%sversion %s\n", importcfgHeaderPrefix, builderVersion)
+	fmt.Fprintf(w, "%starget %s/%s\n", importcfgHeaderPrefix, target.goos, target.goarch)
+	fmt.Fprintf(w, "%spackagefile sha256 %x\n", importcfgHeaderPrefix, h.Sum(nil))
+	for _, line := range other {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	if err := writeImportcfgTo(w, archiveMap); err != nil {
+		f.abort()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// writeImportcfgTo writes importcfg lines to w, sorted by package path.
+// Links may have thousands of archives, so lines are streamed through a
+// buffer instead of building the whole file in memory, and package paths
+// are sorted once.
+func writeImportcfgTo(w io.Writer, archiveMap map[string]string) error {
 	pkgPaths := make([]string, 0, len(archiveMap))
 	for pkgPath := range archiveMap {
 		pkgPaths = append(pkgPaths, pkgPath)
 	}
 	sort.Strings(pkgPaths)
 
-	buf := &bytes.Buffer{}
+	bw := bufio.NewWriterSize(w, 64*1024)
 	for _, pkgPath := range pkgPaths {
-		fmt.Fprintf(buf, "packagefile %s=%s\n", pkgPath, archiveMap[pkgPath])
+		bw.WriteString("packagefile ")
+		bw.WriteString(pkgPath)
+		bw.WriteByte('=')
+		bw.WriteString(archiveMap[pkgPath])
+		bw.WriteByte('\n')
 	}
-
-	return ioutil.WriteFile(outPath, buf.Bytes(), 0666)
+	return bw.Flush()
 }
@@ -0,0 +1,222 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// largeArchiveMap returns a map with n packages, like the importcfg for a
+// link with many dependencies.
+func largeArchiveMap(n int) map[string]string {
+	archiveMap := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		pkgPath := fmt.Sprintf("example.com/module%d/internal/pkg%d", i%97, i)
+		archiveMap[pkgPath] = fmt.Sprintf("bazel-out/k8-fastbuild/bin/module%d/pkg%d_/pkg.a", i%97, i)
+	}
+	return archiveMap
+}
+
+func TestWriteImportcfgTo(t *testing.T) {
+	archiveMap := map[string]string{
+		"fmt":           "/goroot/pkg/fmt.a",
+		"example.com/a": "a.a",
+		"errors":        "/goroot/pkg/errors.a",
+	}
+	buf := &bytes.Buffer{}
+	if err := writeImportcfgTo(buf, archiveMap); err != nil {
+		t.Fatal(err)
+	}
+	want := "packagefile errors=/goroot/pkg/errors.a\n" +
+		"packagefile example.com/a=a.a\n" +
+		"packagefile fmt=/goroot/pkg/fmt.a\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImportcfgRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importcfg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "importcfg")
+
+	want := largeArchiveMap(10000)
+	if err := writeImportcfg(want, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readImportcfg(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %d packages, wrote %d; maps differ", len(got), len(want))
+	}
+}
+
+func BenchmarkWriteImportcfg(b *testing.B) {
+	archiveMap := largeArchiveMap(5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := writeImportcfgTo(ioutil.Discard, archiveMap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestImportcfgPreservesOtherLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importcfg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "importcfg")
+	content := "# go version go1.13.4 linux/amd64\n" +
+		"packagefile fmt=/goroot/pkg/fmt.a\n" +
+		"importmap old/path=new/path\n" +
+		"\n" +
+		"customverb some args\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read and write the file twice. Provenance comments written the first
+	// time should be replaced, not accumulated.
+	wantOther := []string{
+		"# go version go1.13.4 linux/amd64",
+		"importmap old/path=new/path",
+		"customverb some args",
+	}
+	for i := 0; i < 2; i++ {
+		cfg, err := readImportcfgFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cfg.other, wantOther) {
+			t.Fatalf("round %d: other lines: got %q; want %q", i, cfg.other, wantOther)
+		}
+		if want := map[string]string{"fmt": "/goroot/pkg/fmt.a"}; !reflect.DeepEqual(cfg.archives, want) {
+			t.Fatalf("round %d: archives: got %v; want %v", i, cfg.archives, want)
+		}
+		wantTarget := ""
+		if i > 0 {
+			wantTarget = target.goos + "/" + target.goarch
+		}
+		if cfg.target != wantTarget {
+			t.Fatalf("round %d: target: got %q; want %q", i, cfg.target, wantTarget)
+		}
+		if err := writeImportcfg(cfg.archives, path, cfg.other...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte(importcfgHeaderPrefix+"version ")); n != 1 {
+		t.Errorf("got %d version comments; want 1:\n%s", n, data)
+	}
+}
+
+func TestImportcfgToolLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importcfg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stdPath := filepath.Join(dir, "std.importcfg")
+	content := "# go version go1.13.4 linux/amd64\n" +
+		"packagefile fmt=/goroot/pkg/fmt.a\n" +
+		"importmap old/path=new/path\n" +
+		"modinfo \"info\"\n" +
+		"customverb some args\n"
+	if err := ioutil.WriteFile(stdPath, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	stdCfg, err := readImportcfgFile(stdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		tool string
+		want []string
+	}{
+		{"link", []string{"packagefile fmt=/goroot/pkg/fmt.a"}},
+		{"compile", []string{"importmap old/path=new/path", "packagefile fmt=/goroot/pkg/fmt.a"}},
+	} {
+		// Build the importcfg the way link and compile do, then check the
+		// tool would accept every line.
+		path, err := writeTempImportcfg(stdCfg.archives, stdCfg.toolLines(tc.tool)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		os.Remove(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if !strings.HasPrefix(line, "#") {
+				got = append(got, line)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s importcfg: got %q; want %q", tc.tool, got, tc.want)
+		}
+	}
+}
+
+func TestResolveStdOverlap(t *testing.T) {
+	defer func(old string) { stdOverlap = old }(stdOverlap)
+	stdArchives := map[string]string{"runtime": "/goroot/pkg/runtime.a"}
+	archives := []archive{
+		{packagePath: "runtime", filePath: "custom/runtime.a"},
+		{packagePath: "example.com/a", filePath: "a.a"},
+	}
+	want := []archive{{packagePath: "example.com/a", filePath: "a.a"}}
+
+	for _, mode := range []string{"warn", "ignore"} {
+		stdOverlap = mode
+		got, err := resolveStdOverlap(stdArchives, archives)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v; want %v", mode, got, want)
+		}
+	}
+
+	stdOverlap = "error"
+	if _, err := resolveStdOverlap(stdArchives, archives); err == nil {
+		t.Error("error: got success; want error")
+	}
+	if _, err := resolveStdOverlap(stdArchives, want); err != nil {
+		t.Errorf("error: no overlap: %v", err)
+	}
+
+	stdOverlap = "replace"
+	got, err := resolveStdOverlap(stdArchives, archives)
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if !reflect.DeepEqual(got, archives) {
+		t.Errorf("replace: got %v; want %v", got, archives)
+	}
+	if _, ok := stdArchives["runtime"]; ok {
+		t.Error("replace: runtime is still in the standard library archives")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// layerModTime is the modification time of every entry in a layer tarball.
+// It's fixed so layers are reproducible. Some tools treat a zero time as
+// missing, so this is the start of 2000, like other Bazel image rules.
+var layerModTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// packLayer writes a tar file containing a binary and its runfiles, laid out
+// the way 'bazel run' would see them, for use as a container image layer.
+// The binary is written to dir/name, and each runfile is written below
+// dir/name.runfiles. The data manifest, if given, is written to
+// dir/name.data_manifest, where the runfiles library looks for it. Entries are sorted, owned by root, and have fixed
+// modification times, so the tar file is the same every time its inputs
+// are.
+func packLayer(args []string) error {
+	// Process command line arguments.
+	var outPath, binPath, dataManifestPath, dir, name string
+	var runfileArgs []string
+	fs := flag.NewFlagSet("pack-layer", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "path to the tar file to write")
+	fs.StringVar(&binPath, "binary", "", "path to the executable to put in the layer")
+	fs.StringVar(&dataManifestPath, "data-manifest", "", "path to the executable's data manifest, written next to it in the image")
+	fs.StringVar(&dir, "dir", "/app", "absolute directory in the image where the executable is written")
+	fs.StringVar(&name, "name", "", "name of the executable in the image (default: the base name of -binary)")
+	fs.Var(stringListFlag{&runfileArgs}, "runfile", "runfile to include, formatted as runfilespath=file (may be repeated)")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if len(fs.Args()) != 0 {
+		return fmt.Errorf("expected 0 positional arguments; got %d", len(fs.Args()))
+	}
+	if outPath == "" || binPath == "" {
+		return fmt.Errorf("-o and -binary must be set")
+	}
+	if !path.IsAbs(dir) {
+		return fmt.Errorf("-dir must be an absolute path; got %q", dir)
+	}
+	if name == "" {
+		name = path.Base(strings.Replace(binPath, "\\", "/", -1))
+	}
+
+	// Map paths in the image to files.
+	binDest := path.Join(dir, name)
+	files := map[string]string{binDest: binPath}
+	sandboxPaths := []string{outPath, binPath, dataManifestPath}
+	if dataManifestPath != "" {
+		files[binDest+".data_manifest"] = dataManifestPath
+	}
+	for _, arg := range runfileArgs {
+		i := strings.Index(arg, "=")
+		if i <= 0 || i == len(arg)-1 {
+			return fmt.Errorf("-runfile must be formatted as runfilespath=file; got %q", arg)
+		}
+		rpath, filePath := arg[:i], arg[i+1:]
+		if path.IsAbs(rpath) || path.Clean(rpath) != rpath || strings.HasPrefix(rpath, "../") {
+			return fmt.Errorf("-runfile: invalid runfiles path %q", rpath)
+		}
+		dest := path.Join(binDest+".runfiles", rpath)
+		if other, ok := files[dest]; ok && other != filePath {
+			return fmt.Errorf("-runfile: %s and %s both have the runfiles path %s", other, filePath, rpath)
+		}
+		files[dest] = filePath
+		sandboxPaths = append(sandboxPaths, filePath)
+	}
+	if err := checkSandbox(sandboxPaths...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+
+	f, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeLayer(w, files, binDest); err != nil {
+		f.abort()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// writeLayer writes a tar file to w with an entry for each path in the
+// image in files, which maps paths to files on disk, and for each of their
+// parent directories. binDest is the path of the executable.
+func writeLayer(w io.Writer, files map[string]string, binDest string) error {
+	// Each file's parent directories need their own entries, so the image
+	// has directories with the same fixed metadata.
+	dirs := make(map[string]bool)
+	dests := make([]string, 0, len(files))
+	for dest := range files {
+		dests = append(dests, dest)
+		for d := path.Dir(dest); d != "/" && !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	for d := range dirs {
+		dests = append(dests, d)
+	}
+	sort.Strings(dests)
+
+	tw := tar.NewWriter(w)
+	for _, dest := range dests {
+		if err := writeLayerEntry(tw, dest, files[dest], dest == binDest); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeLayerEntry writes a tar entry for dest, a path in the image. If
+// filePath is empty, dest is a directory. Files are readable by everyone,
+// and executable if they're the main binary or executable on disk.
+func writeLayerEntry(tw *tar.Writer, dest, filePath string, isBinary bool) error {
+	hdr := &tar.Header{
+		Name:    strings.TrimPrefix(dest, "/"),
+		ModTime: layerModTime,
+		Format:  tar.FormatPAX,
+	}
+	if filePath == "" {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0755
+		return tw.WriteHeader(hdr)
+	}
+
+	r, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: directories can't be packed; list the files they contain", filePath)
+	}
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = info.Size()
+	hdr.Mode = 0644
+	if isBinary || info.Mode()&0111 != 0 {
+		hdr.Mode = 0755
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
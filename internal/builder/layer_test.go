@@ -0,0 +1,103 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWriteLayerReproducible packs the same files twice, with different
+// modification times and permissions on disk in between, and checks that
+// the tar files are identical.
+func TestWriteLayerReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriteLayerReproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"/app/hello":                                 "hello",
+		"/app/hello.data_manifest":                   "hello.data_manifest",
+		"/app/hello.runfiles/ws/data/foo.txt":        "foo.txt",
+		"/app/hello.runfiles/ws/data/nested/bar.txt": "bar.txt",
+	}
+	for dest, name := range files {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(dest), 0600); err != nil {
+			t.Fatal(err)
+		}
+		files[dest] = p
+	}
+
+	pack := func() []byte {
+		buf := &bytes.Buffer{}
+		if err := writeLayer(buf, files, "/app/hello"); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := pack()
+	later := time.Now().Add(time.Hour)
+	for _, p := range files {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if second := pack(); !bytes.Equal(first, second) {
+		t.Fatal("packing the same files twice produced different tar files")
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(layerModTime) || hdr.Uid != 0 || hdr.Gid != 0 {
+			t.Errorf("%s: got time %v, uid %d, gid %d; want %v, 0, 0", hdr.Name, hdr.ModTime, hdr.Uid, hdr.Gid, layerModTime)
+		}
+		wantMode := int64(0644)
+		if hdr.Typeflag == tar.TypeDir || hdr.Name == "app/hello" {
+			wantMode = 0755
+		}
+		if hdr.Mode != wantMode {
+			t.Errorf("%s: got mode %o; want %o", hdr.Name, hdr.Mode, wantMode)
+		}
+	}
+	want := []string{
+		"app/",
+		"app/hello",
+		"app/hello.data_manifest",
+		"app/hello.runfiles/",
+		"app/hello.runfiles/ws/",
+		"app/hello.runfiles/ws/data/",
+		"app/hello.runfiles/ws/data/foo.txt",
+		"app/hello.runfiles/ws/data/nested/",
+		"app/hello.runfiles/ws/data/nested/bar.txt",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("got entries %q; want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got entries %q; want %q", names, want)
+		}
+	}
+}
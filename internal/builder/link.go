@@ -6,55 +6,231 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 // link produces an executable file from a main archive file and a list of
 // dependencies (both direct and transitive).
 func link(args []string) error {
 	// Process command line arguments.
-	var stdImportcfgPath, mainPath, outPath string
-	var archives []archive
+	var stdImportcfgPath, mainPath, outPath, linkMapPath, expectPath string
+	var stamp bool
+	var defines []string
+	var archives, directArchives, transitiveArchives []archive
+	var graph depGraph
+	abi := abiFlag{make(map[string]string)}
 	fs := flag.NewFlagSet("link", flag.ExitOnError)
 	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library")
-	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies (including transitive dependencies), formatted as packagepath=file (may be repeated)")
+	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies (including transitive dependencies), formatted as packagepath=file or packagepath=file;optional (may be repeated)")
+	fs.Var(archiveFlag{&directArchives}, "direct", "like -arc, but for a direct dependency of the main package")
+	fs.Var(archiveFlag{&transitiveArchives}, "transitive", "like -arc, but for a dependency that's only reachable through other dependencies")
+	fs.Var(depLabelFlag{&graph}, "deplabel", "label of a dependency, formatted as packagepath=label (may be repeated)")
+	fs.Var(depEdgeFlag{&graph}, "depedge", "imports of a dependency, formatted as packagepath=imp1,imp2 (may be repeated)")
+	fs.Var(depsManifestFlag{&archives, &graph, false}, "deps-manifest", "JSON manifest describing dependencies, an alternative to -arc, -deplabel, and -depedge")
+	fs.Var(abi, "abi", "ABI file written when a package was compiled, formatted as packagepath=file; link checks that archives match it (may be repeated)")
 	fs.StringVar(&mainPath, "main", "", "path to main package archive file")
 	fs.StringVar(&outPath, "o", "", "path to binary file the linker should produce")
+	fs.Var(defineFlag{&defines}, "define", "set a string variable, formatted as packagepath.name=value (may be repeated)")
+	fs.BoolVar(&stamp, "stamp", false, "append information about the builder, toolchain, and target platform to the binary, which 'version -file' prints")
+	addStdOverlapFlag(fs)
+	fs.StringVar(&linkMapPath, "link-map", "", "path to a JSON file describing the binary's layout: package init order and the package and address of each symbol")
+	fs.StringVar(&expectPath, "expect", "", "path to a binary the linked binary must be identical to; used to check that a builder rebuilds itself reproducibly")
+	addCommonFlags(fs)
+	addToolFlags(fs)
+	addPlatformFlags(fs)
 	fs.Parse(args)
+	events.addOutput(outPath)
+	if linkMapPath != "" {
+		events.addOutput(linkMapPath)
+	}
+	if err := events.begin(); err != nil {
+		return err
+	}
 	if len(fs.Args()) != 0 {
 		return fmt.Errorf("expected 0 positional arguments; got %d", len(fs.Args()))
 	}
+	for _, arc := range directArchives {
+		graph.markDirect(arc.packagePath)
+	}
+	archives = append(archives, directArchives...)
+	archives = append(archives, transitiveArchives...)
+
+	sandboxPaths := append([]string{stdImportcfgPath, mainPath, outPath, linkMapPath, expectPath}, archivePaths(archives)...)
+	for _, abiPath := range abi.abiFiles {
+		sandboxPaths = append(sandboxPaths, abiPath)
+	}
+	if err := checkSandbox(sandboxPaths...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+	archives, err := skipMissingOptionalArchives(archives)
+	if err != nil {
+		return err
+	}
+
+	// Check for import cycles among dependencies. The linker would otherwise
+	// report duplicate or missing symbols without explaining why.
+	if cycle := graph.findAnyCycle(); cycle != nil {
+		return graph.cycleError(cycle, "")
+	}
 
 	// Build an importcfg file.
-	archiveMap, err := readImportcfg(stdImportcfgPath)
+	stdCfg, err := readImportcfgFile(stdImportcfgPath)
 	if err != nil {
 		return err
 	}
+	if err := checkStdTarget(stdCfg, stdImportcfgPath); err != nil {
+		return err
+	}
+	if archives, err = resolveStdOverlap(stdCfg.archives, archives); err != nil {
+		return err
+	}
+	archiveMap := stdCfg.archives
 	for _, arc := range archives {
 		archiveMap[arc.packagePath] = arc.filePath
 	}
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	if err := checkABIFiles(abi.abiFiles, archiveMap, graph); err != nil {
+		return err
+	}
+	importcfgPath, err := writeTempImportcfg(archiveMap, stdCfg.toolLines("link")...)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(importcfgPath)
 
-	// Invoke the linker.
-	return runLinker(mainPath, importcfgPath, outPath)
+	// Invoke the linker. If it reports undefined symbols, explain which
+	// packages they should have come from.
+	binOut, err := newAtomicOutput(outPath)
+	if err != nil {
+		return err
+	}
+	defer binOut.cleanup()
+	if out, err := runLinkerOutput(mainPath, importcfgPath, binOut.tmpPath(), defineArgs(defines)...); err != nil {
+		// Notes are written to the full log too, since the linker's output
+		// may have been truncated on stderr.
+		notes := &bytes.Buffer{}
+		for _, note := range explainUndefined(out, archiveMap, graph) {
+			fmt.Fprintln(notes, note)
+		}
+		io.MultiWriter(os.Stderr, events.stderrWriter()).Write(notes.Bytes())
+		if logErr := appendFullLog(notes.Bytes()); logErr != nil {
+			log.Print(logErr)
+		}
+		return err
+	}
+	if stamp {
+		info, err := newBuildInfo(importcfgPath)
+		if err != nil {
+			return err
+		}
+		if err := appendBuildInfo(binOut.tmpPath(), info); err != nil {
+			return err
+		}
+	}
+	if err := binOut.commit(); err != nil {
+		return err
+	}
+	if linkMapPath != "" {
+		if err := writeLinkMap(linkMapPath, outPath); err != nil {
+			return err
+		}
+	}
+	if expectPath != "" {
+		return compareBinaries(outPath, expectPath)
+	}
+	return nil
 }
 
-func runLinker(mainPath, importcfgPath string, outPath string) error {
-	args := []string{"tool", "link", "-importcfg", importcfgPath, "-o", outPath}
-	args = append(args, "--", mainPath)
-	goTool, err := findGoTool()
+// compareBinaries returns an error if the files at outPath and expectPath
+// differ. It's used when bootstrapping the builder: a builder built by the
+// bootstrap builder rebuilds itself, and the result must match, or the new
+// builder doesn't produce the same output as the one that built it.
+func compareBinaries(outPath, expectPath string) error {
+	out, err := ioutil.ReadFile(outPath)
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command(goTool, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	expect, err := ioutil.ReadFile(expectPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(out, expect) {
+		return nil
+	}
+	n := len(out)
+	if len(expect) < n {
+		n = len(expect)
+	}
+	i := 0
+	for i < n && out[i] == expect[i] {
+		i++
+	}
+	return fmt.Errorf("%s differs from %s at byte %d (sizes %d and %d); the binary does not reproduce itself", outPath, expectPath, i, len(out), len(expect))
+}
+
+// runLinker invokes the Go linker. extraArgs are passed to the linker before
+// the main archive.
+func runLinker(mainPath, importcfgPath string, outPath string, extraArgs ...string) error {
+	_, err := runLinkerOutput(mainPath, importcfgPath, outPath, extraArgs...)
+	return err
+}
+
+// runLinkerOutput is like runLinker, but it also returns the linker's output.
+func runLinkerOutput(mainPath, importcfgPath string, outPath string, extraArgs ...string) ([]byte, error) {
+	linker, err := tools.path("linker", tools.linker)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-importcfg", importcfgPath, "-o", outPath}
+	args = append(args, extraArgs...)
+	args = append(args, "--", mainPath)
+	return runToolOutput(exec.Command(linker, args...))
+}
+
+// defineFlag collects -define arguments, which set the initial values of
+// string variables in the linked binary.
+type defineFlag struct {
+	defines *[]string
+}
+
+func (f defineFlag) String() string {
+	if f.defines == nil {
+		return ""
+	}
+	return strings.Join(*f.defines, ",")
+}
+
+func (f defineFlag) Set(value string) error {
+	eq := strings.IndexByte(value, '=')
+	if eq < 0 {
+		return fmt.Errorf("malformed -define value; expected packagepath.name=value: %q", value)
+	}
+	name := value[:eq]
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.LastIndexByte(name, '.')
+	if dot <= slash+1 || dot == len(name)-1 {
+		return fmt.Errorf("malformed -define value; expected packagepath.name=value: %q", value)
+	}
+	*f.defines = append(*f.defines, value)
+	return nil
+}
+
+// defineArgs returns linker arguments that set variables named in
+// -define arguments.
+func defineArgs(defines []string) []string {
+	args := make([]string, 0, 2*len(defines))
+	for _, d := range defines {
+		args = append(args, "-X", d)
+	}
+	return args
 }
@@ -0,0 +1,150 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// linkMap describes the layout of a linked binary. link writes it with
+// -link-map, so tools that load the binary, like a host that runs guest
+// modules, can check their assumptions about it.
+type linkMap struct {
+	Format string `json:"format"`
+
+	// InitOrder lists the packages whose initialization runs code, in the
+	// order the runtime initializes them. It's read from the packages' init
+	// tasks, so it's null for WebAssembly, which has no data symbols.
+	InitOrder []string `json:"init_order"`
+
+	// Symbols lists the binary's symbols, sorted by address. WebAssembly
+	// modules only have function symbols.
+	Symbols []linkMapSymbol `json:"symbols"`
+}
+
+type linkMapSymbol struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Section string `json:"section"`
+	Addr    uint64 `json:"addr"`
+	Size    uint64 `json:"size"`
+}
+
+// initTaskRoots are the init tasks runtime.main runs, in order. Each
+// package with initialization work has an init task, named
+// packagepath..inittask, with this layout:
+//
+//	state uintptr // 0 = uninitialized, 1 = in progress, 2 = done
+//	ndeps uintptr
+//	nfns  uintptr
+//	deps  [ndeps]*initTask
+//	fns   [nfns]uintptr
+//
+// The runtime initializes a task's dependencies depth-first, in order,
+// before running its functions, and skips tasks that are already done.
+var initTaskRoots = []string{"runtime..inittask", "main..inittask"}
+
+// readInitOrder returns the packages whose init tasks have functions, in the
+// order the runtime runs them. read returns the words of a task symbol.
+func readInitOrder(syms []binarySymbol, read func(binarySymbol) ([]uint64, error)) ([]string, error) {
+	tasks := make(map[uint64]binarySymbol)
+	roots := make(map[string]binarySymbol)
+	for _, s := range syms {
+		if strings.HasSuffix(s.name, "..inittask") {
+			tasks[s.addr] = s
+			roots[s.name] = s
+		}
+	}
+
+	order := []string{}
+	done := make(map[uint64]bool)
+	var visit func(sym binarySymbol) error
+	visit = func(sym binarySymbol) error {
+		done[sym.addr] = true
+		words, err := read(sym)
+		if err != nil {
+			return err
+		}
+		if len(words) < 3 {
+			return fmt.Errorf("init task %s is too short", sym.name)
+		}
+		ndeps, nfns := words[1], words[2]
+		if uint64(len(words)-3) < ndeps {
+			return fmt.Errorf("init task %s has %d dependencies but only %d words", sym.name, ndeps, len(words)-3)
+		}
+		for _, addr := range words[3 : 3+ndeps] {
+			if done[addr] {
+				continue
+			}
+			dep, ok := tasks[addr]
+			if !ok {
+				return fmt.Errorf("init task %s depends on unknown task at %#x", sym.name, addr)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		if nfns > 0 {
+			order = append(order, symbolPackage(sym.name))
+		}
+		return nil
+	}
+	for _, name := range initTaskRoots {
+		if sym, ok := roots[name]; ok && !done[sym.addr] {
+			if err := visit(sym); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return order, nil
+}
+
+// writeLinkMap writes a linkMap for the binary at binPath as JSON to path.
+func writeLinkMap(path, binPath string) error {
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return err
+	}
+	defer bin.close()
+
+	m := linkMap{Format: bin.format, Symbols: []linkMapSymbol{}}
+	hasMainTask := false
+	for _, s := range bin.symbols {
+		m.Symbols = append(m.Symbols, linkMapSymbol{
+			Name:    s.name,
+			Package: symbolPackage(s.name),
+			Section: s.section,
+			Addr:    s.addr,
+			Size:    s.size,
+		})
+		hasMainTask = hasMainTask || s.name == initTaskRoots[1]
+	}
+	sort.SliceStable(m.Symbols, func(i, j int) bool {
+		if m.Symbols[i].Addr != m.Symbols[j].Addr {
+			return m.Symbols[i].Addr < m.Symbols[j].Addr
+		}
+		return m.Symbols[i].Name < m.Symbols[j].Name
+	})
+
+	if hasMainTask {
+		m.InitOrder, err = readInitOrder(bin.symbols, bin.readPointers)
+		if err != nil {
+			return fmt.Errorf("%s: %v", binPath, err)
+		}
+	} else if bin.format != "wasm" {
+		log.Printf("warning: %s has no %s symbol; package init order is not recorded in %s", binPath, initTaskRoots[1], path)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
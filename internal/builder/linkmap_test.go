@@ -0,0 +1,92 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestReadInitOrder(t *testing.T) {
+	// Init tasks laid out as Go 1.13 writes them for a program that imports
+	// fmt, with tasks listed in symbol table (address) order. Packages like
+	// internal/reflectlite have tasks only to reach their dependencies.
+	tasks := []struct {
+		pkg  string
+		deps []string
+		nfns int
+	}{
+		{"errors", []string{"internal/reflectlite"}, 1},
+		{"fmt", []string{"errors", "os", "reflect", "strconv", "sync"}, 1},
+		{"internal/bytealg", nil, 1},
+		{"internal/poll", []string{"errors", "io", "sync", "syscall"}, 1},
+		{"internal/reflectlite", []string{"runtime"}, 0},
+		{"io", []string{"errors", "sync"}, 1},
+		{"main", []string{"fmt"}, 0},
+		{"os", []string{"errors", "internal/poll", "io", "sync", "syscall"}, 1},
+		{"reflect", []string{"strconv", "sync", "unicode"}, 1},
+		{"runtime", []string{"internal/bytealg"}, 4},
+		{"strconv", []string{"errors"}, 1},
+		{"sync", []string{"runtime"}, 1},
+		{"syscall", []string{"sync"}, 1},
+		{"unicode", nil, 1},
+	}
+	addrs := make(map[string]uint64)
+	var syms []binarySymbol
+	for i, task := range tasks {
+		addr := uint64(0x55a000 + 0x40*i)
+		addrs[task.pkg] = addr
+		syms = append(syms, binarySymbol{name: task.pkg + "..inittask", addr: addr, size: 0x40, section: ".noptrdata"})
+	}
+	syms = append(syms, binarySymbol{name: "main.main", addr: 0x48f000, size: 0x80, section: ".text"})
+	words := make(map[uint64][]uint64)
+	for _, task := range tasks {
+		w := []uint64{0, uint64(len(task.deps)), uint64(task.nfns)}
+		for _, dep := range task.deps {
+			w = append(w, addrs[dep])
+		}
+		for i := 0; i < task.nfns; i++ {
+			w = append(w, 0x400000+uint64(i))
+		}
+		words[addrs[task.pkg]] = w
+	}
+	read := func(sym binarySymbol) ([]uint64, error) {
+		w, ok := words[sym.addr]
+		if !ok {
+			return nil, fmt.Errorf("no data for %s", sym.name)
+		}
+		return w, nil
+	}
+
+	got, err := readInitOrder(syms, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"internal/bytealg",
+		"runtime",
+		"errors",
+		"sync",
+		"io",
+		"syscall",
+		"internal/poll",
+		"os",
+		"strconv",
+		"unicode",
+		"reflect",
+		"fmt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// A dependency on a task that isn't in the symbol table is an error.
+	words[addrs["unicode"]] = []uint64{0, 1, 0, 0x1234}
+	if _, err := readInitOrder(syms, read); err == nil {
+		t.Error("got no error for a dependency on an unknown task")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathToPrefix converts a package path to the prefix the compiler uses for
+// symbols in that package. Characters that would make a symbol name
+// ambiguous are escaped as %xx: control characters, spaces, '%', '"',
+// non-ASCII bytes, and dots in the last path element. For example,
+// "gopkg.in/yaml.v2" becomes "gopkg.in/yaml%2ev2".
+//
+// This matches PathToPrefix in cmd/internal/objabi.
+func pathToPrefix(pkgPath string) string {
+	slash := strings.LastIndex(pkgPath, "/")
+	b := &strings.Builder{}
+	for i := 0; i < len(pkgPath); i++ {
+		c := pkgPath[i]
+		if c <= ' ' || (c == '.' && i > slash) || c == '%' || c == '"' || c >= 0x7F {
+			fmt.Fprintf(b, "%%%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// prefixToPath reverses pathToPrefix, converting a symbol prefix back to a
+// package path.
+func prefixToPath(prefix string) (string, error) {
+	b := &strings.Builder{}
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(prefix) {
+			return "", fmt.Errorf("malformed symbol prefix %q: incomplete escape", prefix)
+		}
+		n, err := strconv.ParseUint(prefix[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("malformed symbol prefix %q: invalid escape %q", prefix, prefix[i:i+3])
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// splitSymbol splits a symbol name into a package path and a name within
+// that package. Because dots in the last element of the package path are
+// escaped, the package prefix ends at the first dot after the last slash.
+// For example, "gopkg.in/yaml%2ev2.(*Decoder).Decode" is split into
+// "gopkg.in/yaml.v2" and "(*Decoder).Decode". Symbols without a package
+// prefix, like "runtime.text" or "go.buildid", are split at the first dot.
+func splitSymbol(sym string) (pkgPath, name string, err error) {
+	// Look for a slash before any parenthesis or bracket, so slashes in
+	// receiver or type parameter names aren't mistaken for part of the path.
+	end := strings.IndexAny(sym, "([")
+	if end < 0 {
+		end = len(sym)
+	}
+	slash := strings.LastIndex(sym[:end], "/")
+	dot := strings.IndexByte(sym[slash+1:], '.')
+	if dot < 0 {
+		return "", sym, nil
+	}
+	dot += slash + 1
+	pkgPath, err = prefixToPath(sym[:dot])
+	if err != nil {
+		return "", "", err
+	}
+	return pkgPath, sym[dot+1:], nil
+}
+
+// escapedSymbolRe matches words that contain escape sequences written by
+// pathToPrefix. Words are delimited by white space, so a match may include
+// punctuation around a symbol; splitSymbol leaves that intact.
+var escapedSymbolRe = regexp.MustCompile(`[^\s]*%[0-9a-fA-F]{2}[^\s]*`)
+
+// demangleText replaces escaped symbol prefixes in arbitrary text, such as
+// output from nm, objdump, or the linker, with the package paths they
+// encode. Text that isn't a symbol is left as is.
+func demangleText(text string) string {
+	return escapedSymbolRe.ReplaceAllStringFunc(text, func(word string) string {
+		pkgPath, name, err := splitSymbol(word)
+		if err != nil || pkgPath == "" {
+			return word
+		}
+		return pkgPath + "." + name
+	})
+}
+
+// demangle prints the package paths of symbol names. Symbols are read from
+// the command line or, if there are none, from stdin, one per line. With
+// -stream, demangle instead copies stdin to stdout, replacing symbols
+// wherever they appear, so other tools' output can be piped through it.
+func demangle(args []string) error {
+	// Process command line arguments.
+	var split, stream bool
+	fs := flag.NewFlagSet("demangle", flag.ExitOnError)
+	fs.BoolVar(&split, "split", false, "print the package path and name of each symbol separated by a tab")
+	fs.BoolVar(&stream, "stream", false, "copy stdin to stdout, demangling symbols anywhere in the text")
+	fs.Parse(args)
+
+	if stream {
+		if fs.NArg() > 0 || split {
+			return errors.New("-stream may not be used with -split or symbol arguments")
+		}
+		sc := bufio.NewScanner(os.Stdin)
+		sc.Buffer(nil, 1024*1024)
+		w := bufio.NewWriter(os.Stdout)
+		for sc.Scan() {
+			fmt.Fprintln(w, demangleText(sc.Text()))
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	printSymbol := func(sym string) error {
+		pkgPath, name, err := splitSymbol(sym)
+		if err != nil {
+			return err
+		}
+		switch {
+		case split:
+			fmt.Printf("%s\t%s\n", pkgPath, name)
+		case pkgPath == "":
+			fmt.Println(name)
+		default:
+			fmt.Printf("%s.%s\n", pkgPath, name)
+		}
+		return nil
+	}
+
+	if fs.NArg() > 0 {
+		for _, sym := range fs.Args() {
+			if err := printSymbol(sym); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		if sym := strings.TrimSpace(sc.Text()); sym != "" {
+			if err := printSymbol(sym); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
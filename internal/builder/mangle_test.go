@@ -0,0 +1,78 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import "testing"
+
+func TestPathToPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		pkgPath, prefix string
+	}{
+		{"fmt", "fmt"},
+		{"net/http", "net/http"},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml%2ev2"},
+		{"example.com/a b", "example.com/a%20b"},
+		{"example.com/100%", "example.com/100%25"},
+		{`example.com/"q"`, "example.com/%22q%22"},
+		{"example.com/é", "example.com/%c3%a9"},
+	} {
+		if got := pathToPrefix(tc.pkgPath); got != tc.prefix {
+			t.Errorf("pathToPrefix(%q): got %q; want %q", tc.pkgPath, got, tc.prefix)
+		}
+		if got, err := prefixToPath(tc.prefix); err != nil {
+			t.Errorf("prefixToPath(%q): %v", tc.prefix, err)
+		} else if got != tc.pkgPath {
+			t.Errorf("prefixToPath(%q): got %q; want %q", tc.prefix, got, tc.pkgPath)
+		}
+	}
+}
+
+func TestPrefixToPathErrors(t *testing.T) {
+	for _, prefix := range []string{"a%2", "a%zz"} {
+		if _, err := prefixToPath(prefix); err == nil {
+			t.Errorf("prefixToPath(%q): got nil error; want error", prefix)
+		}
+	}
+}
+
+func TestSplitSymbol(t *testing.T) {
+	for _, tc := range []struct {
+		sym, pkgPath, name string
+	}{
+		{"fmt.Println", "fmt", "Println"},
+		{"net/http.(*Server).Serve", "net/http", "(*Server).Serve"},
+		{"gopkg.in/yaml%2ev2.Unmarshal", "gopkg.in/yaml.v2", "Unmarshal"},
+		{"example.com/a.T.M", "example.com/a", "T.M"},
+		{"main.main", "main", "main"},
+		{"_rt0_amd64_linux", "", "_rt0_amd64_linux"},
+	} {
+		pkgPath, name, err := splitSymbol(tc.sym)
+		if err != nil {
+			t.Errorf("splitSymbol(%q): %v", tc.sym, err)
+			continue
+		}
+		if pkgPath != tc.pkgPath || name != tc.name {
+			t.Errorf("splitSymbol(%q): got %q, %q; want %q, %q", tc.sym, pkgPath, name, tc.pkgPath, tc.name)
+		}
+	}
+}
+
+func TestDemangleText(t *testing.T) {
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"no symbols here", "no symbols here"},
+		{"  TEXT gopkg.in/yaml%2ev2.(*Decoder).Decode(SB) decode.go", "  TEXT gopkg.in/yaml.v2.(*Decoder).Decode(SB) decode.go"},
+		{"4a0f20 T example.com/a%20b.F", "4a0f20 T example.com/a b.F"},
+		{`relocation target "example.com/100%25.G" not defined`, `relocation target "example.com/100%.G" not defined`},
+		{"usage: 100%25", "usage: 100%25"},
+		{"bad escape a%zz.F", "bad escape a%zz.F"},
+	} {
+		if got := demangleText(tc.text); got != tc.want {
+			t.Errorf("demangleText(%q): got %q; want %q", tc.text, got, tc.want)
+		}
+	}
+}
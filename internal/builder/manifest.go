@@ -0,0 +1,89 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+)
+
+// stdManifest lists the archives in a Go distribution's standard library.
+// It lets stdimportcfg produce an importcfg without scanning the distribution,
+// for example, when the distribution is not available until execution.
+// Paths are slash-separated and relative to GOROOT, so a manifest may be
+// generated once per distribution and checked in.
+type stdManifest struct {
+	GOOS     string            `json:"goos"`
+	GOARCH   string            `json:"goarch"`
+	Packages map[string]string `json:"packages"`
+}
+
+// stdManifestCmd produces a manifest of standard library archives for the
+// Go distribution in GOROOT.
+func stdManifestCmd(args []string) error {
+	// Process command line arguments.
+	var outPath string
+	fs := flag.NewFlagSet("stdmanifest", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "path to the JSON manifest to write")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+
+	goroot, err := getenv("GOROOT")
+	if err != nil {
+		return err
+	}
+	archiveMap, err := walkStdArchives(goroot)
+	if err != nil {
+		return err
+	}
+
+	m := stdManifest{
+		GOOS:     runtime.GOOS,
+		GOARCH:   runtime.GOARCH,
+		Packages: make(map[string]string),
+	}
+	for pkgPath, archivePath := range archiveMap {
+		rel, err := filepath.Rel(goroot, archivePath)
+		if err != nil {
+			return err
+		}
+		m.Packages[pkgPath] = filepath.ToSlash(rel)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outPath, append(data, '\n'))
+}
+
+// readStdManifest reads a manifest written by stdManifestCmd. It returns a map
+// from package paths to archive file paths in goroot.
+func readStdManifest(manifestPath, goroot string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var m stdManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestPath, err)
+	}
+	if m.GOOS != runtime.GOOS || m.GOARCH != runtime.GOARCH {
+		return nil, fmt.Errorf("%s: manifest is for %s/%s, but the builder targets %s/%s", manifestPath, m.GOOS, m.GOARCH, runtime.GOOS, runtime.GOARCH)
+	}
+	archiveMap := make(map[string]string)
+	for pkgPath, rel := range m.Packages {
+		archiveMap[pkgPath] = filepath.Join(goroot, filepath.FromSlash(rel))
+	}
+	return archiveMap, nil
+}
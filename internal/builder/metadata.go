@@ -0,0 +1,97 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// packageMetadata describes a compiled package. compile writes it with
+// -metadata so IDE aspects and dependency analysis tools can learn what
+// was actually compiled without running the builder again.
+type packageMetadata struct {
+	ImportPath string `json:"importpath"`
+	Label      string `json:"label,omitempty"`
+
+	// Srcs are the Go and assembly sources that matched build constraints.
+	// Excluded are the sources that didn't.
+	Srcs     []string `json:"srcs"`
+	Excluded []string `json:"excluded,omitempty"`
+
+	// Deps are the import paths of direct dependencies passed with -arc.
+	// Imports are the packages the filtered sources actually import.
+	Deps    []string `json:"deps"`
+	Imports []string `json:"imports"`
+
+	// Flags describes the target platform the sources were filtered and
+	// compiled for.
+	Flags packageMetadataFlags `json:"flags"`
+
+	// Outputs maps each output file to its hex-encoded SHA-256 digest.
+	Outputs map[string]string `json:"outputs"`
+
+	// Unchanged reports whether the archive has the digest given with
+	// -previous-digest. It's omitted if -previous-digest wasn't set.
+	Unchanged *bool `json:"unchanged,omitempty"`
+}
+
+type packageMetadataFlags struct {
+	GOOS    string `json:"goos"`
+	GOARCH  string `json:"goarch"`
+	Variant string `json:"variant,omitempty"`
+	Cgo     bool   `json:"cgo"`
+
+	GOEXPERIMENT string `json:"goexperiment,omitempty"`
+}
+
+// writeMetadata computes digests of outputPaths and writes metadata as JSON
+// to path. Empty output paths are ignored. The first output path is the
+// archive, which is compared with -previous-digest.
+func writeMetadata(path string, md packageMetadata, outputPaths ...string) error {
+	for i, src := range md.Srcs {
+		md.Srcs[i] = mapSourcePath(src)
+	}
+	for i, src := range md.Excluded {
+		md.Excluded[i] = mapSourcePath(src)
+	}
+	sort.Strings(md.Deps)
+	sort.Strings(md.Imports)
+	md.Flags = packageMetadataFlags{
+		GOOS:    target.goos,
+		GOARCH:  target.goarch,
+		Variant: target.variant,
+		Cgo:     target.cgo,
+
+		GOEXPERIMENT: target.experiments,
+	}
+	md.Outputs = make(map[string]string)
+	for _, out := range outputPaths {
+		if out == "" {
+			continue
+		}
+		digest, err := fileDigest(out)
+		if err != nil {
+			return err
+		}
+		md.Outputs[out] = digest
+	}
+	if len(outputPaths) > 0 {
+		if unchanged, ok := outputUnchanged(outputPaths[0]); ok {
+			md.Unchanged = &unchanged
+		}
+	}
+	data, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// optOptions controls how much the compiler optimizes. It's set by flags
+// registered with addOptFlags.
+var optOptions struct {
+	level    string
+	noInline bool
+}
+
+// addOptFlags registers flags that control compiler optimization.
+func addOptFlags(fs *flag.FlagSet) {
+	optOptions.level = "2"
+	fs.Var(optLevelFlag{}, "O", "optimization level: 0 (no optimization or inlining, for debugging), 1 (no inlining), 2 (the compiler's default), or s (optimize for size)")
+	fs.BoolVar(&optOptions.noInline, "no-inline", false, "disable inlining at any optimization level")
+}
+
+type optLevelFlag struct{}
+
+func (optLevelFlag) String() string { return optOptions.level }
+
+func (optLevelFlag) Set(value string) error {
+	switch value {
+	case "0", "1", "2", "s":
+		optOptions.level = value
+		return nil
+	default:
+		return fmt.Errorf("unknown optimization level %q; expected 0, 1, 2, or s", value)
+	}
+}
+
+// optArgs returns compiler flags for the selected optimization options.
+// The gc compiler only has switches to disable optimization (-N) and
+// inlining (-l), so levels map onto those. It has no size-specific
+// optimizations; inlining is what grows code the most, so s disables it.
+// -l is never passed twice, since the compiler treats a repeated -l as a
+// request for more aggressive inlining.
+func optArgs() []string {
+	var args []string
+	if optOptions.level == "0" {
+		args = append(args, "-N")
+	}
+	if optOptions.level != "2" || optOptions.noInline {
+		args = append(args, "-l")
+	}
+	return args
+}
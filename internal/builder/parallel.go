@@ -0,0 +1,44 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// maxFileWorkers limits the number of files read at once by actions that
+// process many files concurrently, so they don't open too many of them.
+const maxFileWorkers = 8
+
+// parallelFor calls f(i) for each i in [0, n) on a pool of at most
+// maxFileWorkers goroutines, and returns when all calls have finished. Calls
+// may happen in any order, so f should store results by index.
+func parallelFor(n int, f func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > maxFileWorkers {
+		workers = maxFileWorkers
+	}
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
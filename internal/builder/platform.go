@@ -0,0 +1,173 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"runtime"
+	"strings"
+)
+
+// targetPlatform describes the platform sources are built for. It selects
+// which Go and assembly files match build constraints, and it's passed
+// to the compiler, assembler, and linker through the environment.
+type targetPlatform struct {
+	goos, goarch string
+
+	// variant selects a processor variant for architectures that have them,
+	// for example, "7" for GOARM or "softfloat" for GOMIPS.
+	variant string
+
+	// cgo sets the "cgo" build constraint. When it's false, Go files that
+	// import "C" are excluded, like the go command with CGO_ENABLED=0. It's
+	// on by default wherever the go command would enable it.
+	cgo bool
+
+	// experiments is a comma-separated list of toolchain experiments, passed
+	// to tools as GOEXPERIMENT. Each enabled experiment also sets a
+	// goexperiment.name build constraint.
+	experiments string
+}
+
+// target is set by flags registered with addPlatformFlags. By default,
+// sources are built for the platform the builder runs on, with cgo enabled
+// if build.Default enables it.
+var target = targetPlatform{goos: runtime.GOOS, goarch: runtime.GOARCH, cgo: build.Default.CgoEnabled}
+
+// addPlatformFlags registers flags that select the target platform.
+func addPlatformFlags(fs *flag.FlagSet) {
+	fs.StringVar(&target.goos, "goos", target.goos, "target operating system; must match the standard library's")
+	fs.StringVar(&target.goarch, "goarch", target.goarch, "target architecture; must match the standard library's")
+	fs.StringVar(&target.variant, "goarch-variant", "", "target processor variant, used as GOARM, GO386, GOMIPS, or GOMIPS64 depending on -goarch")
+	fs.Var(cgoFlag{}, "cgo", "whether cgo is enabled, on or off; sets the cgo build constraint (default: on where the go command enables it)")
+	fs.Var(goexperimentFlag{}, "goexperiment", "comma-separated list of toolchain experiments, like fieldtrack or noframepointer; passed to tools as GOEXPERIMENT")
+}
+
+type cgoFlag struct{}
+
+func (cgoFlag) String() string {
+	if target.cgo {
+		return "on"
+	}
+	return "off"
+}
+
+func (cgoFlag) Set(value string) error {
+	switch value {
+	case "on":
+		target.cgo = true
+	case "off":
+		target.cgo = false
+	default:
+		return fmt.Errorf("-cgo must be on or off; got %q", value)
+	}
+	return nil
+}
+
+type goexperimentFlag struct{}
+
+func (goexperimentFlag) String() string { return target.experiments }
+
+func (goexperimentFlag) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_')
+		}) >= 0 {
+			return fmt.Errorf("-goexperiment must be a comma-separated list of experiment names; got %q", value)
+		}
+	}
+	target.experiments = value
+	return nil
+}
+
+// enabledExperiments returns the names of experiments that are turned on.
+// Names starting with "no" turn experiments off, and "none" turns off the
+// toolchain's default experiments.
+func (t targetPlatform) enabledExperiments() []string {
+	if t.experiments == "" {
+		return nil
+	}
+	var enabled []string
+	for _, name := range strings.Split(t.experiments, ",") {
+		if name != "none" && !strings.HasPrefix(name, "no") {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
+
+// checkStdTarget returns an error if the standard library importcfg at path
+// has archives for a platform other than the target. The builder can't
+// build the standard library, and stdimportcfg only finds the archives
+// distributed for the host, so -goos and -goarch can only select the host
+// platform until a standard library for the target is provided.
+func checkStdTarget(cfg importcfgFile, path string) error {
+	stdTarget := cfg.target
+	if stdTarget == "" {
+		stdTarget = runtime.GOOS + "/" + runtime.GOARCH
+	}
+	if want := target.goos + "/" + target.goarch; want != stdTarget {
+		return fmt.Errorf("-goos and -goarch select %s, but the standard library in %s was built for %s; building for another platform needs a standard library built for it", want, path, stdTarget)
+	}
+	return nil
+}
+
+// buildContext returns a context for evaluating build constraints.
+func (t targetPlatform) buildContext() *build.Context {
+	bctx := build.Default
+	bctx.GOOS = t.goos
+	bctx.GOARCH = t.goarch
+	bctx.CgoEnabled = t.cgo
+	bctx.BuildTags = append([]string(nil), bctx.BuildTags...)
+	for _, name := range t.enabledExperiments() {
+		bctx.BuildTags = append(bctx.BuildTags, "goexperiment."+strings.ToLower(name))
+	}
+	return &bctx
+}
+
+// variantEnvKey returns the environment variable that selects a processor
+// variant for the target architecture, or "" if there is none.
+func (t targetPlatform) variantEnvKey() string {
+	switch t.goarch {
+	case "arm":
+		return "GOARM"
+	case "386":
+		return "GO386"
+	case "mips", "mipsle":
+		return "GOMIPS"
+	case "mips64", "mips64le":
+		return "GOMIPS64"
+	default:
+		return ""
+	}
+}
+
+// env returns environment variables that tell tools which platform to
+// target. GOEXPERIMENT is always set, so experiments can't leak in from the
+// builder's environment.
+func (t targetPlatform) env() []string {
+	cgoEnabled := "0"
+	if t.cgo {
+		cgoEnabled = "1"
+	}
+	env := []string{"GOOS=" + t.goos, "GOARCH=" + t.goarch, "CGO_ENABLED=" + cgoEnabled, "GOEXPERIMENT=" + t.experiments}
+	if key := t.variantEnvKey(); key != "" && t.variant != "" {
+		env = append(env, key+"="+t.variant)
+	}
+	return env
+}
+
+// asmDefines returns preprocessor flags that assembly sources may use to
+// check the target platform.
+func (t targetPlatform) asmDefines() []string {
+	defines := []string{"-D", "GOOS_" + t.goos, "-D", "GOARCH_" + t.goarch}
+	if key := t.variantEnvKey(); (key == "GOMIPS" || key == "GOMIPS64") && t.variant != "" {
+		defines = append(defines, "-D", key+"_"+t.variant)
+	}
+	return defines
+}
@@ -0,0 +1,106 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultPlatformMatrix is the list of platforms platforms checks when no
+// -platform flags are given.
+var defaultPlatformMatrix = []string{"linux/amd64", "linux/arm64", "js/wasm"}
+
+// platformsCmd reports which platforms in a matrix each source file builds
+// on. It's used to check that a package is portable before depending on it
+// from code built for another platform. Each platform is evaluated like
+// -goos and -goarch, with the cgo and -goexperiment settings from the
+// command line.
+//
+// With -portable, platformsCmd fails if no Go source matches on some
+// platform, since the package can't be built there.
+func platformsCmd(args []string) error {
+	var platformArgs []string
+	var portable bool
+	fs := flag.NewFlagSet("platforms", flag.ExitOnError)
+	fs.Var(stringListFlag{&platformArgs}, "platform", "platform to check, formatted as goos/goarch (may be repeated; default "+strings.Join(defaultPlatformMatrix, ", ")+")")
+	fs.BoolVar(&portable, "portable", false, "fail if no Go source matches on some platform")
+	addPlatformFlags(fs)
+	fs.Parse(args)
+	if len(platformArgs) == 0 {
+		platformArgs = defaultPlatformMatrix
+	}
+	platforms := make([]targetPlatform, len(platformArgs))
+	for i, arg := range platformArgs {
+		slash := strings.Index(arg, "/")
+		if slash <= 0 || slash == len(arg)-1 || strings.Count(arg, "/") != 1 {
+			return fmt.Errorf("-platform must be formatted as goos/goarch; got %q", arg)
+		}
+		platforms[i] = target
+		platforms[i].goos, platforms[i].goarch = arg[:slash], arg[slash+1:]
+		platforms[i].variant = ""
+	}
+
+	srcPaths, err := expandSourceDirs(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(srcPaths) == 0 {
+		return fmt.Errorf("no source files")
+	}
+
+	// Evaluate each file on each platform.
+	goMatches := make([]int, len(platforms))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "FILE\t%s\n", strings.Join(platformArgs, "\t"))
+	for _, path := range srcPaths {
+		cols := make([]string, len(platforms))
+		for i, p := range platforms {
+			bctx := p.buildContext()
+			var match bool
+			if strings.HasSuffix(path, ".go") {
+				src, err := loadSourceInfo(bctx, path)
+				if err != nil {
+					return err
+				}
+				match = src.match
+				if match {
+					goMatches[i]++
+				}
+			} else if match, err = bctx.MatchFile(filepath.Dir(path), filepath.Base(path)); err != nil {
+				return err
+			}
+			if match {
+				cols[i] = "yes"
+			} else {
+				cols[i] = "no"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\n", path, strings.Join(cols, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var unbuildable []string
+	for i, n := range goMatches {
+		if n == 0 {
+			unbuildable = append(unbuildable, platformArgs[i])
+		}
+	}
+	if len(unbuildable) == 0 {
+		return nil
+	}
+	if portable {
+		return fmt.Errorf("no Go sources match on %s", strings.Join(unbuildable, ", "))
+	}
+	fmt.Fprintf(os.Stderr, "note: no Go sources match on %s\n", strings.Join(unbuildable, ", "))
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progressLog prints a line when each step of a multi-step action starts,
+// like "[2/4] +0.81s assembling 3 files", so someone watching a build with
+// bazel build -s can tell which phase is slow. Bazel prints an action's
+// output when it finishes, so each line shows the time since the action
+// started rather than relying on when the line appears. Nothing is printed
+// unless the -progress flag is set.
+type progressLog struct {
+	enabled     bool
+	start       time.Time
+	step, total int
+}
+
+// progress is the progress log for this process.
+var progress = progressLog{start: time.Now()}
+
+// plan adds n steps to the number the action expects to run.
+func (p *progressLog) plan(n int) {
+	p.total += n
+}
+
+// stepf reports that the next step is starting.
+func (p *progressLog) stepf(format string, args ...interface{}) {
+	p.step++
+	if !p.enabled {
+		return
+	}
+	elapsed := time.Since(p.start).Seconds()
+	fmt.Fprintf(os.Stderr, "[%d/%d] +%.2fs %s\n", p.step, p.total, elapsed, fmt.Sprintf(format, args...))
+}
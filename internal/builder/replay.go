@@ -0,0 +1,180 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// replayFilePath is set by the -replay-file flag. If the action fails, a
+// replay record is written there.
+var replayFilePath string
+
+// replayRecord describes an action in enough detail to run it again outside
+// Bazel: the builder's arguments, its environment, its working directory,
+// and digests of the files named in its arguments. Only declared variables
+// of the environment are recorded (see declaredEnv), so the record doesn't
+// capture credentials or other variables unrelated to the action.
+type replayRecord struct {
+	Args   []string          `json:"args"`
+	Env    []string          `json:"env"`
+	Dir    string            `json:"dir"`
+	Inputs map[string]string `json:"inputs"`
+	Error  string            `json:"error"`
+}
+
+// writeReplayFile writes a replay record for the current action, which
+// failed with actionErr. Inputs are the existing regular files named by
+// arguments or flag values, except for files the action was supposed to
+// produce.
+func writeReplayFile(path string, actionErr error) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	isOutput := make(map[string]bool)
+	for _, out := range events.outputs {
+		isOutput[out] = true
+	}
+	inputs := make(map[string]string)
+	for _, arg := range os.Args[2:] {
+		for _, candidate := range replayCandidatePaths(arg) {
+			if isOutput[candidate] || inputs[candidate] != "" {
+				continue
+			}
+			if digest, err := fileDigest(candidate); err == nil {
+				inputs[candidate] = digest
+			}
+		}
+	}
+	// Don't record -replay-file, so replaying doesn't overwrite the record.
+	var args []string
+	for i := 1; i < len(os.Args); i++ {
+		arg := strings.TrimPrefix(os.Args[i], "-")
+		if arg == "-replay-file" || arg == "replay-file" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "-replay-file=") || strings.HasPrefix(arg, "replay-file=") {
+			continue
+		}
+		args = append(args, os.Args[i])
+	}
+	record := replayRecord{
+		Args:   args,
+		Env:    declaredEnv(),
+		Dir:    dir,
+		Inputs: inputs,
+		Error:  actionErr.Error(),
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// replayCandidatePaths returns strings within an argument that may name
+// input files: the argument itself, and for arguments like "-flag=value"
+// or "pkg=file", the text after each '='.
+func replayCandidatePaths(arg string) []string {
+	candidates := []string{arg}
+	for i := strings.IndexByte(arg, '='); i >= 0; i = strings.IndexByte(arg, '=') {
+		arg = arg[i+1:]
+		candidates = append(candidates, strings.TrimSuffix(arg, ";optional"))
+	}
+	return candidates
+}
+
+// fileDigest returns the hex-encoded SHA-256 digest of a regular file.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return "", err
+	} else if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replay runs an action recorded in a replay file with this builder. Before
+// running, it checks that input files still have the recorded digests and
+// warns about any that changed.
+func replay(args []string) error {
+	// Process command line arguments.
+	var dir string
+	var keepEnv bool
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "", "directory to run the action in (default: the recorded directory if it exists, otherwise the current directory)")
+	fs.BoolVar(&keepEnv, "keep-env", false, "run with the current environment instead of the recorded one")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [-dir dir] [-keep-env] file")
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var record replayRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	if len(record.Args) == 0 {
+		return fmt.Errorf("%s: no arguments recorded", fs.Arg(0))
+	}
+	if dir == "" {
+		if fi, err := os.Stat(record.Dir); err == nil && fi.IsDir() {
+			dir = record.Dir
+		}
+	}
+
+	for path, want := range record.Inputs {
+		p := path
+		if dir != "" && !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if got, err := fileDigest(p); err != nil {
+			log.Printf("warning: input %s: %v", path, err)
+		} else if got != want {
+			log.Printf("warning: input %s has changed since the action was recorded", path)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, record.Args...)
+	cmd.Dir = dir
+	if !keepEnv {
+		cmd.Env = record.Env
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,131 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sandboxOptions controls strict sandbox mode. In strict mode, the builder
+// fails instead of doing things that work locally but may break with remote
+// execution: accessing absolute paths outside the execroot, reading
+// undeclared environment variables, or writing temporary files outside
+// TMPDIR.
+type sandboxOptions struct {
+	strict     bool
+	allowedEnv map[string]bool
+}
+
+// sandbox is set by flags registered with addSandboxFlags.
+var sandbox = sandboxOptions{
+	allowedEnv: map[string]bool{"GOROOT": true, "TMPDIR": true},
+}
+
+// addSandboxFlags registers flags that control strict sandbox mode.
+func addSandboxFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&sandbox.strict, "strict-sandbox", false, "fail if the action accesses paths outside the execroot, reads undeclared environment variables, or writes temporary files outside TMPDIR")
+	fs.Var(allowEnvFlag{}, "allow-env", "environment variable the action may read in strict sandbox mode, in addition to GOROOT and TMPDIR (may be repeated)")
+}
+
+type allowEnvFlag struct{}
+
+func (allowEnvFlag) String() string {
+	keys := make([]string, 0, len(sandbox.allowedEnv))
+	for key := range sandbox.allowedEnv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (allowEnvFlag) Set(key string) error {
+	sandbox.allowedEnv[key] = true
+	return nil
+}
+
+// getenv returns the value of an environment variable. In strict mode, it's
+// an error to read a variable that was not declared.
+func getenv(key string) (string, error) {
+	if sandbox.strict && !sandbox.allowedEnv[key] {
+		return "", fmt.Errorf("strict sandbox: read of undeclared environment variable %s", key)
+	}
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%s not set", key)
+	}
+	return value, nil
+}
+
+// lookupEnv is like getenv, but it returns "" without an error if the
+// variable is not set. It's used for variables with defaults.
+func lookupEnv(key string) (string, error) {
+	if sandbox.strict && !sandbox.allowedEnv[key] {
+		return "", fmt.Errorf("strict sandbox: read of undeclared environment variable %s", key)
+	}
+	return os.Getenv(key), nil
+}
+
+// toolEnv returns the environment for tools run by the builder. Normally,
+// tools inherit the builder's environment. In strict mode, tools only see
+// declared variables. In either case, variables selecting the target
+// platform are set explicitly.
+func toolEnv() []string {
+	var env []string
+	if !sandbox.strict {
+		env = os.Environ()
+	} else {
+		env = declaredEnv()
+	}
+	return append(env, target.env()...)
+}
+
+// declaredEnv returns the variables in the builder's environment that
+// actions may read in strict mode: GOROOT, TMPDIR, and those named with
+// -allow-env.
+func declaredEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i >= 0 && sandbox.allowedEnv[kv[:i]] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// checkSandbox verifies that paths the action will read or write are inside
+// the execroot (the current directory) and that temporary files will be
+// written inside TMPDIR or -tmpdir. It does nothing unless strict mode is enabled.
+func checkSandbox(paths ...string) error {
+	if !sandbox.strict {
+		return nil
+	}
+	if _, ok := os.LookupEnv("TMPDIR"); !ok && tmpDir == "" {
+		return fmt.Errorf("strict sandbox: TMPDIR is not set, so temporary files would be written to %s", os.TempDir())
+	}
+	execroot, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		absPath := path
+		if !filepath.IsAbs(absPath) {
+			absPath = filepath.Join(execroot, path)
+		}
+		rel, err := filepath.Rel(execroot, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("strict sandbox: %s is outside the execroot %s", path, execroot)
+		}
+	}
+	return nil
+}
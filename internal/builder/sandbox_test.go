@@ -0,0 +1,174 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setSandbox sets strict sandbox options for a test and returns a function
+// that restores the previous options.
+func setSandbox(strict bool, allowEnv ...string) func() {
+	old := sandbox
+	sandbox = sandboxOptions{
+		strict:     strict,
+		allowedEnv: map[string]bool{"GOROOT": true, "TMPDIR": true},
+	}
+	for _, key := range allowEnv {
+		sandbox.allowedEnv[key] = true
+	}
+	return func() { sandbox = old }
+}
+
+// setEnv sets an environment variable for a test and returns a function
+// that restores it.
+func setEnv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestGetenv(t *testing.T) {
+	defer setEnv("SANDBOX_TEST_DECLARED", "declared")()
+	defer setEnv("SANDBOX_TEST_SECRET", "secret")()
+
+	for _, tc := range []struct {
+		desc, key string
+		strict    bool
+		want      string
+		wantErr   string
+	}{
+		{desc: "lax", key: "SANDBOX_TEST_SECRET", want: "secret"},
+		{desc: "lax_unset", key: "SANDBOX_TEST_UNSET", wantErr: "SANDBOX_TEST_UNSET not set"},
+		{desc: "strict_declared", key: "SANDBOX_TEST_DECLARED", strict: true, want: "declared"},
+		{desc: "strict_undeclared", key: "SANDBOX_TEST_SECRET", strict: true, wantErr: "undeclared environment variable SANDBOX_TEST_SECRET"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setSandbox(tc.strict, "SANDBOX_TEST_DECLARED")()
+			got, err := getenv(tc.key)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestToolEnv(t *testing.T) {
+	defer setEnv("SANDBOX_TEST_DECLARED", "declared")()
+	defer setEnv("SANDBOX_TEST_SECRET", "secret")()
+	has := func(env []string, kv string) bool {
+		for _, e := range env {
+			if e == kv {
+				return true
+			}
+		}
+		return false
+	}
+
+	restore := setSandbox(false, "SANDBOX_TEST_DECLARED")
+	env := toolEnv()
+	restore()
+	if !has(env, "SANDBOX_TEST_SECRET=secret") {
+		t.Error("lax: tool environment does not include the builder's environment")
+	}
+
+	defer setSandbox(true, "SANDBOX_TEST_DECLARED")()
+	env = toolEnv()
+	if has(env, "SANDBOX_TEST_SECRET=secret") {
+		t.Error("strict: tool environment includes an undeclared variable")
+	}
+	if !has(env, "SANDBOX_TEST_DECLARED=declared") {
+		t.Error("strict: tool environment does not include a declared variable")
+	}
+	if !has(env, "GOOS="+target.goos) {
+		t.Error("strict: tool environment does not set GOOS")
+	}
+}
+
+func TestCheckSandbox(t *testing.T) {
+	defer setEnv("TMPDIR", os.TempDir())()
+	execroot, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		desc    string
+		strict  bool
+		paths   []string
+		wantErr string
+	}{
+		{desc: "lax", paths: []string{"/etc/passwd", "../x"}},
+		{desc: "relative", strict: true, paths: []string{"a/b.go", "bazel-out/k8/bin/a.a", ""}},
+		{desc: "absolute_inside", strict: true, paths: []string{filepath.Join(execroot, "a.go")}},
+		{desc: "parent", strict: true, paths: []string{"a.go", "../x/a.go"}, wantErr: "../x/a.go is outside the execroot"},
+		{desc: "absolute_outside", strict: true, paths: []string{"/usr/lib/go/pkg/fmt.a"}, wantErr: "/usr/lib/go/pkg/fmt.a is outside the execroot"},
+		{desc: "dotdot_prefix", strict: true, paths: []string{"..a/b.go"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setSandbox(tc.strict)()
+			err := checkSandbox(tc.paths...)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("no_tmpdir", func(t *testing.T) {
+		defer setSandbox(true)()
+		defer func(old string) { tmpDir = old }(tmpDir)
+		tmpDir = ""
+		old := os.Getenv("TMPDIR")
+		os.Unsetenv("TMPDIR")
+		defer os.Setenv("TMPDIR", old)
+		if err := checkSandbox("a.go"); err == nil || !strings.Contains(err.Error(), "TMPDIR is not set") {
+			t.Fatalf("got error %v; want error about TMPDIR", err)
+		}
+	})
+}
+
+func TestLookupEnv(t *testing.T) {
+	defer setEnv("SANDBOX_TEST_SECRET", "secret")()
+	os.Unsetenv("SANDBOX_TEST_UNSET")
+
+	restore := setSandbox(false)
+	got, err := lookupEnv("SANDBOX_TEST_UNSET")
+	restore()
+	if err != nil || got != "" {
+		t.Errorf("lax, unset: got %q, %v; want \"\", nil", got, err)
+	}
+
+	defer setSandbox(true, "SANDBOX_TEST_UNSET")()
+	if got, err := lookupEnv("SANDBOX_TEST_UNSET"); err != nil || got != "" {
+		t.Errorf("strict, declared and unset: got %q, %v; want \"\", nil", got, err)
+	}
+	if _, err := lookupEnv("SANDBOX_TEST_SECRET"); err == nil {
+		t.Error("strict, undeclared: unexpected success")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sizeDiff compares the sizes of two linked binaries, section by section
+// and package by package, to track down size regressions. It's meant for
+// CI: with -max-growth, it fails if the new binary's loaded size grew by
+// more than a limit.
+//
+//	sizediff [-top n] [-max-growth bytes] old new
+//
+// Package sizes are the sums of the sizes of the packages' symbols, read
+// from each binary's symbol table, so binaries linked with -s only get a
+// section report. Type descriptors, itabs, and other generated symbols are
+// charged to the package of the declaration they're derived from. Symbols
+// that don't belong to a package are grouped as "<other>".
+func sizeDiff(args []string) error {
+	// Process command line arguments.
+	var top int
+	var maxGrowth int64
+	fs := flag.NewFlagSet("sizediff", flag.ExitOnError)
+	fs.IntVar(&top, "top", 20, "number of packages to report, largest changes first; 0 reports all packages")
+	fs.Int64Var(&maxGrowth, "max-growth", -1, "if non-negative, fail if the total size of loaded sections grows by more than this many bytes")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: sizediff [-top n] [-max-growth bytes] old new")
+	}
+	oldPath, newPath := fs.Arg(0), fs.Arg(1)
+
+	oldBin, err := openBinary(oldPath)
+	if err != nil {
+		return err
+	}
+	defer oldBin.close()
+	newBin, err := openBinary(newPath)
+	if err != nil {
+		return err
+	}
+	defer newBin.close()
+	if oldBin.format != newBin.format {
+		return fmt.Errorf("can't compare %s binary %s with %s binary %s", oldBin.format, oldPath, newBin.format, newPath)
+	}
+
+	sections := diffSizes(sectionSizes(oldBin), sectionSizes(newBin))
+	packages := diffSizes(packageSizes(oldBin), packageSizes(newBin))
+	total := sizeDelta{name: "total"}
+	for _, d := range sections {
+		total.old += d.old
+		total.new += d.new
+	}
+	writeSizeDiff(os.Stdout, sections, packages, total, top)
+
+	if growth := total.new - total.old; maxGrowth >= 0 && growth > maxGrowth {
+		return fmt.Errorf("%s is %d bytes larger than %s, more than the limit of %d bytes", newPath, growth, oldPath, maxGrowth)
+	}
+	return nil
+}
+
+// sizeDelta is the size of a section or package in two binaries.
+type sizeDelta struct {
+	name     string
+	old, new int64
+}
+
+func (d sizeDelta) delta() int64 {
+	return d.new - d.old
+}
+
+// sectionSizes returns the sizes of a binary's sections, by name.
+func sectionSizes(bin *binaryFile) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, s := range bin.sections {
+		sizes[s.name] += int64(s.size)
+	}
+	return sizes
+}
+
+// packageSizes returns the total size of each package's symbols.
+func packageSizes(bin *binaryFile) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, s := range bin.symbols {
+		sizes[symbolPackage(s.name)] += int64(s.size)
+	}
+	return sizes
+}
+
+// symbolPackage returns the package path a symbol belongs to. Symbols the
+// compiler generates for a declaration belong to the declaring package:
+// type descriptors like "type.*example.com/a.T", helpers like
+// "type..eq.example.com/a.T", and itabs like "go.itab.*os.File,io.Writer",
+// which belong to the concrete type's package. Symbols without a package,
+// like "type.int", "type.[]string", or "go.buildid", are grouped as
+// "<other>".
+func symbolPackage(sym string) string {
+	pkgPath, _, err := splitSymbol(trimGeneratedSymbolKind(sym))
+	if err != nil || pkgPath == "" || strings.ContainsAny(pkgPath, "*[](){} ,\"") {
+		return "<other>"
+	}
+	return pkgPath
+}
+
+// trimGeneratedSymbolKind removes the prefixes of symbols the compiler and
+// linker generate, leaving the declaration they're derived from, if any.
+// Go 1.20 and later write "type:" and "go:" where earlier releases write
+// "type." and "go."; both forms are handled.
+func trimGeneratedSymbolKind(sym string) string {
+	for _, prefix := range []string{"type:", "go:"} {
+		if strings.HasPrefix(sym, prefix) {
+			sym = prefix[:len(prefix)-1] + "." + sym[len(prefix):]
+		}
+	}
+	switch {
+	case strings.HasPrefix(sym, "type.."):
+		// Helpers like "type..eq.example.com/a.T" and "type..importpath.fmt.".
+		sym = sym[len("type.."):]
+		i := strings.IndexByte(sym, '.')
+		if i < 0 {
+			return ""
+		}
+		sym = sym[i+1:]
+	case strings.HasPrefix(sym, "type."):
+		sym = sym[len("type."):]
+	case strings.HasPrefix(sym, "go."):
+		// Symbols like "go.itab.*os.File,io.Writer" and "go.info.fmt.Println".
+		sym = sym[len("go."):]
+		i := strings.IndexByte(sym, '.')
+		if i < 0 {
+			return ""
+		}
+		sym = sym[i+1:]
+		if i := strings.IndexByte(sym, ','); i >= 0 {
+			sym = sym[:i]
+		}
+	}
+	return strings.TrimLeft(sym, "*")
+}
+
+// diffSizes pairs sizes by name. The result is sorted by the magnitude of
+// the change, largest first, then by name.
+func diffSizes(old, new map[string]int64) []sizeDelta {
+	var deltas []sizeDelta
+	for name, size := range old {
+		deltas = append(deltas, sizeDelta{name: name, old: size, new: new[name]})
+	}
+	for name, size := range new {
+		if _, ok := old[name]; !ok {
+			deltas = append(deltas, sizeDelta{name: name, new: size})
+		}
+	}
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if di, dj := abs(deltas[i].delta()), abs(deltas[j].delta()); di != dj {
+			return di > dj
+		}
+		return deltas[i].name < deltas[j].name
+	})
+	return deltas
+}
+
+// writeSizeDiff writes a report of section and package size changes. Only
+// the first top packages are listed, unless top is 0.
+func writeSizeDiff(w io.Writer, sections, packages []sizeDelta, total sizeDelta, top int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	writeRow := func(d sizeDelta) {
+		percent := "new"
+		if d.old != 0 {
+			percent = fmt.Sprintf("%+.1f%%", float64(d.delta())*100/float64(d.old))
+		} else if d.new == 0 {
+			percent = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\t%s\n", d.name, d.old, d.new, d.delta(), percent)
+	}
+	fmt.Fprintf(tw, "SECTION\tOLD\tNEW\tDELTA\n")
+	for _, d := range sections {
+		writeRow(d)
+	}
+	writeRow(total)
+	if len(packages) > 0 {
+		fmt.Fprintf(tw, "\n")
+		fmt.Fprintf(tw, "PACKAGE\tOLD\tNEW\tDELTA\n")
+		for i, d := range packages {
+			if top > 0 && i == top {
+				fmt.Fprintf(tw, "(%d more)\n", len(packages)-top)
+				break
+			}
+			writeRow(d)
+		}
+	}
+	tw.Flush()
+}
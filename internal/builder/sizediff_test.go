@@ -0,0 +1,126 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSymbolPackage(t *testing.T) {
+	for _, tc := range []struct {
+		sym, pkg string
+	}{
+		{"main.main", "main"},
+		{"runtime.text", "runtime"},
+		{"gopkg.in/yaml%2ev2.(*Decoder).Decode", "gopkg.in/yaml.v2"},
+		{"type.*example.com/a.T", "example.com/a"},
+		{"type.example.com/a.T", "example.com/a"},
+		{"type..eq.example.com/a.T", "example.com/a"},
+		{"type..hash.example.com/a.T", "example.com/a"},
+		{"type..importpath.fmt.", "fmt"},
+		{"type..namedata.*func()", "<other>"},
+		{"type.int", "<other>"},
+		{"type.[]string", "<other>"},
+		{"type.map[string]example.com/a.T", "<other>"},
+		{"go.itab.*os.File,io.Writer", "os"},
+		{"go.itab.*example.com/a.T,example.com/b.I", "example.com/a"},
+		{"go.buildid", "<other>"},
+		{"go.string.\"hello\"", "<other>"},
+		{"example.com/a..inittask", "example.com/a"},
+		{"type:*example.com/a.T", "example.com/a"},
+		{"go:itab.*os.File,io.Reader", "os"},
+		{"go:buildid", "<other>"},
+		{"_rt0_amd64_linux", "<other>"},
+	} {
+		if got := symbolPackage(tc.sym); got != tc.pkg {
+			t.Errorf("symbolPackage(%q): got %q; want %q", tc.sym, got, tc.pkg)
+		}
+	}
+}
+
+func TestDiffSizes(t *testing.T) {
+	old := map[string]int64{"a": 100, "b": 50, "c": 10}
+	new := map[string]int64{"a": 90, "b": 80, "d": 10}
+	got := diffSizes(old, new)
+	want := []sizeDelta{
+		{"b", 50, 80},
+		{"a", 100, 90},
+		{"c", 10, 0},
+		{"d", 0, 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestReadWasm(t *testing.T) {
+	// A module that imports one function and defines two, named by a
+	// "name" section. The first defined function has no name.
+	var module []byte
+	module = append(module, wasmMagic...)
+	module = append(module,
+		// type section: one type, func() -> ()
+		1, 4, 1, 0x60, 0, 0,
+		// import section: function "env"."f" of type 0
+		2, 9, 1, 3, 'e', 'n', 'v', 1, 'f', 0, 0,
+		// function section: two functions of type 0
+		3, 3, 2, 0, 0,
+		// code section: bodies of 2 and 4 bytes
+		10, 9, 2, 2, 0, 0x0b, 4, 0, 1, 1, 0x0b,
+		// name section: function 2 is "main.g"
+		0, 16, 4, 'n', 'a', 'm', 'e', 1, 9, 1, 2, 6, 'm', 'a', 'i', 'n', '.', 'g',
+	)
+	bin, err := readWasm(bytes.NewReader(module))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sectionNames []string
+	for _, s := range bin.sections {
+		sectionNames = append(sectionNames, s.name)
+	}
+	if want := []string{"type", "import", "function", "code", "name"}; !reflect.DeepEqual(sectionNames, want) {
+		t.Errorf("got sections %v; want %v", sectionNames, want)
+	}
+	wantSyms := []binarySymbol{
+		{"wasm-function[1]", 34, 2, "code"},
+		{"main.g", 37, 4, "code"},
+	}
+	if !reflect.DeepEqual(bin.symbols, wantSyms) {
+		t.Errorf("got symbols %v; want %v", bin.symbols, wantSyms)
+	}
+}
+
+func TestReadWasmMalformed(t *testing.T) {
+	// LEB128 encoding of 1<<63, which is negative as an int.
+	huge := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}
+	for _, tc := range []struct {
+		desc    string
+		section []byte
+	}{
+		{
+			desc:    "truncated_body",
+			section: []byte{10, 4, 1, 9, 0, 0x0b},
+		}, {
+			desc:    "huge_body",
+			section: append(append([]byte{10, 12, 1}, huge...), 0x0b),
+		}, {
+			desc:    "truncated_names",
+			section: []byte{0, 8, 4, 'n', 'a', 'm', 'e', 1, 9, 0},
+		}, {
+			desc:    "huge_names",
+			section: append([]byte{0, 17, 4, 'n', 'a', 'm', 'e', 1}, append(huge, 0)...),
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			module := append([]byte(wasmMagic), tc.section...)
+			if _, err := readWasm(bytes.NewReader(module)); err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+}
@@ -6,15 +6,128 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// sourceKind identifies the kind of a source file by its extension.
+type sourceKind int
+
+const (
+	goKind sourceKind = iota
+	asmKind
+	cKind
+	headerKind
+	objectKind
+)
+
+var sourceKindNames = [...]string{"Go", "assembly", "C", "header", "object"}
+
+func (k sourceKind) String() string { return sourceKindNames[k] }
+
+var sourceKindsByExt = map[string]sourceKind{
+	".go":   goKind,
+	".s":    asmKind,
+	".S":    asmKind,
+	".c":    cKind,
+	".cc":   cKind,
+	".cpp":  cKind,
+	".cxx":  cKind,
+	".m":    cKind,
+	".h":    headerKind,
+	".hh":   headerKind,
+	".hpp":  headerKind,
+	".hxx":  headerKind,
+	".o":    objectKind,
+	".obj":  objectKind,
+	".syso": objectKind,
+}
+
+// expandSourceDirs replaces directories in a list of source paths with the
+// source files they contain, in sorted order. A directory stands for one
+// package, like in go build, so subdirectories, which hold other packages,
+// are skipped. Like go build, it also skips files whose names start with '_'
+// or '.'. Files with unrecognized extensions in directories are skipped,
+// too; they may be data files. Other paths are unchanged.
+func expandSourceDirs(paths []string) ([]string, error) {
+	var expanded []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil || !fi.IsDir() {
+			// classifySources or the compiler will report missing files.
+			expanded = append(expanded, p)
+			continue
+		}
+		fis, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() || isIgnoredName(name) {
+				continue
+			}
+			if _, ok := sourceKindsByExt[filepath.Ext(name)]; ok {
+				expanded = append(expanded, filepath.Join(p, name))
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// isIgnoredName returns whether go build ignores a file or directory with
+// the given base name.
+func isIgnoredName(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
+// isIgnoredSource returns whether go build ignores a source file: its name
+// starts with '_' or '.', or it's in a testdata directory.
+func isIgnoredSource(path string) bool {
+	path = filepath.ToSlash(path)
+	return isIgnoredName(filepath.Base(path)) ||
+		strings.HasPrefix(path, "testdata/") ||
+		strings.Contains(path, "/testdata/")
+}
+
+// classifySources groups source files by kind. Files keep their relative
+// order within each group. An error is returned for files go build would
+// ignore, for files with unrecognized extensions, and for files with a kind
+// not in supported. expandSourceDirs has already skipped ignored files found
+// in directories, so every path here was listed explicitly, and dropping
+// one without a message would hide a mistake in srcs.
+func classifySources(paths []string, supported ...sourceKind) (map[sourceKind][]string, error) {
+	groups := make(map[sourceKind][]string)
+	for _, path := range paths {
+		if isIgnoredSource(path) {
+			return nil, fmt.Errorf("%s: go build ignores files whose names start with '_' or '.' and files in testdata directories; rename the file or remove it from srcs", path)
+		}
+		ext := filepath.Ext(path)
+		kind, ok := sourceKindsByExt[ext]
+		if !ok {
+			return nil, fmt.Errorf("%s: unrecognized source file extension %q", path, ext)
+		}
+		isSupported := false
+		for _, k := range supported {
+			isSupported = isSupported || k == kind
+		}
+		if !isSupported {
+			return nil, fmt.Errorf("%s: %s files are not supported", path, kind)
+		}
+		groups[kind] = append(groups[kind], path)
+	}
+	return groups, nil
+}
+
 type sourceInfo struct {
 	fileName    string
 	match       bool
@@ -24,6 +137,23 @@ type sourceInfo struct {
 	hasTestMain bool
 }
 
+// loadSourceInfos calls loadSourceInfo for each file concurrently. The
+// results are in the same order as fileNames. If any file can't be loaded,
+// the error for the first such file in fileNames is returned.
+func loadSourceInfos(bctx *build.Context, fileNames []string) ([]sourceInfo, error) {
+	infos := make([]sourceInfo, len(fileNames))
+	errs := make([]error, len(fileNames))
+	parallelFor(len(fileNames), func(i int) {
+		infos[i], errs[i] = loadSourceInfo(bctx, fileNames[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
 // loadSourceInfo extracts metadata from a source file.
 func loadSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
 	if match, err := bctx.MatchFile(filepath.Dir(fileName), filepath.Base(fileName)); err != nil {
@@ -47,6 +177,11 @@ func loadSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
 		match:       true,
 		packageName: tree.Name.Name,
 	}
+	if !bctx.CgoEnabled && importsC(tree) {
+		// Like go/build, treat files that use cgo as if they had a cgo
+		// build constraint.
+		return sourceInfo{fileName: fileName}, nil
+	}
 	for _, decl := range tree.Decls {
 		switch decl := decl.(type) {
 		case *ast.GenDecl:
@@ -90,3 +225,90 @@ func loadSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
 	}
 	return si, nil
 }
+
+func importsC(tree *ast.File) bool {
+	for _, imp := range tree.Imports {
+		if imp.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPackageNames reports an error if sources declare different package
+// names, listing the files that declare each name. If all sources agree
+// and packagePath is set, checkPackageNames also warns when the package name
+// is not a plausible name for packagePath. Go allows these to differ, but
+// it's usually a mistake in the build file.
+func checkPackageNames(packagePath string, srcs []sourceInfo) error {
+	var names []string
+	filesByName := make(map[string][]string)
+	for _, src := range srcs {
+		if _, ok := filesByName[src.packageName]; !ok {
+			names = append(names, src.packageName)
+		}
+		filesByName[src.packageName] = append(filesByName[src.packageName], filepath.Base(src.fileName))
+	}
+	if len(names) > 1 {
+		b := &strings.Builder{}
+		b.WriteString("found packages")
+		for i, name := range names {
+			switch {
+			case i == 0:
+				b.WriteString(" ")
+			case i == len(names)-1:
+				b.WriteString(" and ")
+			default:
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(b, "%s (%s)", name, strings.Join(filesByName[name], ", "))
+		}
+		return fmt.Errorf("%s", b.String())
+	}
+
+	if len(names) == 1 && names[0] != "main" && packagePath != "" && !isPlausiblePackageName(names[0], packagePath) {
+		log.Printf("warning: package name %q does not match import path %q", names[0], packagePath)
+	}
+	return nil
+}
+
+// isPlausiblePackageName returns whether name is a conventional name for a
+// package with the given import path. The last element of the path is
+// compared with name, ignoring major version suffixes (like "/v2" or
+// ".v2"), "go-" prefixes, "-go" and ".go" suffixes, and punctuation that
+// can't appear in identifiers.
+func isPlausiblePackageName(name, packagePath string) bool {
+	elems := strings.Split(packagePath, "/")
+	last := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(last) {
+		last = elems[len(elems)-2]
+	}
+	if i := strings.LastIndex(last, "."); i >= 0 && isMajorVersion(last[i+1:]) {
+		last = last[:i]
+	}
+	last = strings.TrimPrefix(last, "go-")
+	last = strings.TrimSuffix(last, "-go")
+	last = strings.TrimSuffix(last, ".go")
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '-' || r == '.' || r == '_' {
+				return -1
+			}
+			return r
+		}, strings.ToLower(s))
+	}
+	return normalize(name) == normalize(last)
+}
+
+// isMajorVersion returns whether s looks like "v2", "v3", and so on.
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandSourceDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExpandSourceDirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"b.go",
+		"a.go",
+		"a_amd64.s",
+		"README.md",
+		"_ignored.go",
+		".hidden.go",
+		"sub/c.go",
+		"sub/inc.h",
+		"testdata/d.go",
+		"_build/e.go",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := expandSourceDirs([]string{"z.go", dir, "x.s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"z.go"}
+	for _, name := range []string{"a.go", "a_amd64.s", "b.go"} {
+		want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	want = append(want, "x.s")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	for _, p := range got {
+		if filepath.Base(filepath.Dir(p)) == "sub" {
+			t.Errorf("got %s from a subdirectory, which is another package", p)
+		}
+	}
+}
+
+func TestClassifySourcesIgnored(t *testing.T) {
+	got, err := classifySources([]string{
+		"a.go",
+		"pkg/mytestdata/e.go",
+		"f.s",
+	}, goKind, asmKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[sourceKind][]string{
+		goKind:  {"a.go", "pkg/mytestdata/e.go"},
+		asmKind: {"f.s"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Explicitly listed files go build would ignore are reported, not
+	// dropped.
+	for _, path := range []string{
+		"_a.go",
+		".a.go",
+		"testdata/b.go",
+		"pkg/testdata/c.go",
+		"pkg/_d.txt",
+	} {
+		_, err := classifySources([]string{"a.go", path}, goKind, asmKind)
+		if err == nil || !strings.HasPrefix(err.Error(), path+":") {
+			t.Errorf("classifySources with %s: got error %v; want an error naming the file", path, err)
+		}
+	}
+}
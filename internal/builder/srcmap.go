@@ -0,0 +1,100 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// srcMapping rewrites the path of a source file (or a directory of source
+// files) to a logical path. This is used for generated sources, which
+// Bazel writes to long paths under bazel-out. Rewritten paths appear in
+// diagnostics and in debug information recorded by the compiler and
+// assembler.
+type srcMapping struct {
+	from, to string
+}
+
+// srcMap is set by flags registered with addSrcMapFlags.
+var srcMap []srcMapping
+
+// addSrcMapFlags registers flags that rewrite source paths.
+func addSrcMapFlags(fs *flag.FlagSet) {
+	fs.Var(srcMapFlag{}, "srcmap", "rewrite a source file or directory path in diagnostics and debug information, formatted as generated_path=logical_path (may be repeated)")
+}
+
+type srcMapFlag struct{}
+
+func (srcMapFlag) String() string {
+	var parts []string
+	for _, m := range srcMap {
+		parts = append(parts, m.from+"="+m.to)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (srcMapFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("malformed -srcmap value; expected generated_path=logical_path: %q", value)
+	}
+	from := filepath.Clean(value[:i])
+	to := filepath.Clean(value[i+1:])
+	srcMap = append(srcMap, srcMapping{from: from, to: to})
+	return nil
+}
+
+// mapSourcePath applies the first matching mapping in srcMap to path.
+// path is returned unchanged if no mapping matches.
+func mapSourcePath(path string) string {
+	for _, m := range srcMap {
+		if path == m.from {
+			return m.to
+		}
+		if strings.HasPrefix(path, m.from+string(filepath.Separator)) {
+			return m.to + path[len(m.from):]
+		}
+	}
+	return path
+}
+
+// mapDiagnosticPos applies srcMap to the file name in a diagnostic position
+// like "file.go:12:3".
+func mapDiagnosticPos(pos string) string {
+	i := strings.IndexByte(pos, ':')
+	if i < 0 {
+		return mapSourcePath(pos)
+	}
+	return mapSourcePath(pos[:i]) + pos[i:]
+}
+
+// trimpathArgs returns a -trimpath flag for the compiler or assembler that
+// applies srcMap to file names recorded in debug information. The tools
+// record absolute paths, so mappings are made absolute relative to the
+// current directory. The current directory (the execroot, which may be a
+// sandbox) is trimmed from other paths, so debug information refers to
+// sources by workspace-relative paths, and a package compiled in different
+// sandboxes produces the same archive.
+func trimpathArgs() ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	rewrites := make([]string, 0, len(srcMap)+1)
+	for _, m := range srcMap {
+		from := m.from
+		if !filepath.IsAbs(from) {
+			from = filepath.Join(wd, from)
+		}
+		rewrites = append(rewrites, from+"=>"+m.to)
+	}
+	rewrites = append(rewrites, wd)
+	return []string{"-trimpath", strings.Join(rewrites, ";")}, nil
+}
@@ -0,0 +1,217 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// builderVersion identifies this version of the builder. It may be set
+// when the builder is linked with -X main.builderVersion=...
+var builderVersion = "devel"
+
+// buildInfo describes how a binary was built. With -stamp, link appends it
+// to the binary, and 'version -file' prints it.
+type buildInfo struct {
+	Builder string `json:"builder"`
+	Go      string `json:"go"`
+	GOOS    string `json:"goos"`
+	GOARCH  string `json:"goarch"`
+	Variant string `json:"variant,omitempty"`
+
+	// Importcfg is the SHA-256 digest of the importcfg file passed to the
+	// linker, which maps each package in the binary to an archive file.
+	Importcfg string `json:"importcfg"`
+}
+
+// buildInfoMagic marks the end of a binary with build info. The info is
+// stored as JSON, followed by its length as a little-endian uint64,
+// followed by buildInfoMagic. Trailing data is ignored by loaders for the
+// executable formats Go supports.
+const buildInfoMagic = "\xffrules_go_simple buildinfo\xff"
+
+// newBuildInfo returns build info for a binary linked with the given
+// importcfg file.
+func newBuildInfo(importcfgPath string) (buildInfo, error) {
+	linker, err := tools.path("linker", tools.linker)
+	if err != nil {
+		return buildInfo{}, err
+	}
+	goVersion, err := toolVersion(linker)
+	if err != nil {
+		return buildInfo{}, err
+	}
+	importcfgData, err := ioutil.ReadFile(importcfgPath)
+	if err != nil {
+		return buildInfo{}, err
+	}
+	return buildInfo{
+		Builder:   builderVersion,
+		Go:        goVersion,
+		GOOS:      target.goos,
+		GOARCH:    target.goarch,
+		Variant:   target.variant,
+		Importcfg: fmt.Sprintf("%x", sha256.Sum256(importcfgData)),
+	}, nil
+}
+
+// toolVersion runs a tool from the Go distribution with -V and returns the
+// Go version it reports. For example, "compile version go1.13.4" yields
+// "go1.13.4".
+func toolVersion(toolPath string) (string, error) {
+	cmd := exec.Command(toolPath, "-V")
+	cmd.Env = toolEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s -V: %v", toolPath, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", fmt.Errorf("%s -V: unexpected output %q", toolPath, out)
+	}
+	return fields[2], nil
+}
+
+// appendBuildInfo appends build info to the end of a binary.
+func appendBuildInfo(binPath string, info buildInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(binPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	buf.Write(data)
+	binary.Write(buf, binary.LittleEndian, uint64(len(data)))
+	buf.WriteString(buildInfoMagic)
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readBuildInfo reads build info appended to a binary by appendBuildInfo.
+func readBuildInfo(binPath string) (buildInfo, error) {
+	f, err := os.Open(binPath)
+	if err != nil {
+		return buildInfo{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return buildInfo{}, err
+	}
+	errNotStamped := fmt.Errorf("%s: binary was not linked with -stamp", binPath)
+	trailerSize := int64(8 + len(buildInfoMagic))
+	if fi.Size() < trailerSize {
+		return buildInfo{}, errNotStamped
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := f.ReadAt(trailer, fi.Size()-trailerSize); err != nil {
+		return buildInfo{}, err
+	}
+	if string(trailer[8:]) != buildInfoMagic {
+		return buildInfo{}, errNotStamped
+	}
+	n := binary.LittleEndian.Uint64(trailer[:8])
+	if n > uint64(fi.Size()-trailerSize) {
+		return buildInfo{}, fmt.Errorf("%s: build info is corrupt", binPath)
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, fi.Size()-trailerSize-int64(n)); err != nil && err != io.EOF {
+		return buildInfo{}, err
+	}
+	var info buildInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return buildInfo{}, fmt.Errorf("%s: build info is corrupt: %v", binPath, err)
+	}
+	return info, nil
+}
+
+// versionInfo describes the builder and the tools it was told to use.
+// It's printed by the version subcommand for inclusion in bug reports.
+type versionInfo struct {
+	Builder string                     `json:"builder"`
+	Runtime string                     `json:"runtime"`
+	Tools   map[string]toolVersionInfo `json:"tools,omitempty"`
+}
+
+type toolVersionInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// version prints the version of the builder and of each tool set with a
+// flag as JSON. With -file, version instead prints build info appended to a
+// binary linked with -stamp.
+func version(args []string) error {
+	// Process command line arguments.
+	var binPath string
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.StringVar(&binPath, "file", "", "path to a binary linked with -stamp")
+	addToolFlags(fs)
+	addPlatformFlags(fs)
+	fs.Parse(args)
+	if len(fs.Args()) != 0 {
+		return fmt.Errorf("expected 0 positional arguments; got %d", len(fs.Args()))
+	}
+
+	var v interface{}
+	if binPath != "" {
+		info, err := readBuildInfo(binPath)
+		if err != nil {
+			return err
+		}
+		v = info
+	} else {
+		info := versionInfo{
+			Builder: builderVersion,
+			Runtime: runtime.Version(),
+			Tools:   make(map[string]toolVersionInfo),
+		}
+		for _, t := range []struct{ name, path string }{
+			{"compiler", tools.compiler},
+			{"linker", tools.linker},
+			{"assembler", tools.assembler},
+			{"packer", tools.packer},
+		} {
+			if t.path == "" {
+				continue
+			}
+			tv := toolVersionInfo{Path: t.path}
+			// pack doesn't report its version.
+			if t.name != "packer" {
+				var err error
+				if tv.Version, err = toolVersion(t.path); err != nil {
+					tv.Error = err.Error()
+				}
+			}
+			info.Tools[t.name] = tv
+		}
+		v = info
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", data)
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// stdAllowlist is the subset of the standard library a constrained target
+// supports, like wasm or bare metal, where many packages compile but can't
+// work. It's read from a manifest given with -std-allowlist. Each line of
+// the manifest is an import path pattern, like "strings" or "unicode/...".
+// Lines starting with '#' are comments, and a line starting with "reason:"
+// describes the target's limitation for error messages.
+//
+// Only imports that resolve to the standard library are checked. Standard
+// library packages may import each other freely, since they're already
+// compiled.
+type stdAllowlist struct {
+	path, reason string
+	patterns     []string
+}
+
+// readStdAllowlist parses a standard library allowlist manifest. If path is
+// empty, the returned allowlist allows everything.
+func readStdAllowlist(path string) (stdAllowlist, error) {
+	if path == "" {
+		return stdAllowlist{}, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return stdAllowlist{}, err
+	}
+	a := stdAllowlist{path: path}
+	for lineNum, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "reason:"):
+			a.reason = strings.TrimSpace(strings.TrimPrefix(line, "reason:"))
+		default:
+			if err := (importPatternFlag{&a.patterns}).Set(line); err != nil {
+				return stdAllowlist{}, fmt.Errorf("%s:%d: %v", path, lineNum+1, err)
+			}
+		}
+	}
+	return a, nil
+}
+
+// allows returns whether imp may be imported.
+func (a stdAllowlist) allows(imp string) bool {
+	if a.path == "" {
+		return true
+	}
+	for _, pattern := range a.patterns {
+		if matchImportPattern(pattern, imp) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error listing imports of standard library packages in
+// srcs that aren't allowed. stdArchives is the standard library importcfg,
+// which says which imports are standard library packages.
+func (a stdAllowlist) check(srcs []sourceInfo, stdArchives map[string]string) error {
+	if a.path == "" {
+		return nil
+	}
+	var violations []string
+	for _, src := range srcs {
+		for _, imp := range src.imports {
+			if stdArchives[imp] != "" && !a.allows(imp) {
+				violations = append(violations, fmt.Sprintf("%s: import %q", mapSourcePath(src.fileName), imp))
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "standard library packages not supported on %s/%s", target.goos, target.goarch)
+	if a.reason != "" {
+		fmt.Fprintf(b, " (%s)", a.reason)
+	}
+	fmt.Fprintf(b, "; supported packages are listed in %s:", a.path)
+	for _, v := range violations {
+		fmt.Fprintf(b, "\n\t%s", v)
+	}
+	return fmt.Errorf("%s", b.String())
+}
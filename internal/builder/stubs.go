@@ -0,0 +1,326 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"go/constant"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// genStubs generates a Go source file for a package with the same exported
+// API as a standard library package, where every function and method
+// panics. It's meant for experimenting with targets that can't support a
+// package: with the stub compiled under the package's path and
+// -std-overlap=replace, code that imports the package compiles and links,
+// and fails only if the missing functionality is actually used.
+//
+//	genstubs -stdimportcfg importcfg -p packagepath -o file.go
+//
+// The API is read from export data, like apicheck. Types are declared with
+// their exported fields and methods only; unexported types the API refers
+// to are declared too. Generic functions and types are skipped.
+func genStubs(args []string) error {
+	// Process command line arguments.
+	var stdImportcfgPath, packagePath, outPath string
+	fs := flag.NewFlagSet("genstubs", flag.ExitOnError)
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library")
+	fs.StringVar(&packagePath, "p", "", "path of the standard library package to generate stubs for")
+	fs.StringVar(&outPath, "o", "", "path to the Go source file to generate")
+	addCommonFlags(fs)
+	fs.Parse(args)
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("expected 0 positional arguments; got %d", fs.NArg())
+	}
+	if stdImportcfgPath == "" || packagePath == "" || outPath == "" {
+		return errors.New("-stdimportcfg, -p, and -o must be set")
+	}
+	if err := checkSandbox(stdImportcfgPath, outPath); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+
+	archiveMap, err := readImportcfg(stdImportcfgPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := archiveMap[packagePath]; !ok {
+		return fmt.Errorf("%s is not in the standard library importcfg %s", packagePath, stdImportcfgPath)
+	}
+	lookup := func(path string) (io.ReadCloser, error) {
+		file, ok := archiveMap[path]
+		if !ok {
+			return nil, fmt.Errorf("no archive for package %q", path)
+		}
+		return os.Open(file)
+	}
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "gc", lookup).Import(packagePath)
+	if err != nil {
+		return fmt.Errorf("reading export data for %s: %v", packagePath, err)
+	}
+
+	f, err := createAtomic(outPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeStubs(w, pkg); err != nil {
+		f.abort()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// stubImports assigns names to the packages a stub file refers to. Each is
+// imported with an explicit name, so packages with the same name, like
+// crypto/rand and math/rand, don't collide.
+type stubImports struct {
+	self  *types.Package
+	names map[string]string
+	used  map[string]bool
+}
+
+func (imps *stubImports) qualifier(p *types.Package) string {
+	if p == imps.self {
+		return ""
+	}
+	if name, ok := imps.names[p.Path()]; ok {
+		return name
+	}
+	name := p.Name()
+	for i := 2; imps.used[name] || name == imps.self.Name(); i++ {
+		name = p.Name() + strconv.Itoa(i)
+	}
+	imps.names[p.Path()] = name
+	imps.used[name] = true
+	return name
+}
+
+// writeStubs writes a stub source file for pkg. Declarations are written to
+// a buffer first, since imports are only known once they've been formatted.
+func writeStubs(w io.Writer, pkg *types.Package) error {
+	imps := &stubImports{self: pkg, names: make(map[string]string), used: make(map[string]bool)}
+	qual := imps.qualifier
+	body := &strings.Builder{}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if isGeneric(scope.Lookup(name)) {
+			continue
+		}
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Const:
+			if !obj.Exported() {
+				continue
+			}
+			if b, ok := obj.Type().(*types.Basic); ok && b.Kind() == types.UntypedRune {
+				fmt.Fprintf(body, "const %s = %s\n\n", name, runeConstantString(obj.Val()))
+			} else if ok && b.Info()&types.IsUntyped != 0 {
+				fmt.Fprintf(body, "const %s = %s\n\n", name, constantString(obj.Val()))
+			} else {
+				fmt.Fprintf(body, "const %s %s = %s\n\n", name, types.TypeString(obj.Type(), qual), constantString(obj.Val()))
+			}
+
+		case *types.Var:
+			if obj.Exported() {
+				fmt.Fprintf(body, "var %s %s\n\n", name, types.TypeString(obj.Type(), qual))
+			}
+
+		case *types.Func:
+			if obj.Exported() {
+				writeStubFunc(body, pkg, "", obj, qual)
+			}
+
+		case *types.TypeName:
+			// Unexported types are declared too, since exported
+			// declarations may refer to them.
+			if obj.IsAlias() {
+				fmt.Fprintf(body, "type %s = %s\n\n", name, types.TypeString(aliasTarget(obj.Type()), qual))
+				continue
+			}
+			fmt.Fprintf(body, "type %s %s\n\n", name, stubUnderlying(obj.Type().Underlying(), qual))
+			named, ok := obj.Type().(*types.Named)
+			if !ok || types.IsInterface(named) {
+				continue
+			}
+			for i := 0; i < named.NumMethods(); i++ {
+				if m := named.Method(i); m.Exported() {
+					writeStubFunc(body, pkg, name, m, qual)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "// Code generated by genstubs from export data for %s. DO NOT EDIT.\n\n", pkg.Path())
+	fmt.Fprintf(w, "package %s\n\n", pkg.Name())
+	if len(imps.names) > 0 {
+		paths := make([]string, 0, len(imps.names))
+		for path := range imps.names {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(w, "import (\n")
+		for _, path := range paths {
+			fmt.Fprintf(w, "\t%s %s\n", imps.names[path], strconv.Quote(path))
+		}
+		fmt.Fprintf(w, ")\n\n")
+	}
+	_, err := io.WriteString(w, body.String())
+	return err
+}
+
+// stubUnderlying formats the underlying type of a declared type. Unexported
+// struct fields are dropped, since they may refer to packages or types the
+// stub doesn't need.
+func stubUnderlying(t types.Type, qual types.Qualifier) string {
+	s, ok := t.(*types.Struct)
+	if !ok {
+		return types.TypeString(t, qual)
+	}
+	b := &strings.Builder{}
+	b.WriteString("struct {")
+	n := 0
+	for i := 0; i < s.NumFields(); i++ {
+		f := s.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		if n == 0 {
+			b.WriteString("\n")
+		}
+		n++
+		b.WriteString("\t")
+		if !f.Embedded() {
+			b.WriteString(f.Name() + " ")
+		}
+		b.WriteString(types.TypeString(f.Type(), qual))
+		if tag := s.Tag(i); tag != "" {
+			b.WriteString(" " + strconv.Quote(tag))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// writeStubFunc writes a function or method whose body panics. recvName is
+// the name of the receiver's type, or "" for a function.
+func writeStubFunc(w io.Writer, pkg *types.Package, recvName string, fn *types.Func, qual types.Qualifier) {
+	sig := fn.Type().(*types.Signature)
+	fmt.Fprintf(w, "func ")
+	qualified := pkg.Name() + "." + fn.Name()
+	if recvName != "" {
+		recv := recvName
+		if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+			recv = "*" + recvName
+		}
+		fmt.Fprintf(w, "(%s) ", recv)
+		qualified = pkg.Name() + "." + recvName + "." + fn.Name()
+	}
+	fmt.Fprintf(w, "%s(", fn.Name())
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		if i > 0 {
+			fmt.Fprintf(w, ", ")
+		}
+		t := params.At(i).Type()
+		if sig.Variadic() && i == params.Len()-1 {
+			fmt.Fprintf(w, "...")
+			t = t.(*types.Slice).Elem()
+		}
+		fmt.Fprintf(w, "%s", types.TypeString(t, qual))
+	}
+	fmt.Fprintf(w, ")")
+	if results := sig.Results(); results.Len() == 1 {
+		fmt.Fprintf(w, " %s", types.TypeString(results.At(0).Type(), qual))
+	} else if results.Len() > 1 {
+		rs := make([]string, results.Len())
+		for i := range rs {
+			rs[i] = types.TypeString(results.At(i).Type(), qual)
+		}
+		fmt.Fprintf(w, " (%s)", strings.Join(rs, ", "))
+	}
+	fmt.Fprintf(w, " {\n\tpanic(%s)\n}\n\n", strconv.Quote(qualified+" is not supported on this target"))
+}
+
+// isGeneric returns whether obj is a generic function or type. The builder
+// may be built with a version of go/types that predates type parameters, so
+// this checks how obj is formatted instead: type parameters follow the name.
+func isGeneric(obj types.Object) bool {
+	switch obj.(type) {
+	case *types.Func, *types.TypeName:
+		s := types.ObjectString(obj, func(*types.Package) string { return "" })
+		i := strings.Index(s, " "+obj.Name())
+		return i >= 0 && strings.HasPrefix(s[i+1+len(obj.Name()):], "[")
+	default:
+		return false
+	}
+}
+
+// aliasTarget returns the type an alias refers to. Newer versions of
+// go/types represent aliases with their own type, which formats as the
+// alias's name; older versions return the target directly.
+func aliasTarget(t types.Type) types.Type {
+	for {
+		a, ok := t.(interface{ Rhs() types.Type })
+		if !ok {
+			return t
+		}
+		t = a.Rhs()
+	}
+}
+
+// runeConstantString formats an untyped rune constant. Its value is an
+// integer, but an integer literal would give the constant a different
+// default type. Values that aren't valid runes, like surrogate halves, are
+// written as an offset from a rune literal, which is still an untyped rune.
+func runeConstantString(v constant.Value) string {
+	r, ok := constant.Int64Val(v)
+	if ok && r >= 0 && r <= utf8.MaxRune && utf8.ValidRune(rune(r)) {
+		return strconv.QuoteRune(rune(r))
+	}
+	return "('\\x00' + " + v.ExactString() + ")"
+}
+
+// constantString formats a constant value as a Go expression with the same
+// value. Floats that can't be written exactly in decimal are written as a
+// quotient of two float literals, since a quotient of integer literals
+// would be truncated.
+func constantString(v constant.Value) string {
+	s := v.ExactString()
+	if v.Kind() != constant.Float {
+		return s
+	}
+	if i := strings.Index(s, "/"); i >= 0 {
+		return "(" + s[:i] + ".0 / " + s[i+1:] + ".0)"
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		// Integral floats must stay floats; integer constants are limited
+		// to a smaller range.
+		return s + ".0"
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// checkPackage type checks a package from a single source file with no
+// imports.
+func checkPackage(t *testing.T, path, src string) *types.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	pkg, err := (&types.Config{}).Check(path, fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	return pkg
+}
+
+// TestWriteStubs generates stubs for a package and checks that they type
+// check and declare the same API.
+func TestWriteStubs(t *testing.T) {
+	orig := checkPackage(t, "example.com/p", `package p
+
+const (
+	Rune      = 'x'
+	Surrogate = '\x00' + 0xD800
+	Float     = 2.0
+	Third     = 1.0 / 3
+	Big       = 1 << 100
+	Str       = "s"
+	Typed     T = 3
+)
+
+var V []T
+
+type T int
+
+func (T) M(int, ...string) (int, error) { return 0, nil }
+
+func (*T) PM() {}
+
+type S struct {
+	Exported int
+	hidden   string
+	T
+}
+
+type A = S
+
+func F(*S) unexported { return unexported{} }
+
+type unexported struct{}
+`)
+
+	b := &strings.Builder{}
+	if err := writeStubs(b, orig); err != nil {
+		t.Fatal(err)
+	}
+	stub := checkPackage(t, "example.com/p", b.String())
+
+	for _, name := range orig.Scope().Names() {
+		o := orig.Scope().Lookup(name)
+		if !o.Exported() {
+			continue
+		}
+		s := stub.Scope().Lookup(name)
+		if s == nil {
+			t.Errorf("stub does not declare %s", name)
+			continue
+		}
+		if got, want := s.Type().String(), o.Type().String(); got != want {
+			t.Errorf("%s: got type %s; want %s", name, got, want)
+		}
+		if oc, ok := o.(*types.Const); ok {
+			if sv := s.(*types.Const).Val(); !constant.Compare(sv, token.EQL, oc.Val()) {
+				t.Errorf("%s: got value %s; want %s", name, sv, oc.Val())
+			}
+		}
+		if tn, ok := o.(*types.TypeName); ok && !tn.IsAlias() {
+			oms := types.NewMethodSet(types.NewPointer(o.Type()))
+			sms := types.NewMethodSet(types.NewPointer(s.Type()))
+			if oms.Len() != sms.Len() {
+				t.Errorf("%s: got %d methods; want %d", name, sms.Len(), oms.Len())
+			}
+			for i := 0; i < oms.Len(); i++ {
+				m := oms.At(i).Obj()
+				sm := sms.Lookup(stub, m.Name())
+				if sm == nil {
+					t.Errorf("%s: stub has no method %s", name, m.Name())
+				} else if got, want := sm.Type().String(), m.Type().String(); got != want {
+					t.Errorf("%s.%s: got type %s; want %s", name, m.Name(), got, want)
+				}
+			}
+		}
+	}
+	if strings.Contains(b.String(), "hidden") {
+		t.Errorf("stub declares an unexported field:\n%s", b.String())
+	}
+}
+
+func TestRuneConstantString(t *testing.T) {
+	for _, tc := range []struct {
+		v    int64
+		want string
+	}{
+		{'a', "'a'"},
+		{'\n', `'\n'`},
+		{0x10FFFF, `'\U0010ffff'`},
+		{0xD800, `('\x00' + 55296)`},
+	} {
+		if got := runeConstantString(constant.MakeInt64(tc.v)); got != tc.want {
+			t.Errorf("runeConstantString(%d): got %s; want %s", tc.v, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// symbolize rewrites a backtrace of program counters, like one printed by a
+// debugger, a crash handler, or a core dump tool, as a Go stack trace with
+// function names and source lines, for triaging crashes in production
+// binaries that were deployed without tools.
+//
+//	symbolize binary [trace]
+//
+// The trace is read from a file or stdin. Each line with a hexadecimal
+// address (the first number starting with 0x) becomes two lines, like in a
+// Go traceback: the function, then the file, line, and offset within the
+// function. Other lines are copied, with escaped symbol names demangled.
+// Addresses are link-time addresses; Go binaries aren't position
+// independent by default, so these match addresses at run time.
+//
+// Every address but the first in a run of consecutive address lines is
+// taken to be a return address, so the line reported is the line of the
+// call instruction before it.
+func symbolize(args []string) error {
+	// Process command line arguments.
+	fs := flag.NewFlagSet("symbolize", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("usage: symbolize binary [trace]")
+	}
+	binPath := fs.Arg(0)
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	bin, err := openBinary(binPath)
+	if err != nil {
+		return err
+	}
+	defer bin.close()
+	if len(bin.symbols) == 0 {
+		return fmt.Errorf("%s has no symbol table; it may have been linked with -s or stripped", binPath)
+	}
+	syms := append([]binarySymbol(nil), bin.symbols...)
+	sort.Slice(syms, func(i, j int) bool { return syms[i].addr < syms[j].addr })
+	var lines []lineEntry
+	if bin.dwarf != nil {
+		if lines, err = readLineTable(bin.dwarf); err != nil {
+			return fmt.Errorf("%s: reading debug information: %v", binPath, err)
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024*1024)
+	w := bufio.NewWriter(os.Stdout)
+	inFrames := false
+	for sc.Scan() {
+		pc, ok := traceAddress(sc.Text())
+		if !ok {
+			inFrames = false
+			fmt.Fprintln(w, demangleText(sc.Text()))
+			continue
+		}
+		lookupPC := pc
+		if inFrames && pc > 0 {
+			lookupPC = pc - 1
+		}
+		inFrames = true
+		sym, ok := findSymbol(syms, lookupPC)
+		if !ok {
+			fmt.Fprintf(w, "?\n\t? pc=%#x\n", pc)
+			continue
+		}
+		name := sym.name
+		if pkgPath, rest, err := splitSymbol(name); err == nil && pkgPath != "" {
+			name = pkgPath + "." + rest
+		}
+		file, line := "?", 0
+		if e, ok := findLine(lines, lookupPC); ok {
+			file, line = e.file, e.line
+		}
+		fmt.Fprintf(w, "%s(...)\n\t%s:%d +%#x\n", name, file, line, pc-sym.addr)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// traceAddressRe matches a hexadecimal address in a line of a backtrace.
+var traceAddressRe = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`)
+
+// traceAddress returns the first hexadecimal address in a line.
+func traceAddress(line string) (uint64, bool) {
+	m := traceAddressRe.FindString(line)
+	if m == "" {
+		return 0, false
+	}
+	pc, err := strconv.ParseUint(m[2:], 16, 64)
+	return pc, err == nil
+}
+
+// findSymbol returns the symbol containing pc. syms must be sorted by
+// address.
+func findSymbol(syms []binarySymbol, pc uint64) (binarySymbol, bool) {
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].addr > pc }) - 1
+	for ; i >= 0 && syms[i].addr <= pc; i-- {
+		if pc < syms[i].addr+syms[i].size {
+			return syms[i], true
+		}
+	}
+	return binarySymbol{}, false
+}
+
+// lineEntry is a row of a DWARF line table. An entry with end set marks
+// the end of a sequence of instructions; it has no line.
+type lineEntry struct {
+	addr uint64
+	file string
+	line int
+	end  bool
+}
+
+// readLineTable returns the line table entries of all compilation units,
+// sorted by address.
+func readLineTable(d *dwarf.Data) ([]lineEntry, error) {
+	var entries []lineEntry
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		r.SkipChildren()
+		lr, err := d.LineReader(e)
+		if err != nil {
+			return nil, err
+		}
+		if lr == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		for {
+			if err := lr.Next(&le); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			entry := lineEntry{addr: le.Address, line: le.Line, end: le.EndSequence}
+			if le.File != nil {
+				entry.file = le.File.Name
+			}
+			entries = append(entries, entry)
+		}
+	}
+	// A sequence may start where another ends, so at the same address, end
+	// entries are sorted first.
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].addr != entries[j].addr {
+			return entries[i].addr < entries[j].addr
+		}
+		return entries[i].end && !entries[j].end
+	})
+	return entries, nil
+}
+
+// findLine returns the line table entry for the instruction at pc.
+func findLine(entries []lineEntry, pc uint64) (lineEntry, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].addr > pc }) - 1
+	if i < 0 || entries[i].end {
+		return lineEntry{}, false
+	}
+	return entries[i], true
+}
@@ -0,0 +1,63 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import "testing"
+
+func TestTraceAddress(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		pc   uint64
+		ok   bool
+	}{
+		{"#1  0x000000000047db33 in main.main () at main.go:5", 0x47db33, true},
+		{"rip 0x47db00 0x1000", 0x47db00, true},
+		{"\t0x47DB00", 0x47db00, true},
+		{"goroutine 1 [running]:", 0, false},
+		{"x0x47db00", 0, false},
+	} {
+		pc, ok := traceAddress(tc.line)
+		if pc != tc.pc || ok != tc.ok {
+			t.Errorf("traceAddress(%q): got %#x, %v; want %#x, %v", tc.line, pc, ok, tc.pc, tc.ok)
+		}
+	}
+}
+
+func TestFindSymbolAndLine(t *testing.T) {
+	syms := []binarySymbol{
+		{"main.f", 0x1000, 0x20, ".text"},
+		{"main.g", 0x1040, 0x10, ".text"},
+	}
+	lines := []lineEntry{
+		{addr: 0x1000, file: "a.go", line: 3},
+		{addr: 0x1010, file: "a.go", line: 4},
+		{addr: 0x1020, end: true},
+		{addr: 0x1040, end: true},
+		{addr: 0x1040, file: "b.go", line: 7},
+		{addr: 0x1050, end: true},
+	}
+	for _, tc := range []struct {
+		pc         uint64
+		sym, file  string
+		line       int
+		symOK, lOK bool
+	}{
+		{0x1000, "main.f", "a.go", 3, true, true},
+		{0x1015, "main.f", "a.go", 4, true, true},
+		{0x1030, "", "", 0, false, false},
+		{0x1040, "main.g", "b.go", 7, true, true},
+		{0x1050, "", "", 0, false, false},
+	} {
+		sym, ok := findSymbol(syms, tc.pc)
+		if ok != tc.symOK || sym.name != tc.sym {
+			t.Errorf("findSymbol(%#x): got %q, %v; want %q, %v", tc.pc, sym.name, ok, tc.sym, tc.symOK)
+		}
+		e, ok := findLine(lines, tc.pc)
+		if ok != tc.lOK || e.file != tc.file || e.line != tc.line {
+			t.Errorf("findLine(%#x): got %s:%d, %v; want %s:%d, %v", tc.pc, e.file, e.line, ok, tc.file, tc.line, tc.lOK)
+		}
+	}
+}
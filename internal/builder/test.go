@@ -9,8 +9,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"go/build"
-	"io/ioutil"
 	"os"
 	"strings"
 	"text/template"
@@ -44,17 +42,58 @@ type testArchiveInfo struct {
 // that into the main archive. Finally, test links the test executable.
 func test(args []string) error {
 	// Parse command line arguments.
-	var stdImportcfgPath, packagePath, outPath, runDir string
+	var stdImportcfgPath, stdAllowlistPath, packagePath, outPath, runDir string
+	var explainSrcs, strict bool
+	var defines []string
 	var directArchives, transitiveArchives []archive
 	fs := flag.NewFlagSet("test", flag.ExitOnError)
 	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to importcfg for the standard library")
+	fs.StringVar(&stdAllowlistPath, "std-allowlist", "", "path to a manifest of standard library import path patterns the target supports; other standard library imports are errors")
 	fs.StringVar(&packagePath, "p", "default", "string used to import the test library")
 	fs.Var(archiveFlag{&directArchives}, "direct", "information about direct dependencies")
 	fs.Var(archiveFlag{&transitiveArchives}, "transitive", "information about transitive dependencies")
 	fs.StringVar(&outPath, "o", "", "path to binary file to generate")
 	fs.StringVar(&runDir, "dir", ".", "directory the test binary should change to before running")
+	fs.Var(defineFlag{&defines}, "define", "set a string variable, formatted as packagepath.name=value (may be repeated)")
+	fs.Var(crashReportFlag{}, "crash-report", "path where a tarball with the inputs of the compiler should be written if it crashes; an empty file is written otherwise")
+	addStdOverlapFlag(fs)
+	fs.BoolVar(&explainSrcs, "explain-srcs", false, "print whether each source matches build constraints and, if not, which constraint excludes it")
+	fs.BoolVar(&strict, "strict", false, "require -p instead of importing the test library as \"default\"")
+	addCommonFlags(fs)
+	addOptFlags(fs)
+	addToolFlags(fs)
+	addPlatformFlags(fs)
 	fs.Parse(args)
-	srcPaths := fs.Args()
+	events.addOutput(outPath)
+	if err := events.begin(); err != nil {
+		return err
+	}
+	if err := requirePackagePath(fs, strict); err != nil {
+		return err
+	}
+	srcArgs, err := expandSourceDirs(fs.Args())
+	if err != nil {
+		return err
+	}
+	srcGroups, err := classifySources(srcArgs, goKind)
+	if err != nil {
+		return err
+	}
+	srcPaths := srcGroups[goKind]
+	sandboxPaths := append([]string{stdImportcfgPath, stdAllowlistPath, outPath}, srcPaths...)
+	sandboxPaths = append(sandboxPaths, archivePaths(directArchives)...)
+	if err := checkSandbox(append(sandboxPaths, archivePaths(transitiveArchives)...)...); err != nil {
+		return err
+	}
+	if err := verifyInputDigests(); err != nil {
+		return err
+	}
+	if directArchives, err = skipMissingOptionalArchives(directArchives); err != nil {
+		return err
+	}
+	if transitiveArchives, err = skipMissingOptionalArchives(transitiveArchives); err != nil {
+		return err
+	}
 
 	// Filter sources into two archives: an internal package that gets compiled
 	// together with the library under test, and an external package that
@@ -68,12 +107,17 @@ func test(args []string) error {
 		PackageName: "xtest",
 	}
 	packageName := ""
-	bctx := &build.Default
-	for _, srcPath := range srcPaths {
-		src, err := loadSourceInfo(bctx, srcPath)
-		if err != nil {
+	bctx := target.buildContext()
+	if explainSrcs {
+		if err := explainSources(os.Stderr, bctx, srcPaths); err != nil {
 			return err
 		}
+	}
+	infos, err := loadSourceInfos(bctx, srcPaths)
+	if err != nil {
+		return err
+	}
+	for _, src := range infos {
 		if !src.match {
 			continue
 		}
@@ -87,25 +131,51 @@ func test(args []string) error {
 		if packageName == "" {
 			packageName = srcPackageName
 		} else if packageName != srcPackageName {
-			return fmt.Errorf("%s: package name %q does not match package name %q in file %s", src.fileName, src.packageName, info.PackageName, srcPaths[0])
+			return fmt.Errorf("%s: package name %q does not match package name %q in file %s", mapSourcePath(src.fileName), src.packageName, info.PackageName, mapSourcePath(srcPaths[0]))
 		}
 		info.Tests = append(info.Tests, src.tests...)
 		info.srcs = append(info.srcs, src)
-		info.srcPaths = append(info.srcPaths, srcPath)
+		info.srcPaths = append(info.srcPaths, src.fileName)
 		info.hasTestMain = info.hasTestMain || src.hasTestMain
 	}
 
 	// Build a map from package paths to archive files using the standard
 	// importcfg and -direct command line arguments.
-	archiveMap, err := readImportcfg(stdImportcfgPath)
+	stdCfg, err := readImportcfgFile(stdImportcfgPath)
 	if err != nil {
 		return err
 	}
+	if err := checkStdTarget(stdCfg, stdImportcfgPath); err != nil {
+		return err
+	}
+	if directArchives, err = resolveStdOverlap(stdCfg.archives, directArchives); err != nil {
+		return err
+	}
+	if transitiveArchives, err = resolveStdOverlap(stdCfg.archives, transitiveArchives); err != nil {
+		return err
+	}
+	stdAllow, err := readStdAllowlist(stdAllowlistPath)
+	if err != nil {
+		return err
+	}
+	for _, info := range []testArchiveInfo{testInfo, xtestInfo} {
+		if err := stdAllow.check(info.srcs, stdCfg.archives); err != nil {
+			return err
+		}
+	}
+	archiveMap := stdCfg.archives
 	for _, arc := range directArchives {
 		archiveMap[arc.packagePath] = arc.filePath
 	}
 
 	// Compile each archive.
+	progress.plan(2)
+	if len(testInfo.srcs) > 0 {
+		progress.plan(1)
+	}
+	if len(xtestInfo.srcs) > 0 {
+		progress.plan(1)
+	}
 	mainInfo := testMainInfo{RunDir: runDir}
 	var testArchivePath string
 	if len(testInfo.srcs) > 0 {
@@ -114,7 +184,8 @@ func test(args []string) error {
 			mainInfo.TestMainPackageName = testInfo.PackageName
 		}
 
-		testArchivePath, err = compileTestArchive(testInfo.ImportPath, testInfo.srcPaths, testInfo.srcs, archiveMap)
+		progress.stepf("compiling %d internal test files", len(testInfo.srcs))
+		testArchivePath, err = compileTestArchive(testInfo.ImportPath, testInfo.srcPaths, testInfo.srcs, archiveMap, stdCfg.toolLines("compile"))
 		if err != nil {
 			return err
 		}
@@ -132,7 +203,8 @@ func test(args []string) error {
 			mainInfo.TestMainPackageName = xtestInfo.PackageName
 		}
 
-		xtestArchivePath, err = compileTestArchive(xtestInfo.ImportPath, xtestInfo.srcPaths, xtestInfo.srcs, archiveMap)
+		progress.stepf("compiling %d external test files", len(xtestInfo.srcs))
+		xtestArchivePath, err = compileTestArchive(xtestInfo.ImportPath, xtestInfo.srcPaths, xtestInfo.srcs, archiveMap, stdCfg.toolLines("compile"))
 		if err != nil {
 			return err
 		}
@@ -151,13 +223,13 @@ func test(args []string) error {
 	for _, arc := range transitiveArchives {
 		archiveMap[arc.packagePath] = arc.filePath
 	}
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	importcfgPath, err := writeTempImportcfg(archiveMap, stdCfg.toolLines("link")...)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(importcfgPath)
 
-	testMainArchiveFile, err := ioutil.TempFile("", "*-testmain.a")
+	testMainArchiveFile, err := createTempFile("*-testmain.a")
 	if err != nil {
 		return err
 	}
@@ -166,21 +238,31 @@ func test(args []string) error {
 	if err := testMainArchiveFile.Close(); err != nil {
 		return err
 	}
+	progress.stepf("compiling test main package")
 	if err := runCompiler("main", importcfgPath, []string{testmainSrcPath}, testMainArchivePath); err != nil {
 		return err
 	}
 
 	// Link everything together.
-	return runLinker(testMainArchivePath, importcfgPath, outPath)
+	progress.stepf("linking")
+	binOut, err := newAtomicOutput(outPath)
+	if err != nil {
+		return err
+	}
+	defer binOut.cleanup()
+	if err := runLinker(testMainArchivePath, importcfgPath, binOut.tmpPath(), defineArgs(defines)...); err != nil {
+		return err
+	}
+	return binOut.commit()
 }
 
-func compileTestArchive(packagePath string, srcPaths []string, srcs []sourceInfo, archiveMap map[string]string) (string, error) {
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+func compileTestArchive(packagePath string, srcPaths []string, srcs []sourceInfo, archiveMap map[string]string, importcfgOther []string) (string, error) {
+	importcfgPath, err := writeTempImportcfg(archiveMap, importcfgOther...)
 	if err != nil {
 		return "", err
 	}
 
-	tmpArchiveFile, err := ioutil.TempFile("", "*-test.a")
+	tmpArchiveFile, err := createTempFile("*-test.a")
 	if err != nil {
 		return "", err
 	}
@@ -237,7 +319,7 @@ func main() {
 `))
 
 func generateTestMain(mainInfo testMainInfo) (testmainPath string, err error) {
-	testmainFile, err := ioutil.TempFile("", "*-testmain.go")
+	testmainFile, err := createTempFile("*-testmain.go")
 	if err != nil {
 		return "", err
 	}
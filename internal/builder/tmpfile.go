@@ -0,0 +1,77 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tmpDir is set with -tmpdir. When it's set, temporary files are created
+// there with names derived from the action's primary output instead of
+// random names in TMPDIR. Temporary files appear in tool command lines, so
+// this makes logs and failure reports from identical actions directly
+// comparable.
+var tmpDir string
+
+// tmpNameCounts counts the temporary files created with each pattern in
+// tmpDir, so a pattern used more than once in an action, like for response
+// files, still gets distinct names.
+var tmpNameCounts = make(map[string]int)
+
+// createTempFile creates a temporary file like ioutil.TempFile, with a name
+// that's deterministic if -tmpdir is set.
+func createTempFile(pattern string) (*os.File, error) {
+	if tmpDir == "" {
+		return ioutil.TempFile("", pattern)
+	}
+	return os.OpenFile(deterministicTempPath(pattern), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// createTempDir creates a temporary directory like ioutil.TempDir, with a
+// name that's deterministic if -tmpdir is set. A directory left at that
+// path by an earlier action that was interrupted is replaced.
+func createTempDir(pattern string) (string, error) {
+	if tmpDir == "" {
+		return ioutil.TempDir("", pattern)
+	}
+	path := deterministicTempPath(pattern)
+	if err := os.RemoveAll(path); err != nil {
+		return "", err
+	}
+	return path, os.Mkdir(path, 0700)
+}
+
+// deterministicTempPath returns a path in tmpDir for a temporary file. As
+// with ioutil.TempFile, the last '*' in pattern is replaced by a unique
+// string, or the string is appended if there's no '*'. Here, the string is
+// the base name of the action's primary output and a short hash of its
+// full path, followed by a count if pattern was used before.
+func deterministicTempPath(pattern string) string {
+	key := "action"
+	for _, out := range events.outputs {
+		if out != "" {
+			key = out
+			break
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	unique := filepath.Base(key) + "-" + hex.EncodeToString(sum[:4])
+	tmpNameCounts[pattern]++
+	if n := tmpNameCounts[pattern]; n > 1 {
+		unique += "-" + strconv.Itoa(n)
+	}
+	name := pattern + unique
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		name = pattern[:i] + unique + pattern[i+1:]
+	}
+	return filepath.Join(tmpDir, name)
+}
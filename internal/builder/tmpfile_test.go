@@ -0,0 +1,34 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDeterministicTempPath(t *testing.T) {
+	defer func(dir string, outputs []string) {
+		tmpDir, events.outputs = dir, outputs
+		tmpNameCounts = make(map[string]int)
+	}(tmpDir, events.outputs)
+	tmpDir = "tmp"
+	events.outputs = []string{"", "bazel-out/bin/lib.a"}
+	tmpNameCounts = make(map[string]int)
+
+	for _, tc := range []struct {
+		pattern, want string
+	}{
+		{"importcfg-*", "importcfg-lib.a-b834e33f"},
+		{"importcfg-*", "importcfg-lib.a-b834e33f-2"},
+		{"*-testmain.go", "lib.a-b834e33f-testmain.go"},
+		{"args", "argslib.a-b834e33f"},
+	} {
+		if got := deterministicTempPath(tc.pattern); got != filepath.Join("tmp", tc.want) {
+			t.Errorf("deterministicTempPath(%q): got %q; want %q", tc.pattern, got, filepath.Join("tmp", tc.want))
+		}
+	}
+}
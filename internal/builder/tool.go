@@ -0,0 +1,262 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// toolPaths contains the locations of tools from the Go distribution. The
+// toolchain provides these with flags registered by addToolFlags; the
+// builder does not search for tools itself. objdump is only used by disasm,
+// which registers its own flag.
+type toolPaths struct {
+	compiler, linker, assembler, packer, objdump string
+}
+
+var tools toolPaths
+
+// addToolFlags registers flags for tool locations on a flag set.
+func addToolFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tools.compiler, "compiler", "", "path to the Go compiler")
+	fs.StringVar(&tools.linker, "linker", "", "path to the Go linker")
+	fs.StringVar(&tools.assembler, "assembler", "", "path to the Go assembler")
+	fs.StringVar(&tools.packer, "packer", "", "path to the Go archive tool (pack)")
+	fs.IntVar(&toolRetries, "tool-retries", 0, "number of times to retry a tool that fails with a recognizable transient error, like ETXTBSY or a resource limit")
+	fs.BoolVar(&explainArgs, "explain-args", false, "print each tool command line before running it, with the builder flag or default each argument came from")
+}
+
+// toolRetries is the number of times runToolOutput retries a tool that
+// fails with a transient error. By default, tools aren't retried, since
+// most failures are real.
+var toolRetries int
+
+// transientToolMessages are parts of tool output or errors that indicate
+// the tool failed because of the state of the machine rather than its
+// inputs. These happen with heavy parallelism: for example, ETXTBSY when a
+// tool binary is executed while another process still has it open for
+// writing.
+var transientToolMessages = []string{
+	"text file busy",
+	"resource temporarily unavailable",
+	"too many open files",
+	"cannot allocate memory",
+}
+
+// isTransientToolError returns whether a tool failed in a way that may not
+// happen again if it's retried.
+func isTransientToolError(out []byte, err error) bool {
+	return transientReason(out, err) != ""
+}
+
+// transientReason returns the message that makes a failure look transient,
+// or "" if it doesn't.
+func transientReason(out []byte, err error) string {
+	for _, errno := range []syscall.Errno{syscall.ETXTBSY, syscall.EAGAIN, syscall.EMFILE, syscall.ENFILE, syscall.ENOMEM} {
+		if errors.Is(err, errno) {
+			return errno.Error()
+		}
+	}
+	for _, msg := range transientToolMessages {
+		if bytes.Contains(out, []byte(msg)) {
+			return msg
+		}
+	}
+	return ""
+}
+
+// path returns the location of a tool, or an error if it wasn't set.
+// name is the name of the flag that sets the location.
+func (t toolPaths) path(name, value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("location of %s not set; use -%s", name, name)
+	}
+	if err := checkSandbox(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// runTool runs a tool from the Go distribution, such as the compiler or
+// linker. The tool's output is collected and printed as diagnostics after
+// the tool exits. The compiler reports errors on stdout and the linker on
+// stderr, so each stream is formatted separately and written to the
+// builder's stream of the same name.
+func runTool(cmd *exec.Cmd) error {
+	_, err := runToolOutput(cmd)
+	return err
+}
+
+// runToolOutput is like runTool, but it also returns the tool's stdout and
+// stderr, concatenated, so the caller can explain errors further.
+func runToolOutput(cmd *exec.Cmd) ([]byte, error) {
+	printExplainedCommand(cmd)
+	origArgs := cmd.Args
+	runArgs, respPath, err := responseFileArgs(cmd.Path, origArgs)
+	if err != nil {
+		return nil, err
+	}
+	if respPath != "" {
+		defer os.Remove(respPath)
+		// Restore the arguments afterward, so crash reports and error
+		// messages show the real command.
+		cmd.Args = runArgs
+		defer func() { cmd.Args = origArgs }()
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	combined := func() []byte {
+		return append(append([]byte(nil), stdout.Bytes()...), stderr.Bytes()...)
+	}
+	cmd.Env = toolEnv()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	for attempt := 1; attempt <= toolRetries && err != nil && isTransientToolError(combined(), err); attempt++ {
+		// Output from a failed attempt isn't printed, since the error is
+		// probably not the tool's fault, and a retry may succeed. Retries
+		// run without flags that only make the tool print more.
+		delay := time.Duration(100<<uint(attempt-1)) * time.Millisecond
+		quietArgs, dropped := quietToolArgs(origArgs)
+		without := ""
+		if len(dropped) > 0 {
+			without = " without " + strings.Join(dropped, " ")
+		}
+		log.Printf("warning: %s failed with what looks like a transient error (%v); retrying%s in %v (retry %d of %d)", filepath.Base(cmd.Path), transientReason(combined(), err), without, delay, attempt, toolRetries)
+		time.Sleep(delay)
+		retryArgs, retryRespPath, rerr := responseFileArgs(cmd.Path, quietArgs)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if retryRespPath != "" {
+			defer os.Remove(retryRespPath)
+		}
+		retry := exec.Command(cmd.Path)
+		retry.Args = retryArgs
+		retry.Env = cmd.Env
+		retry.Dir = cmd.Dir
+		stdout.Reset()
+		stderr.Reset()
+		retry.Stdout = stdout
+		retry.Stderr = stderr
+		err = retry.Run()
+	}
+	writeDiagnostics(io.MultiWriter(os.Stdout, events.stderrWriter()), stdout.Bytes(), diagOpts)
+	writeDiagnostics(io.MultiWriter(os.Stderr, events.stderrWriter()), stderr.Bytes(), diagOpts)
+	out := combined()
+	if logErr := appendFullLog(out); logErr != nil && err == nil {
+		err = logErr
+	}
+	return out, err
+}
+
+// quietToolArgs returns a tool command line without flags that only make
+// the tool print more, like -v and the compiler's -m, and the flags that
+// were removed. A machine that's short of memory or file descriptors is
+// more likely to get through a retry without them, at the cost of
+// diagnostics the action asked for.
+func quietToolArgs(args []string) (quiet, dropped []string) {
+	quiet = make([]string, 0, len(args))
+	for i, arg := range args {
+		if i > 0 && isVerboseToolFlag(arg) {
+			dropped = append(dropped, arg)
+			continue
+		}
+		quiet = append(quiet, arg)
+	}
+	return quiet, dropped
+}
+
+// isVerboseToolFlag returns whether arg is a flag that only makes a tool
+// print more.
+func isVerboseToolFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name = name[:i]
+	}
+	return name == "v" || name == "m"
+}
+
+// responseFileArgs returns a tool command line with the arguments moved to
+// a response file, if the command line is too long to pass directly and
+// the tool reads response files. respPath is the response file, which the
+// caller should remove, or "" if args are returned unchanged.
+func responseFileArgs(toolPath string, args []string) (runArgs []string, respPath string, err error) {
+	if commandLineLen(args) <= maxCommandLineLen || !supportsResponseFiles(toolPath) {
+		return args, "", nil
+	}
+	respPath, err = writeResponseFile(args[1:])
+	if err != nil {
+		return nil, "", err
+	}
+	return []string{args[0], "@" + respPath}, respPath, nil
+}
+
+// maxCommandLineLen is the longest command line the builder passes to a tool
+// directly. Longer command lines are written to response files. This is
+// below the Windows limit of 32767 characters; Unix limits are higher.
+const maxCommandLineLen = 30000
+
+// commandLineLen returns the approximate length of a command line.
+func commandLineLen(args []string) int {
+	n := 0
+	for _, arg := range args {
+		n += len(arg) + 1
+	}
+	return n
+}
+
+// supportsResponseFiles returns whether a tool reads arguments from a file
+// named with an @ prefix. The compiler, assembler, and linker do; pack
+// doesn't.
+func supportsResponseFiles(toolPath string) bool {
+	switch strings.TrimSuffix(filepath.Base(toolPath), ".exe") {
+	case "compile", "asm", "link":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeResponseFile writes arguments to a temporary file in the format the
+// Go tools expect: one argument per line, with backslashes and newlines
+// escaped.
+func writeResponseFile(args []string) (string, error) {
+	f, err := createTempFile("args")
+	if err != nil {
+		return "", err
+	}
+	b := &strings.Builder{}
+	for _, arg := range args {
+		arg = strings.Replace(arg, "\\", "\\\\", -1)
+		arg = strings.Replace(arg, "\n", "\\n", -1)
+		b.WriteString(arg)
+		b.WriteByte('\n')
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,105 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTransientReason(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		out  string
+		err  error
+		want string
+	}{
+		{
+			desc: "etxtbsy",
+			err:  &os.PathError{Op: "fork/exec", Path: "/go/pkg/tool/link", Err: syscall.ETXTBSY},
+			want: syscall.ETXTBSY.Error(),
+		}, {
+			desc: "emfile",
+			err:  &os.SyscallError{Syscall: "pipe", Err: syscall.EMFILE},
+			want: syscall.EMFILE.Error(),
+		}, {
+			desc: "output",
+			out:  "link: open b.a: too many open files\n",
+			err:  errors.New("exit status 2"),
+			want: "too many open files",
+		}, {
+			desc: "compile_error",
+			out:  "a.go:3:1: syntax error: non-declaration statement outside function body\n",
+			err:  errors.New("exit status 2"),
+		}, {
+			desc: "other_errno",
+			err:  &os.PathError{Op: "fork/exec", Path: "/go/pkg/tool/link", Err: syscall.ENOENT},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := transientReason([]byte(tc.out), tc.err); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+			if got, want := isTransientToolError([]byte(tc.out), tc.err), tc.want != ""; got != want {
+				t.Errorf("isTransientToolError: got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestQuietToolArgs(t *testing.T) {
+	args := []string{"link", "-v", "-o", "out", "-m=2", "--v", "-vet", "-importcfg", "cfg", "main.a"}
+	quiet, dropped := quietToolArgs(args)
+	if want := []string{"link", "-o", "out", "-vet", "-importcfg", "cfg", "main.a"}; !reflect.DeepEqual(quiet, want) {
+		t.Errorf("got args %q; want %q", quiet, want)
+	}
+	if want := []string{"-v", "-m=2", "--v"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("got dropped flags %q; want %q", dropped, want)
+	}
+}
+
+// TestRunToolRetry runs a tool that fails with a transient error the first
+// time and checks that it's retried without verbose flags.
+func TestRunToolRetry(t *testing.T) {
+	defer func(old int) { toolRetries = old }(toolRetries)
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	dir, err := ioutil.TempDir("", "TestRunToolRetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	toolPath := filepath.Join(dir, "tool")
+	countPath := filepath.Join(dir, "count")
+	script := `#!/bin/sh
+if [ ! -e "` + countPath + `" ]; then
+  touch "` + countPath + `"
+  echo "tool: fork/exec: text file busy" >&2
+  exit 1
+fi
+echo "$@"
+`
+	if err := ioutil.WriteFile(toolPath, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	toolRetries = 1
+	out, err := runToolOutput(exec.Command(toolPath, "-v", "-o", "out"))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "-o out"; got != want {
+		t.Errorf("retry got args %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// undefinedRe matches linker errors about references to undefined symbols,
+// for example, "main.main: relocation target example.com/a.F not defined".
+var undefinedRe = regexp.MustCompile(`relocation target (\S+) not defined`)
+
+// explainUndefined returns notes explaining undefined symbols reported in
+// linker output. Each symbol is mapped to the package that should define
+// it. If that package isn't linked, the note says so; otherwise, it names
+// the target that provided the package, which must be missing the
+// definition. archiveMap maps package paths to the archives passed to the
+// linker, and graph provides target labels.
+func explainUndefined(out []byte, archiveMap map[string]string, graph depGraph) []string {
+	symsByPkg := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, m := range undefinedRe.FindAllSubmatch(out, -1) {
+		sym := string(m[1])
+		if seen[sym] {
+			continue
+		}
+		seen[sym] = true
+		pkgPath, name, err := splitSymbol(trimSymbolKind(sym))
+		if err != nil || pkgPath == "" {
+			continue
+		}
+		symsByPkg[pkgPath] = append(symsByPkg[pkgPath], name)
+	}
+
+	pkgPaths := make([]string, 0, len(symsByPkg))
+	for pkgPath := range symsByPkg {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+	var notes []string
+	missingSeen := make(map[string]bool)
+	for _, m := range missingPackageRe.FindAllSubmatch(out, -1) {
+		pkgPath := string(m[1])
+		if !missingSeen[pkgPath] {
+			missingSeen[pkgPath] = true
+			notes = append(notes, fmt.Sprintf("note: package %s is not linked; %s", pkgPath, explainNotLinked(pkgPath, graph)))
+		}
+	}
+	for _, pkgPath := range pkgPaths {
+		names := strings.Join(symsByPkg[pkgPath], ", ")
+		switch {
+		case archiveMap[pkgPath] == "":
+			notes = append(notes, fmt.Sprintf("note: %s is referenced, but package %s is not linked; %s", names, pkgPath, explainNotLinked(pkgPath, graph)))
+		case graph.labels[pkgPath] != "":
+			notes = append(notes, fmt.Sprintf("note: %s is referenced, but package %s from %s doesn't define it; check that its sources (including assembly) weren't excluded by build constraints", names, pkgPath, graph.labels[pkgPath]))
+		default:
+			notes = append(notes, fmt.Sprintf("note: %s is referenced, but package %s (%s) doesn't define it; it may have been compiled from different sources than its importers expect", names, pkgPath, archiveMap[pkgPath]))
+		}
+	}
+	return notes
+}
+
+// missingPackageRe matches linker errors about imported packages that
+// aren't in the importcfg.
+var missingPackageRe = regexp.MustCompile(`cannot find package (\S+) \(using -importcfg\)`)
+
+// explainNotLinked says who should provide a package that isn't linked. If
+// a dependency in the closure imports it, that dependency's target is
+// missing a dependency. Otherwise, the binary itself needs one.
+func explainNotLinked(pkgPath string, graph depGraph) string {
+	importers := graph.importers(pkgPath)
+	if len(importers) == 0 {
+		return "add a dependency on a library that provides it"
+	}
+	for i, imp := range importers {
+		importers[i] = describePackage(imp, graph)
+	}
+	return fmt.Sprintf("it's imported by %s, which should depend on a library that provides it", strings.Join(importers, ", "))
+}
+
+// trimSymbolKind removes prefixes that the compiler adds to names of
+// symbols derived from a Go declaration, such as type descriptors, so the
+// rest can be split into a package path and name.
+func trimSymbolKind(sym string) string {
+	for _, prefix := range []string{"type.", "type:", "go.info.", "go:info."} {
+		if strings.HasPrefix(sym, prefix) {
+			return strings.TrimLeft(sym[len(prefix):], "*")
+		}
+	}
+	return sym
+}
@@ -17,6 +17,16 @@ GoLibraryInfo = provider(
         Has the following fields:
             importpath: Name by which the library may be imported.
             archive: The .a file compiled from the library's sources.
+            export: An archive containing only the library's export data.
+                This is enough to compile packages that import the library,
+                but not to link them, so go_compile passes it to importers
+                instead of the archive.
+            abi: A file recording the export data of each import the library
+                was compiled against. Linking checks it, so archives from
+                mismatched caches or configurations are detected. May be None.
+            label: The label of the library target, used in error messages.
+            dep_importpaths: Import paths of the library's direct
+                dependencies, used to detect import cycles.
         """,
         "deps": "A depset of info structs for this library's dependencies",
     },
@@ -33,10 +43,29 @@ GoToolchainInfo = provider(
 
         Args:
             ctx: analysis context.
-            srcs: list of source Files to be compiled.
+            srcs: list of source Files to be compiled. May include assembly
+                (.s) files and headers (.h) they include.
             out: output .a file.
             importpath: the path other libraries may use to import this package.
             deps: list of GoLibraryInfo objects for direct dependencies.
+            export: optional output File for an archive containing only
+                export data.
+            objdir: optional output directory for the archive's object files.
+            metadata: optional output File for a JSON description of the
+                compiled package.
+            abi: optional output File for digests of the export data each
+                import was compiled against.
+            asm_out: optional output File for the compiler's assembly
+                listing.
+            forbid: list of features the sources may not use: "cgo",
+                "linkname", or "unsafe".
+            allowed_imports: list of import path patterns like "net" or
+                "net/...". If not empty, every import must match one.
+            denied_imports: list of import path patterns the sources may not
+                import. These take precedence over allowed_imports.
+            optimization: optimization level: "0", "1", "2", or "s". If
+                empty, the level depends on --compilation_mode.
+            inline: whether the compiler may inline functions.
         """,
         "link": """Function that links a Go executable.
 
@@ -45,6 +74,10 @@ GoToolchainInfo = provider(
             out: ouptut executable file.
             main: archive File for the main package.
             deps: list of GoLibraryInfo objects for direct dependencies.
+            main_abi: optional ABI File written when the main package was
+                compiled.
+            link_map: optional JSON File to write describing the
+                executable's package init order and symbols.
         """,
         "build_test": """Function that compiles and links a test executable.
 
@@ -55,6 +88,37 @@ GoToolchainInfo = provider(
             out: output executable file.
             importpath: import path of the internal test archive.
             rundir: directory the test should change to before executing.
+            optimization: optimization level: "0", "1", "2", or "s". If
+                empty, the level depends on --compilation_mode.
+            inline: whether the compiler may inline functions.
+        """,
+        "embed_data": """Function that generates a Go source file containing
+        the contents of other files.
+
+        Args:
+            ctx: analysis context.
+            srcs: list of Files to embed.
+            out: output .go File.
+            package: name of the package the generated file belongs to.
+            var: name of the variable holding the contents.
+            use_map: whether var is a map from file names to contents.
+            flatten: with use_map, use base names of files as keys.
+            string: whether contents are strings instead of byte slices.
+            gzip: whether contents are gzip-compressed.
+            bindata: optional go-bindata manifest File. If set, the
+                generated file has go-bindata's API instead of a variable.
+        """,
+        "pack_layer": """Function that packs an executable and its runfiles
+        into a reproducible tar file for a container image layer.
+
+        Args:
+            ctx: analysis context.
+            out: output .tar File.
+            binary: executable File.
+            runfiles: dict mapping runfiles paths to Files.
+            data_manifest: optional data manifest File, written next to
+                the executable.
+            dir: absolute directory in the image where binary is written.
         """,
     },
 )
@@ -36,6 +36,7 @@ def _go_download_impl(ctx):
         "{exe}": ".exe" if ctx.attr.goos == "windows" else "",
         "{exec_constraints}": constraint_str,
         "{target_constraints}": constraint_str,
+        "{builder}": ":builder_stage2" if ctx.attr.self_hosted_builder else ":builder",
     }
     ctx.template(
         "BUILD.bazel",
@@ -64,6 +65,12 @@ go_download = repository_rule(
             values = ["amd64"],
             doc = "Host architecture for the Go distribution",
         ),
+        "self_hosted_builder": attr.bool(
+            doc = ("Whether the toolchain uses a builder built by the " +
+                   "bootstrap builder with the builder's own actions, " +
+                   "instead of the bootstrap builder itself. The new " +
+                   "builder must reproduce itself exactly."),
+        ),
         "_build_tpl": attr.label(
             default = "@rules_go_simple//internal:BUILD.dist.bazel.tpl",
         ),
@@ -10,6 +10,7 @@ dependencies of a library) and create a plan of how to build it (output files,
 actions).
 """
 
+load("@bazel_skylib//lib:paths.bzl", "paths")
 load("@bazel_skylib//lib:shell.bzl", "shell")
 load(":providers.bzl", "GoLibraryInfo")
 
@@ -21,11 +22,22 @@ def _go_binary_impl(ctx):
     # our output files will start with a prefix to avoid conflicting with
     # other rules.
     main_archive = ctx.actions.declare_file("{name}_/main.a".format(name = ctx.label.name))
+    main_abi = ctx.actions.declare_file("{name}_/main.abi".format(name = ctx.label.name))
+    asm_out = None
+    if ctx.attr.emit_asm:
+        asm_out = ctx.actions.declare_file("{name}_/main.s".format(name = ctx.label.name))
     go_toolchain.compile(
         ctx,
         srcs = ctx.files.srcs,
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = main_archive,
+        abi = main_abi,
+        asm_out = asm_out,
+        forbid = ctx.attr.forbid,
+        allowed_imports = ctx.attr.allowed_imports,
+        denied_imports = ctx.attr.denied_imports,
+        optimization = ctx.attr.optimization,
+        inline = ctx.attr.inline,
     )
 
     # Declare an output file for the executable and link it. Note that output
@@ -33,21 +45,63 @@ def _go_binary_impl(ctx):
     # prefix here.
     executable_path = "{name}_/{name}".format(name = ctx.label.name)
     executable = ctx.actions.declare_file(executable_path)
+    link_map = None
+    if ctx.attr.link_map:
+        link_map = ctx.actions.declare_file("{name}_/{name}.linkmap.json".format(name = ctx.label.name))
     go_toolchain.link(
         ctx,
         main = main_archive,
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = executable,
+        x_defs = ctx.attr.x_defs,
+        stamp = ctx.attr.stamp,
+        main_abi = main_abi,
+        link_map = link_map,
     )
 
+    # Write a manifest listing data files, so the runfiles library can find
+    # them. The manifest is itself a data file.
+    runfiles = ctx.runfiles(collect_data = True)
+    manifest = _write_data_manifest(ctx, executable, runfiles)
+    runfiles = runfiles.merge(ctx.runfiles(files = [manifest]))
+
+    # Declare a container image layer with the binary and its runfiles. It's
+    # only built if the layer output group is requested.
+    layer = ctx.actions.declare_file("{name}_/{name}.layer.tar".format(name = ctx.label.name))
+    go_toolchain.pack_layer(
+        ctx,
+        out = layer,
+        binary = executable,
+        runfiles = {_runfiles_path(ctx, f): f for f in runfiles.files.to_list()},
+        data_manifest = manifest,
+        dir = ctx.attr.layer_dir,
+    )
+
+    # If there's a launcher, the executable Bazel runs is a script that runs
+    # the binary with it.
+    files = [executable]
+    if ctx.attr.launcher:
+        launcher_script = _write_launcher(ctx, executable)
+        runfiles = runfiles.merge(ctx.runfiles(files = [executable]))
+        runfiles = runfiles.merge(ctx.attr.launcher[DefaultInfo].default_runfiles)
+        files.append(launcher_script)
+        executable = launcher_script
+
     # Return the DefaultInfo provider. This tells Bazel what files should be
     # built when someone asks to build a go_binary rule. It also says which
     # file is executable (in this case, there's only one).
-    return [DefaultInfo(
-        files = depset([executable]),
-        runfiles = ctx.runfiles(collect_data = True),
-        executable = executable,
-    )]
+    return [
+        DefaultInfo(
+            files = depset(files),
+            runfiles = runfiles,
+            executable = executable,
+        ),
+        OutputGroupInfo(
+            asm = depset([asm_out] if asm_out else []),
+            layer = depset([layer]),
+            link_map = depset([link_map] if link_map else []),
+        ),
+    ]
 
 # Declare the go_binary rule. This statement is evaluated during the loading
 # phase when this file is loaded. The function body above is evaluated only
@@ -56,8 +110,9 @@ go_binary = rule(
     _go_binary_impl,
     attrs = {
         "srcs": attr.label_list(
-            allow_files = [".go"],
-            doc = "Source files to compile for the main package of this binary",
+            allow_files = [".go", ".s", ".h"],
+            doc = ("Source files to compile for the main package of this " +
+                   "binary. May include assembly files and headers."),
         ),
         "deps": attr.label_list(
             providers = [GoLibraryInfo],
@@ -67,12 +122,136 @@ go_binary = rule(
             allow_files = True,
             doc = "Data files available to this binary at run-time",
         ),
+        "forbid": attr.string_list(
+            doc = ("Features the sources may not use: \"cgo\", " +
+                   "\"linkname\", or \"unsafe\". Compilation fails if " +
+                   "any are used."),
+        ),
+        "allowed_imports": attr.string_list(
+            doc = ("Import path patterns the sources may import, like " +
+                   "\"net\" or \"net/...\". If set, every import must " +
+                   "match one."),
+        ),
+        "denied_imports": attr.string_list(
+            doc = ("Import path patterns the sources may not import. " +
+                   "These take precedence over allowed_imports."),
+        ),
+        "optimization": attr.string(
+            values = ["", "0", "1", "2", "s"],
+            doc = ("Optimization level: \"0\" disables optimization and " +
+                   "inlining, \"1\" disables inlining, \"2\" is the " +
+                   "compiler's default, and \"s\" optimizes for size. " +
+                   "By default, optimization is disabled with " +
+                   "--compilation_mode=dbg."),
+        ),
+        "inline": attr.bool(
+            default = True,
+            doc = "Whether the compiler may inline functions",
+        ),
+        "emit_asm": attr.bool(
+            doc = ("Whether to write the compiler's assembly listing for " +
+                   "the package to the asm output group"),
+        ),
+        "x_defs": attr.string_dict(
+            doc = ("Values of string variables to set when linking, keyed " +
+                   "by qualified name (packagepath.name). Like " +
+                   "-ldflags=-X in the go command."),
+        ),
+        "stamp": attr.bool(
+            default = False,
+            doc = ("Whether to append information about the builder, " +
+                   "toolchain, and target platform to the binary. The " +
+                   "builder's version subcommand prints it."),
+        ),
+        "link_map": attr.bool(
+            doc = ("Whether to write a JSON file to the link_map output " +
+                   "group recording the binary's package init order and " +
+                   "the package and address of each symbol, so a host " +
+                   "that loads the binary can check its layout."),
+        ),
+        "launcher": attr.label(
+            executable = True,
+            cfg = "host",
+            doc = ("Program that runs the binary, like an emulator " +
+                   "(qemu-aarch64) or a wasm runtime (wasmtime). If set, " +
+                   "the executable is a script that runs the launcher " +
+                   "with launcher_args, the binary, and its own " +
+                   "arguments, with RUNFILES_DIR set, so 'bazel run' " +
+                   "works for binaries that can't run on the host."),
+        ),
+        "launcher_args": attr.string_list(
+            doc = ("Arguments passed to the launcher before the binary. " +
+                   "$(location) is expanded for the launcher and data."),
+        ),
+        "layer_dir": attr.string(
+            default = "/app",
+            doc = ("Absolute directory where the binary is written in the " +
+                   "tar file in the layer output group. Runfiles are " +
+                   "written to its .runfiles directory. The tar file has " +
+                   "fixed timestamps and owners, so it can be used as a " +
+                   "reproducible container image layer."),
+        ),
     },
     doc = "Builds an executable program from Go source code",
     executable = True,
     toolchains = ["@rules_go_simple//:toolchain_type"],
 )
 
+def _write_launcher(ctx, executable):
+    """Writes a shell script that runs executable with ctx.attr.launcher,
+    for example, an emulator like qemu-aarch64 or a wasm runtime like
+    wasmtime. Both are found in the runfiles directory, which is exported
+    as RUNFILES_DIR so the binary can find its data files."""
+    script = ctx.actions.declare_file(
+        executable.basename + "_launcher.sh",
+        sibling = executable,
+    )
+    launcher = ctx.executable.launcher
+    args = [
+        ctx.expand_location(arg, [ctx.attr.launcher] + ctx.attr.data)
+        for arg in ctx.attr.launcher_args
+    ]
+    content = """#!/bin/sh
+# Generated by go_binary. Runs {binary} with {launcher}.
+set -e
+if [ -z "$RUNFILES_DIR" ]; then
+  if [ -n "$TEST_SRCDIR" ]; then
+    RUNFILES_DIR="$TEST_SRCDIR"
+  elif [ -d "$0.runfiles" ]; then
+    RUNFILES_DIR="$0.runfiles"
+  else
+    echo "$0: could not locate runfiles directory" >&2
+    exit 1
+  fi
+fi
+export RUNFILES_DIR
+exec "$RUNFILES_DIR"/{launcher} {args} "$RUNFILES_DIR"/{binary} "$@"
+""".format(
+        launcher = shell.quote(_runfiles_path(ctx, launcher)),
+        binary = shell.quote(_runfiles_path(ctx, executable)),
+        args = " ".join([shell.quote(arg) for arg in args]),
+    )
+    ctx.actions.write(script, content, is_executable = True)
+    return script
+
+def _write_data_manifest(ctx, executable, runfiles):
+    """Writes a file next to an executable listing the runfiles paths of
+    its data files, one per line. Runfiles paths start with the name of the
+    workspace containing the file."""
+    manifest = ctx.actions.declare_file(
+        executable.basename + ".data_manifest",
+        sibling = executable,
+    )
+    lines = [_runfiles_path(ctx, f) + "\n" for f in runfiles.files.to_list()]
+    ctx.actions.write(manifest, "".join(lines))
+    return manifest
+
+def _runfiles_path(ctx, f):
+    # Files in external repositories have short paths like "../repo/path".
+    if f.short_path.startswith("../"):
+        return f.short_path[len("../"):]
+    return ctx.workspace_name + "/" + f.short_path
+
 def _go_tool_binary_impl(ctx):
     # Locate the go command. We use it to invoke the compiler and linker.
     go_cmd = None
@@ -139,30 +318,202 @@ will be compiled, and they may only depend on the standard library.
     executable = True,
 )
 
+def _go_builder_binary_impl(ctx):
+    # Locate the go command and the tools the builder wraps. This rule can't
+    # depend on the Go toolchain, since the toolchain may use its output.
+    go_cmd = None
+    compiler = None
+    linker = None
+    for f in ctx.files.tools:
+        if f.path.endswith("/bin/go") or f.path.endswith("/bin/go.exe"):
+            go_cmd = f
+        elif "/pkg/tool/" in f.path and f.basename in ("compile", "compile.exe"):
+            compiler = f
+        elif "/pkg/tool/" in f.path and f.basename in ("link", "link.exe"):
+            linker = f
+    if not go_cmd or not compiler or not linker:
+        fail("could not locate Go command, compiler, or linker")
+    env = {"GOROOT": paths.dirname(paths.dirname(go_cmd.path))}
+
+    # The bootstrap builder generates the standard library importcfg, then
+    # builds the builder sources with its own compile and link actions.
+    stdimportcfg = ctx.actions.declare_file(ctx.label.name + ".importcfg")
+    ctx.actions.run(
+        outputs = [stdimportcfg],
+        inputs = ctx.files.tools + ctx.files.std_pkgs,
+        arguments = ["stdimportcfg", "-o", stdimportcfg.path, "-go", go_cmd.path],
+        env = env,
+        executable = ctx.executable.bootstrap,
+        mnemonic = "GoStdImportcfg",
+    )
+    tools = struct(
+        env = env,
+        compiler = compiler,
+        linker = linker,
+        stdimportcfg = stdimportcfg,
+        inputs = ctx.files.tools + ctx.files.std_pkgs + [stdimportcfg],
+    )
+    executable_path = "{name}_/{name}".format(name = ctx.label.name)
+    executable = _build_builder(ctx, ctx.executable.bootstrap, executable_path, tools)
+
+    # The new builder rebuilds itself. Its link action fails unless the
+    # result is identical to the builder that ran it.
+    files = [executable]
+    if ctx.attr.verify:
+        files.append(_build_builder(
+            ctx,
+            executable,
+            executable_path + ".stage3",
+            tools,
+            expect = executable,
+        ))
+
+    return [DefaultInfo(
+        files = depset(files),
+        executable = executable,
+    )]
+
+def _build_builder(ctx, builder, out_path, tools, expect = None):
+    out = ctx.actions.declare_file(out_path)
+    archive = ctx.actions.declare_file(out_path + ".a")
+    compile_args = ctx.actions.args()
+    compile_args.add("compile")
+    compile_args.add("-stdimportcfg", tools.stdimportcfg)
+    compile_args.add("-compiler", tools.compiler)
+    compile_args.add("-p", "main")
+    compile_args.add("-o", archive)
+    compile_args.add_all(ctx.files.srcs)
+    ctx.actions.run(
+        outputs = [archive],
+        inputs = tools.inputs + ctx.files.srcs,
+        arguments = [compile_args],
+        env = tools.env,
+        executable = builder,
+        mnemonic = "GoBuilderCompile",
+    )
+
+    link_args = ctx.actions.args()
+    link_args.add("link")
+    link_args.add("-stdimportcfg", tools.stdimportcfg)
+    link_args.add("-linker", tools.linker)
+    link_args.add("-main", archive)
+    link_args.add("-o", out)
+    inputs = tools.inputs + [archive]
+    if expect:
+        link_args.add("-expect", expect)
+        inputs.append(expect)
+    ctx.actions.run(
+        outputs = [out],
+        inputs = inputs,
+        arguments = [link_args],
+        env = tools.env,
+        executable = builder,
+        mnemonic = "GoBuilderLink",
+    )
+    return out
+
+go_builder_binary = rule(
+    implementation = _go_builder_binary_impl,
+    attrs = {
+        "srcs": attr.label_list(
+            allow_files = [".go"],
+            mandatory = True,
+            doc = "Source files of the builder",
+        ),
+        "bootstrap": attr.label(
+            mandatory = True,
+            executable = True,
+            cfg = "host",
+            doc = "Builder that compiles and links srcs, usually built with go_tool_binary",
+        ),
+        "tools": attr.label_list(
+            allow_files = True,
+            mandatory = True,
+            doc = "Executable files that are part of a Go distribution",
+        ),
+        "std_pkgs": attr.label_list(
+            allow_files = True,
+            mandatory = True,
+            doc = "Pre-compiled standard library packages that are part of a Go distribution",
+        ),
+        "verify": attr.bool(
+            default = True,
+            doc = ("Whether the new builder should rebuild itself and check " +
+                   "that the result is identical"),
+        ),
+    },
+    doc = """Builds the builder with another builder.
+
+This is the second stage of a two-stage bootstrap. go_tool_binary builds a
+minimal builder with the go command; go_builder_binary then uses it to build
+the builder sources with the builder's own compile and link actions, so
+changes to the builder are exercised by the binary that the toolchain uses.
+With verify set, the new builder builds itself once more, and the build fails
+if the result differs.
+""",
+    executable = True,
+)
+
 def _go_library_impl(ctx):
     # Load the toolchain.
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
     # Declare an output file for the library package and compile it from srcs.
+    # The compile action also splits the archive into export data, which is
+    # all importers need, and the object files, which only the linker needs,
+    # and describes the package in a metadata file for IDEs and other tools.
+    # Importers are compiled against the export data alone, so they read
+    # less, and a change that doesn't affect export data (like an edit to a
+    # function body the compiler won't inline) doesn't invalidate them.
     archive = ctx.actions.declare_file("{name}_/pkg.a".format(name = ctx.label.name))
+    export = ctx.actions.declare_file("{name}_/pkg.x".format(name = ctx.label.name))
+    objdir = ctx.actions.declare_directory("{name}_/obj".format(name = ctx.label.name))
+    metadata = ctx.actions.declare_file("{name}_/pkg.json".format(name = ctx.label.name))
+    abi = ctx.actions.declare_file("{name}_/pkg.abi".format(name = ctx.label.name))
+    asm_out = None
+    if ctx.attr.emit_asm:
+        asm_out = ctx.actions.declare_file("{name}_/pkg.s".format(name = ctx.label.name))
     toolchain.compile(
         ctx,
         srcs = ctx.files.srcs,
         importpath = ctx.attr.importpath,
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = archive,
+        export = export,
+        objdir = objdir,
+        metadata = metadata,
+        abi = abi,
+        asm_out = asm_out,
+        forbid = ctx.attr.forbid,
+        allowed_imports = ctx.attr.allowed_imports,
+        denied_imports = ctx.attr.denied_imports,
+        optimization = ctx.attr.optimization,
+        inline = ctx.attr.inline,
     )
 
-    # Return the output file and metadata about the library.
+    # Return the output file and metadata about the library. Each output is
+    # also available in its own output group, so other rules and aspects can
+    # request exactly the files they need.
     return [
         DefaultInfo(
             files = depset([archive]),
             runfiles = ctx.runfiles(collect_data = True),
         ),
+        OutputGroupInfo(
+            archive = depset([archive]),
+            export = depset([export]),
+            objects = depset([objdir]),
+            metadata = depset([metadata]),
+            asm = depset([asm_out] if asm_out else []),
+        ),
         GoLibraryInfo(
             info = struct(
                 importpath = ctx.attr.importpath,
                 archive = archive,
+                export = export,
+                abi = abi,
+                label = str(ctx.label),
+                dep_importpaths = [dep[GoLibraryInfo].info.importpath for dep in ctx.attr.deps],
             ),
             deps = depset(
                 direct = [dep[GoLibraryInfo].info for dep in ctx.attr.deps],
@@ -175,8 +526,8 @@ go_library = rule(
     _go_library_impl,
     attrs = {
         "srcs": attr.label_list(
-            allow_files = [".go"],
-            doc = "Source files to compile",
+            allow_files = [".go", ".s", ".h"],
+            doc = "Source files to compile. May include assembly files and headers.",
         ),
         "deps": attr.label_list(
             providers = [GoLibraryInfo],
@@ -186,6 +537,36 @@ go_library = rule(
             allow_files = True,
             doc = "Data files available to binaries using this library",
         ),
+        "forbid": attr.string_list(
+            doc = ("Features the sources may not use: \"cgo\", " +
+                   "\"linkname\", or \"unsafe\". Compilation fails if " +
+                   "any are used."),
+        ),
+        "allowed_imports": attr.string_list(
+            doc = ("Import path patterns the sources may import, like " +
+                   "\"net\" or \"net/...\". If set, every import must " +
+                   "match one."),
+        ),
+        "denied_imports": attr.string_list(
+            doc = ("Import path patterns the sources may not import. " +
+                   "These take precedence over allowed_imports."),
+        ),
+        "optimization": attr.string(
+            values = ["", "0", "1", "2", "s"],
+            doc = ("Optimization level: \"0\" disables optimization and " +
+                   "inlining, \"1\" disables inlining, \"2\" is the " +
+                   "compiler's default, and \"s\" optimizes for size. " +
+                   "By default, optimization is disabled with " +
+                   "--compilation_mode=dbg."),
+        ),
+        "inline": attr.bool(
+            default = True,
+            doc = "Whether the compiler may inline functions",
+        ),
+        "emit_asm": attr.bool(
+            doc = ("Whether to write the compiler's assembly listing for " +
+                   "the package to the asm output group"),
+        ),
         "importpath": attr.string(
             mandatory = True,
             doc = "Name by which the library may be imported",
@@ -195,6 +576,84 @@ go_library = rule(
     toolchains = ["@rules_go_simple//:toolchain_type"],
 )
 
+def _go_embed_data_impl(ctx):
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+
+    # A single src produces a single value. srcs produce a map.
+    if ctx.file.src and ctx.files.srcs:
+        fail("only one of src and srcs may be set")
+    if not ctx.file.src and not ctx.files.srcs:
+        fail("one of src or srcs must be set")
+    use_map = not ctx.file.src
+    srcs = ctx.files.srcs if use_map else [ctx.file.src]
+    if ctx.file.bindata and not use_map:
+        fail("bindata requires srcs")
+
+    # With bindata, the manifest's -pkg is the default.
+    package = ctx.attr.package
+    if not package and not ctx.file.bindata:
+        package = ctx.label.package.split("/")[-1] or "main"
+    out = ctx.actions.declare_file(ctx.label.name + ".go")
+    toolchain.embed_data(
+        ctx,
+        srcs = srcs,
+        out = out,
+        package = package,
+        var = ctx.attr.var,
+        use_map = use_map,
+        flatten = ctx.attr.flatten,
+        string = ctx.attr.string,
+        gzip = ctx.attr.gzip,
+        bindata = ctx.file.bindata,
+    )
+    return [DefaultInfo(files = depset([out]))]
+
+go_embed_data = rule(
+    _go_embed_data_impl,
+    attrs = {
+        "src": attr.label(
+            allow_single_file = True,
+            doc = "A single file to embed. The variable holds its contents.",
+        ),
+        "srcs": attr.label_list(
+            allow_files = True,
+            doc = ("Files to embed. The variable is a map from file " +
+                   "names (relative to the workspace root) to contents."),
+        ),
+        "package": attr.string(
+            doc = ("Name of the package the generated file belongs to. " +
+                   "Defaults to the last component of the Bazel package."),
+        ),
+        "var": attr.string(
+            default = "Data",
+            doc = "Name of the variable holding the contents",
+        ),
+        "flatten": attr.bool(
+            doc = "With srcs, use base names of files as map keys",
+        ),
+        "string": attr.bool(
+            doc = "Whether contents are strings instead of byte slices",
+        ),
+        "gzip": attr.bool(
+            doc = "Whether contents are gzip-compressed",
+        ),
+        "bindata": attr.label(
+            allow_single_file = True,
+            doc = ("A go-bindata manifest: a file holding the arguments " +
+                   "a project passed to go-bindata. If set, the generated " +
+                   "file has go-bindata's API (Asset, MustAsset, " +
+                   "AssetNames, AssetDir) instead of a variable, and asset " +
+                   "names are relative to this package's directory. " +
+                   "-ignore patterns match workspace-relative paths. " +
+                   "Eases migration from go-bindata."),
+        ),
+    },
+    doc = ("Generates a Go source file containing the contents of other " +
+           "files, for use in srcs of other Go rules. This is an " +
+           "alternative to //go:embed."),
+    toolchains = ["@rules_go_simple//:toolchain_type"],
+)
+
 def _go_test_impl(ctx):
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
@@ -207,11 +666,17 @@ def _go_test_impl(ctx):
         out = executable,
         importpath = ctx.attr.importpath,
         rundir = ctx.label.package,
+        x_defs = ctx.attr.x_defs,
+        optimization = ctx.attr.optimization,
+        inline = ctx.attr.inline,
     )
 
+    runfiles = ctx.runfiles(collect_data = True)
+    manifest = _write_data_manifest(ctx, executable, runfiles)
+
     return [DefaultInfo(
         files = depset([executable]),
-        runfiles = ctx.runfiles(collect_data = True),
+        runfiles = runfiles.merge(ctx.runfiles(files = [manifest])),
         executable = executable,
     )]
 
@@ -235,6 +700,23 @@ go_test = rule(
             default = "",
             doc = "Name by which test archives may be imported (optional)",
         ),
+        "optimization": attr.string(
+            values = ["", "0", "1", "2", "s"],
+            doc = ("Optimization level: \"0\" disables optimization and " +
+                   "inlining, \"1\" disables inlining, \"2\" is the " +
+                   "compiler's default, and \"s\" optimizes for size. " +
+                   "By default, optimization is disabled with " +
+                   "--compilation_mode=dbg."),
+        ),
+        "inline": attr.bool(
+            default = True,
+            doc = "Whether the compiler may inline functions",
+        ),
+        "x_defs": attr.string_dict(
+            doc = ("Values of string variables to set when linking, keyed " +
+                   "by qualified name (packagepath.name). Like " +
+                   "-ldflags=-X in the go command."),
+        ),
     },
     doc = """Compiles and links a Go test executable. Functions with names
 starting with "Test" in files with names ending in "_test.go" will be called
@@ -18,7 +18,9 @@ load(
     ":actions.bzl",
     "go_build_test",
     "go_compile",
+    "go_embed_data",
     "go_link",
+    "go_pack_layer",
 )
 
 def _go_toolchain_impl(ctx):
@@ -31,13 +33,24 @@ def _go_toolchain_impl(ctx):
     if not go_cmd:
         fail("could not locate go command")
     env = {"GOROOT": paths.dirname(paths.dirname(go_cmd.path))}
+    compiler = _find_tool(ctx.files.tools, "compile")
+    linker = _find_tool(ctx.files.tools, "link")
+    assembler = _find_tool(ctx.files.tools, "asm")
+    packer = _find_tool(ctx.files.tools, "pack")
 
-    # Generate the package list from the standard library.
+    # Generate the package list from the standard library. If a manifest
+    # was provided, the builder reads the list from there instead of
+    # scanning the distribution.
     stdimportcfg = ctx.actions.declare_file(ctx.label.name + ".importcfg")
+    stdimportcfg_args = ["stdimportcfg", "-o", stdimportcfg.path, "-go", go_cmd.path]
+    stdimportcfg_inputs = ctx.files.tools + ctx.files.std_pkgs
+    if ctx.file.std_manifest:
+        stdimportcfg_args += ["-manifest", ctx.file.std_manifest.path]
+        stdimportcfg_inputs += [ctx.file.std_manifest]
     ctx.actions.run(
         outputs = [stdimportcfg],
-        inputs = ctx.files.tools + ctx.files.std_pkgs,
-        arguments = ["stdimportcfg", "-o", stdimportcfg.path],
+        inputs = stdimportcfg_inputs,
+        arguments = stdimportcfg_args,
         env = env,
         executable = ctx.executable.builder,
         mnemonic = "GoStdImportcfg",
@@ -51,20 +64,46 @@ def _go_toolchain_impl(ctx):
         compile = go_compile,
         link = go_link,
         build_test = go_build_test,
+        embed_data = go_embed_data,
+        pack_layer = go_pack_layer,
 
         # Internal data. Contents may change without notice.
         # Think of these like private fields in a class. Actions may use these
         # (they are methods of the class) but rules may not (they are clients).
         internal = struct(
             go_cmd = go_cmd,
+            compiler = compiler,
+            linker = linker,
+            assembler = assembler,
+            packer = packer,
             env = env,
+            goexperiment = ctx.attr.goexperiment,
+            tool_retries = ctx.attr.tool_retries,
+            max_output_lines = ctx.attr.max_output_lines,
+            verify_input_digests = ctx.attr.verify_input_digests,
+            crash_reports = ctx.attr.crash_reports,
+            deps_manifest = ctx.attr.deps_manifest,
+            std_overlap = ctx.attr.std_overlap,
+            std_allowlist = ctx.file.std_allowlist,
             stdimportcfg = stdimportcfg,
             builder = ctx.executable.builder,
             tools = ctx.files.tools,
             std_pkgs = ctx.files.std_pkgs,
+            headers = ctx.files.headers,
         ),
     )]
 
+def _find_tool(files, name):
+    """Finds an executable in the Go distribution's pkg/tool directory.
+
+    The builder is told where each tool is, rather than searching for tools
+    itself, so all tools an action uses are declared by the toolchain.
+    """
+    for f in files:
+        if "/pkg/tool/" in f.path and f.basename in (name, name + ".exe"):
+            return f
+    fail("could not locate Go tool: " + name)
+
 go_toolchain = rule(
     implementation = _go_toolchain_impl,
     attrs = {
@@ -82,6 +121,80 @@ go_toolchain = rule(
             mandatory = True,
             doc = "Standard library packages from the Go distribution",
         ),
+        "headers": attr.label_list(
+            allow_files = True,
+            doc = "Header files from the Go distribution that assembly sources may include",
+        ),
+        "std_manifest": attr.label(
+            allow_single_file = [".json"],
+            doc = ("JSON manifest of standard library archives, produced by " +
+                   "'builder stdmanifest'. If set, the standard library " +
+                   "importcfg is generated from the manifest instead of " +
+                   "by scanning std_pkgs."),
+        ),
+        "goexperiment": attr.string(
+            doc = ("Comma-separated list of toolchain experiments, like " +
+                   "\"fieldtrack\" or \"noframepointer\", passed to the " +
+                   "compiler and linker as GOEXPERIMENT. GOEXPERIMENT is " +
+                   "not inherited from the environment. std_pkgs must be " +
+                   "built with the same experiments."),
+        ),
+        "tool_retries": attr.int(
+            doc = ("Number of times the compiler, assembler, and linker " +
+                   "are retried when they fail with a recognizable " +
+                   "transient error, like ETXTBSY or a resource limit. " +
+                   "Retries run without flags that only make tools print " +
+                   "more, like -v. Tools aren't retried by default."),
+        ),
+        "max_output_lines": attr.int(
+            doc = ("Number of lines of each tool's output the compile, " +
+                   "link, and test actions print before truncating the " +
+                   "rest. If set, the complete output is written to a " +
+                   ".log file next to each action's primary output. " +
+                   "Output isn't limited by default."),
+        ),
+        "verify_input_digests": attr.bool(
+            doc = ("Whether compile, link, and test actions check the " +
+                   "digests of their sources and dependency archives " +
+                   "against a manifest written by a separate action, " +
+                   "before running any tools. This catches sandboxes " +
+                   "with stale or wrong inputs."),
+        ),
+        "crash_reports": attr.bool(
+            doc = ("Whether compile and test actions write a tarball " +
+                   "with everything needed to reproduce a compiler crash " +
+                   "to a .crash.tar.gz file next to each action's primary " +
+                   "output. The file is empty if the compiler doesn't " +
+                   "crash."),
+        ),
+        "deps_manifest": attr.bool(
+            doc = ("Whether compile and link actions read their " +
+                   "dependencies from a JSON manifest written next to " +
+                   "each action's primary output, instead of from " +
+                   "command-line flags. The manifest records each " +
+                   "dependency's archive, export data, label, imports, " +
+                   "and whether it's direct in one place."),
+        ),
+        "std_allowlist": attr.label(
+            allow_single_file = True,
+            doc = ("Manifest of standard library import path patterns, " +
+                   "like \"strings\" or \"unicode/...\", one per line, " +
+                   "that a constrained target supports. Sources that " +
+                   "import other standard library packages fail to " +
+                   "compile. A line starting with \"reason:\" explains " +
+                   "the limitation in error messages."),
+        ),
+        "std_overlap": attr.string(
+            default = "warn",
+            values = ["warn", "error", "ignore", "replace"],
+            doc = ("What to do when a dependency provides a package with " +
+                   "the same path as a standard library package. " +
+                   "Normally the standard library archive is used; " +
+                   "\"warn\" reports the overlap, \"error\" fails the " +
+                   "build, and \"ignore\" says nothing. \"replace\" " +
+                   "uses the dependency instead, for stubs generated by " +
+                   "'builder genstubs'."),
+        ),
     },
     doc = "Gathers functions and file lists needed for a Go toolchain",
 )
@@ -0,0 +1,120 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+// Package runfiles locates data files for go_binary and go_test targets
+// at run-time.
+//
+// Bazel makes data files available in a runfiles directory next to the
+// executable (or, on platforms without symbolic links, lists them in a
+// runfiles manifest file). Files are located by their runfiles paths, which
+// start with the workspace name, for example, "my_workspace/pkg/data.txt".
+//
+// go_binary and go_test also write a data manifest next to the executable
+// listing the runfiles paths of all data files, which Data returns.
+package runfiles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path returns the location of a file, given its runfiles path.
+func Path(rpath string) (string, error) {
+	if rpath == "" || filepath.IsAbs(rpath) || strings.HasPrefix(rpath, "../") {
+		return "", fmt.Errorf("runfiles path %q must be a relative path starting with a workspace name", rpath)
+	}
+	if dir, err := Dir(); err == nil {
+		return filepath.Join(dir, filepath.FromSlash(rpath)), nil
+	}
+	if manifestPath := os.Getenv("RUNFILES_MANIFEST_FILE"); manifestPath != "" {
+		return lookupManifest(manifestPath, rpath)
+	}
+	return "", errors.New("could not locate runfiles directory or manifest")
+}
+
+// Dir returns the runfiles directory. RUNFILES_DIR and TEST_SRCDIR are
+// checked first, since Bazel sets them for tests and for binaries run by
+// other binaries. Otherwise, Dir looks for a directory next to the
+// executable ending in ".runfiles", which is where 'bazel run' puts it.
+func Dir() (string, error) {
+	for _, key := range []string{"RUNFILES_DIR", "TEST_SRCDIR"} {
+		if dir := os.Getenv(key); dir != "" {
+			return dir, nil
+		}
+	}
+	for _, exe := range executablePaths() {
+		dir := exe + ".runfiles"
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", errors.New("could not locate runfiles directory")
+}
+
+// Data returns the runfiles paths of the data files of the running
+// executable, including data files of its dependencies. The paths are read
+// from the data manifest written by go_binary or go_test.
+func Data() ([]string, error) {
+	for _, exe := range executablePaths() {
+		f, err := os.Open(exe + ".data_manifest")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var rpaths []string
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				rpaths = append(rpaths, line)
+			}
+		}
+		return rpaths, sc.Err()
+	}
+	return nil, errors.New("could not locate data manifest next to executable")
+}
+
+// executablePaths returns possible paths of the running executable. The
+// path from os.Args[0] may be a link in a runfiles tree; os.Executable
+// may resolve it to a path in the output tree.
+func executablePaths() []string {
+	var paths []string
+	if len(os.Args) > 0 && os.Args[0] != "" {
+		if abs, err := filepath.Abs(os.Args[0]); err == nil {
+			paths = append(paths, abs)
+		}
+	}
+	if exe, err := os.Executable(); err == nil && (len(paths) == 0 || exe != paths[0]) {
+		paths = append(paths, exe)
+	}
+	return paths
+}
+
+// lookupManifest finds a runfiles path in a runfiles manifest file. Each
+// line of the manifest has a runfiles path and an absolute path, separated
+// by a space.
+func lookupManifest(manifestPath, rpath string) (string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, ' '); i >= 0 && line[:i] == rpath {
+			return line[i+1:], nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: runfiles path %q not found", manifestPath, rpath)
+}
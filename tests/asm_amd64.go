@@ -0,0 +1,10 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package asm
+
+// AddOffset returns a + b + offset, where offset is defined in asm.h.
+// It's implemented in assembly.
+func AddOffset(a, b int64) int64
@@ -0,0 +1,15 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+#include "textflag.h"
+#include "asm.h"
+
+// func AddOffset(a, b int64) int64
+TEXT ·AddOffset(SB), NOSPLIT, $0-24
+	MOVQ a+0(FP), AX
+	ADDQ b+8(FP), AX
+	ADDQ $OFFSET, AX
+	MOVQ AX, ret+16(FP)
+	RET
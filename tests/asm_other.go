@@ -0,0 +1,18 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build !amd64
+// +build !amd64
+
+package asm
+
+// offset must match OFFSET in asm.h.
+const offset = 100
+
+// AddOffset returns a + b + offset. Only amd64 has an assembly
+// implementation, so the test can run on other platforms.
+func AddOffset(a, b int64) int64 {
+	return a + b + offset
+}
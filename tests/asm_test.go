@@ -0,0 +1,17 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package asm_test
+
+import (
+	"rules_go_simple/tests/asm"
+	"testing"
+)
+
+func TestAddOffset(t *testing.T) {
+	if got, want := asm.AddOffset(1, 2), int64(103); got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindataAsset(t *testing.T) {
+	data, err := Asset("embed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "embedded data\n"; string(data) != want {
+		t.Errorf("got %q; want %q", data, want)
+	}
+	if _, err := Asset("missing.txt"); err == nil {
+		t.Error("Asset(missing.txt): got nil error")
+	}
+}
+
+func TestBindataNames(t *testing.T) {
+	// foo.txt matches -ignore in the manifest.
+	if got, want := AssetNames(), []string{"embed.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AssetNames: got %q; want %q", got, want)
+	}
+	if got, err := AssetDir(""); err != nil || !reflect.DeepEqual(got, []string{"embed.txt"}) {
+		t.Errorf("AssetDir: got %q, %v", got, err)
+	}
+}
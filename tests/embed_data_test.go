@@ -0,0 +1,44 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+const embedText = "embedded data\n"
+
+func TestEmbedSingle(t *testing.T) {
+	if Embed != embedText {
+		t.Errorf("got %q; want %q", Embed, embedText)
+	}
+}
+
+func TestEmbedMap(t *testing.T) {
+	if got, ok := EmbedFiles["tests/embed.txt"]; !ok || string(got) != embedText {
+		t.Errorf("tests/embed.txt: got %q, %v; want %q", got, ok, embedText)
+	}
+	if got, ok := EmbedFiles["tests/foo.txt"]; !ok || len(got) != 0 {
+		t.Errorf("tests/foo.txt: got %q, %v; want empty", got, ok)
+	}
+}
+
+func TestEmbedGzip(t *testing.T) {
+	r, err := gzip.NewReader(bytes.NewReader(EmbedGzip["embed.txt"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != embedText {
+		t.Errorf("got %q; want %q", data, embedText)
+	}
+}
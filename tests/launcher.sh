@@ -0,0 +1,13 @@
+#!/bin/sh
+
+# Copyright Jay Conrod. All rights reserved.
+
+# This file is part of rules_go_simple. Use of this source code is governed by
+# the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+# Stands in for an emulator in launcher_test. It prints its first argument,
+# then runs the rest.
+
+echo "$1"
+shift
+exec "$@"
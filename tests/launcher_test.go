@@ -0,0 +1,28 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package launcher_test
+
+import (
+	"flag"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+var helloPath = flag.String("hello", "", "path to hello_launched launcher script")
+
+func TestLauncher(t *testing.T) {
+	cmd := exec.Command(strings.TrimPrefix(*helloPath, "tests/"))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(out)), "\n")
+	want := []string{"-launched", "Hello, world!"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"rules_go_simple/runfiles"
+)
+
+// runfiles_bin prints the runfiles path of each of its data files that it
+// can find.
+func main() {
+	rpaths, err := runfiles.Data()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, rpath := range rpaths {
+		path, err := runfiles.Path(rpath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(rpath)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunfilesBazelRun runs a binary the way 'bazel run' does: from another
+// directory, without the runfiles environment variables Bazel sets for
+// tests, with its runfiles in a directory next to it. The binary must find
+// its data files through the runfiles library anyway.
+func TestRunfilesBazelRun(t *testing.T) {
+	binPath := strings.TrimPrefix(flag.Args()[0], "tests/")
+	srcDir := os.Getenv("TEST_SRCDIR")
+	if srcDir == "" {
+		t.Fatal("TEST_SRCDIR not set")
+	}
+	srcDir, err := filepath.Abs(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "TestRunfilesBazelRun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runPath := filepath.Join(dir, "runfiles_bin")
+	for _, suffix := range []string{"", ".data_manifest"} {
+		data, err := ioutil.ReadFile(binPath + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(runPath+suffix, data, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(srcDir, runPath+".runfiles"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(runPath)
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "RUNFILES_DIR", "RUNFILES_MANIFEST_FILE", "TEST_SRCDIR":
+		default:
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("%v\n%s", err, ee.Stderr)
+		}
+		t.Fatal(err)
+	}
+
+	got := strings.Fields(string(out))
+	for _, want := range []string{"rules_go_simple/tests/foo.txt", "rules_go_simple/tests/bar.txt"} {
+		found := false
+		for _, g := range got {
+			found = found || g == want
+		}
+		if !found {
+			t.Errorf("binary did not find %q; got %q", want, got)
+		}
+	}
+}
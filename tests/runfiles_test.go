@@ -0,0 +1,35 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"rules_go_simple/runfiles"
+	"testing"
+)
+
+func TestRunfiles(t *testing.T) {
+	rpaths, err := runfiles.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "rules_go_simple/tests/foo.txt"
+	found := false
+	for _, rpath := range rpaths {
+		found = found || rpath == want
+	}
+	if !found {
+		t.Fatalf("data manifest does not list %q; got %q", want, rpaths)
+	}
+
+	path, err := runfiles.Path(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package x_defs
+
+// Version is set with x_defs when the test is linked.
+var Version = "unset"
@@ -0,0 +1,17 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"rules_go_simple/tests/x_defs"
+	"testing"
+)
+
+func TestXDefs(t *testing.T) {
+	if got, want := x_defs.Version, "1.2.3"; got != want {
+		t.Errorf("got version %q; want %q", got, want)
+	}
+}